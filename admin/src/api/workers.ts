@@ -12,6 +12,12 @@ import type {
   AccessTokenCreateRequest,
   AccessTokenListRequest,
   AccessTokenDeleteRequest,
+  AccessTokenRotateRequest,
+  AKSKCreateRequest,
+  AKSKListRequest,
+  AKSKUpdateRequest,
+  AKSKDeleteRequest,
+  ExternalServerAKSK,
   InternalWhiteListCreateRequest,
   InternalWhiteListListRequest,
   InternalWhiteListUpdateRequest,
@@ -96,6 +102,32 @@ export const deleteAccessToken = (request: AccessTokenDeleteRequest) => {
   return api.post('api/worker/access/token/delete', request).then((res) => res.data)
 }
 
+export const rotateAccessToken = (request: AccessTokenRotateRequest) => {
+  return api.post('api/worker/access/token/rotate', request).then((res) => res.data)
+}
+
+// AK/SK 相关 API
+export const createAKSK = (request: AKSKCreateRequest) => {
+  return api.post('api/worker/access/aksk/create', request).then((res) => res.data)
+}
+
+export const listAKSKs = async (request: AKSKListRequest) => {
+  return (
+    await api.post<CommonResponse<{ aksks: ExternalServerAKSK[]; total: number }>>(
+      'api/worker/access/aksk/list',
+      request,
+    )
+  ).data
+}
+
+export const updateAKSK = (request: AKSKUpdateRequest) => {
+  return api.post('api/worker/access/aksk/update', request).then((res) => res.data)
+}
+
+export const deleteAKSK = (request: AKSKDeleteRequest) => {
+  return api.post('api/worker/access/aksk/delete', request).then((res) => res.data)
+}
+
 // 定义通用响应类型
 interface CommonResponse<T> {
   code: number
@@ -22,6 +22,45 @@ export interface AccessTokenDeleteRequest {
   id: number
 }
 
+// 访问令牌轮换请求接口
+export interface AccessTokenRotateRequest {
+  worker_uid: string
+  id: number
+  // 旧 token 继续可用的秒数，为 0 时使用默认的 1 小时
+  grace_period?: number
+}
+
+// AK/SK 创建请求接口
+export interface AKSKCreateRequest {
+  worker_uid: string
+  description: string
+  // 是否永久有效，为 false 时必须提供过期时间
+  forever: boolean
+  expiration_time: string
+}
+
+// AK/SK 列表请求接口
+export interface AKSKListRequest {
+  worker_uid: string
+  page: number
+  page_size: number
+}
+
+// AK/SK 更新请求接口
+export interface AKSKUpdateRequest {
+  worker_uid: string
+  id: number
+  description: string
+  forever: boolean
+  expiration_time: string
+}
+
+// AK/SK 删除请求接口
+export interface AKSKDeleteRequest {
+  worker_uid: string
+  id: number
+}
+
 // 内部白名单创建请求接口
 export interface InternalWhiteListCreateRequest {
   // 关联的 Worker UID
@@ -91,7 +130,7 @@ export interface InternalServerWhiteList {
 // ExternalServerAKSK 实体接口
 export interface ExternalServerAKSK {
   // 自增 ID
-  id: number
+  ID: number
   // 创建时间
   created_at: string
   // 更新时间
@@ -124,18 +163,25 @@ export interface ExternalServerToken {
   deleted_at: string | null
   // 关联的 Worker UID
   worker_uid: string
-  // 令牌
+  // 令牌，只返回前三位
   token: string
+  token_prefix: string
   // 描述信息
   description: string
   // 是否永久有效
   forever: boolean
   // 过期时间
   expiration_time: string
+  // 过期时间，为空时不过期
+  expires_at: string | null
+  // 最后使用时间
+  last_used_at: string | null
+  // 轮换时间，轮换后旧 token 在过期前仍然可用
+  rotated_at: string | null
 }
 
 // AccessRule 实体接口
-export type AccessRuleType = 'internal' | 'aksk' | 'token' | 'sso' | 'open'
+export type AccessRuleType = 'internal' | 'aksk' | 'token' | 'sso' | 'open' | 'ratelimit' | 'ip' | 'jwt' | 'oidc'
 export interface AccessRule {
   // 自增 ID
   id?: number
@@ -0,0 +1,34 @@
+package authz
+
+import (
+	"errors"
+	"vvorker/defs"
+	"vvorker/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// ResourceAuthz 校验 worker 绑定中的资源凭证，凭证只能访问签发时 worker 配置中的一个资源
+// 校验通过后把资源写入上下文，处理函数只使用其中的资源，不再信任请求中的资源 ID 与连接参数
+func ResourceAuthz(resourceType string) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		token := c.Request.Header.Get(defs.HeaderResourceToken)
+		if token == "" {
+			c.AbortWithStatusJSON(401, gin.H{"message": "Unauthorized"})
+			return
+		}
+		res, err := models.GetWorkerResource(token)
+		if errors.Is(err, models.ErrResourceNotAllowed) || (err == nil && res.Type != resourceType) {
+			c.AbortWithStatusJSON(403, gin.H{"message": "Forbidden"})
+			return
+		}
+		if err != nil {
+			logrus.WithError(err).Warn("get worker resource error")
+			c.AbortWithStatusJSON(503, gin.H{"message": "Service Unavailable"})
+			return
+		}
+		c.Set(defs.KeyWorkerResource, res)
+		c.Next()
+	}
+}
@@ -33,7 +33,11 @@ func RespOK(c *gin.Context, msg string, data interface{}) {
 	c.JSON(http.StatusOK, &Response{Code: RespCodeOK, Msg: msg, Data: data})
 }
 
+// RespErrCodeKey 记录 RespErr 返回的错误码，供中间件（如监控）判断请求是否失败
+const RespErrCodeKey = "resp_err_code"
+
 func RespErr(c *gin.Context, code int, errMsg string, data interface{}) {
 	logrus.WithContext(c).Errorf(errMsg)
+	c.Set(RespErrCodeKey, code)
 	c.JSON(http.StatusOK, &Response{Code: code, Msg: errMsg, Data: data})
 }
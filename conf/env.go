@@ -3,6 +3,8 @@ package conf
 import (
 	"flag"
 	"fmt"
+	"io"
+	"os"
 	"vvorker/utils/secret"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -14,6 +16,16 @@ import (
 var EnvPath = flag.String("e", ".env", "env file path")
 var Version string
 
+// parseEnvPath 只读取 -e 参数，其他包的 init 依赖配置，不能等到 main 中调用 flag.Parse
+// 遇到未知参数时停止并使用默认值，完整的命令行由 main 解析
+func parseEnvPath(args []string) string {
+	fs := flag.NewFlagSet("conf", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	path := fs.String("e", ".env", "env file path")
+	fs.Parse(args)
+	return *path
+}
+
 type AppConfig struct {
 	MasterEndpoint string `env:"MASTER_ENDPOINT" env-default:"http://127.0.0.1:8888"` // needed for agent，agent需要通过该url来注册节点
 	WorkerPort     int    `env:"WORKER_PORT" env-default:"8080"`                      // 【主节点公开】提供worker服务，如 xxx.example.com:8080
@@ -37,10 +49,36 @@ type AppConfig struct {
 	WorkerLimit    int    `env:"WORKER_LIMIT" env-default:"10000"`
 	WorkerdBinPath string `env:"WORKERD_BIN_PATH" env-default:"/bin/workerd"`
 
+	WorkerRestartBackoffMax  int `env:"WORKER_RESTART_BACKOFF_MAX" env-default:"300"` // workerd 崩溃后重启的最大等待时间（秒），从 1 秒开始指数增长
+	WorkerCrashLoopThreshold int `env:"WORKER_CRASHLOOP_THRESHOLD" env-default:"5"`   // 连续快速退出多少次后标记为 crashloop
+	WorkerHealthCheckTimeout int `env:"WORKER_HEALTH_CHECK_TIMEOUT" env-default:"30"` // 更新时新副本通过健康检查的最长等待时间（秒），超时则保留旧副本
+	WorkerDrainTimeout       int `env:"WORKER_DRAIN_TIMEOUT" env-default:"10"`        // 流量切换到新副本后，旧副本继续处理已有请求的时间（秒）
+
+	// 日志保留策略，由主节点定期清理，设置为 0 表示不限制（默认永久保留）
+	WorkerLogRetentionDays  int `env:"WORKER_LOG_RETENTION_DAYS" env-default:"0"`  // worker 日志保留天数
+	WorkerLogRetentionCount int `env:"WORKER_LOG_RETENTION_COUNT" env-default:"0"` // 每个 worker 最多保留的日志条数
+	TaskLogRetentionDays    int `env:"TASK_LOG_RETENTION_DAYS" env-default:"0"`    // 任务日志保留天数
+	LogRetentionInterval    int `env:"LOG_RETENTION_INTERVAL" env-default:"60"`    // 清理间隔（分钟）
+
 	FileStorageUseOSS    bool   `env:"FILE_STORAGE_USE_OSS" env-default:"false"` // 文件是否使用oss存储，而不是数据库
 	FileStorageOSSBucket string `env:"FILE_STORAGE_OSS_BUCKET"`                  // oss bucket
 	FileStorageOSSPrefix string `env:"FILE_STORAGE_OSS_PREFIX"`                  // oss prefix
 
+	AssetsReleaseKeep int `env:"ASSETS_RELEASE_KEEP" env-default:"5"` // 每个 worker 保留的静态资源发布记录数，用于回滚
+
+	// 以压缩包发布静态资源时的限制，超出时拒绝整个压缩包
+	AssetsArchiveMaxSize     int64 `env:"ASSETS_ARCHIVE_MAX_SIZE" env-default:"1073741824"`     // 解压后的总大小（字节）
+	AssetsArchiveMaxFileSize int64 `env:"ASSETS_ARCHIVE_MAX_FILE_SIZE" env-default:"104857600"` // 单个文件解压后的大小（字节）
+	AssetsArchiveMaxFiles    int   `env:"ASSETS_ARCHIVE_MAX_FILES" env-default:"10000"`         // 文件数量
+
+	// 文件内容按哈希存放在 blob 存储中
+	BlobStorageType    string `env:"BLOB_STORAGE_TYPE"`                          // db、local 或 oss，为空时按 FILE_STORAGE_USE_OSS 选择 oss 或 db
+	BlobStorageDir     string `env:"BLOB_STORAGE_DIR"`                           // local 存储的目录，为空时使用 WORKERD_DIR/blobs，多节点部署时需要共享
+	BlobCacheMaxSize   int    `env:"BLOB_CACHE_MAX_SIZE" env-default:"1048576"`  // 不超过该大小（字节）的文件缓存在 sys_cache 中，更大的文件缓存在本地磁盘
+	BlobDiskCacheTTL   int    `env:"BLOB_DISK_CACHE_TTL" env-default:"24"`       // 本地磁盘缓存多久未访问后清理（小时）
+	BlobMigrateOnStart bool   `env:"BLOB_MIGRATE_ON_START" env-default:"false"`  // 启动时将旧的文件内容迁移到 blob 存储
+	MultipartMaxMemory int64  `env:"MULTIPART_MAX_MEMORY" env-default:"1048576"` // multipart 上传在内存中保留的最大字节数，超出部分写入临时文件
+
 	EncryptionKey string `env:"ENCRYPTION_KEY" env-default:""`
 
 	APIWebBaseURL  string `env:"API_WEB_BASE_URL"`
@@ -69,6 +107,11 @@ type AppConfig struct {
 	WorkerHostPath string `env:"WORKER_HOST_PATH" env-default:""`     // host 模式需要使用域名进行访问，path则url的第一段为服务名（不包含域名后缀，如example.com/xxxx/admin
 	AdminAPIProxy  bool   `env:"ADMIN_API_PROXY" env-default:"false"` // 允许admin页面代理api请求，这可能会导致路径冲突，并且WORKER_HOST_MODE必须为path
 
+	TrustedProxies      string `env:"TRUSTED_PROXIES" env-default:""`            // 可信代理的 IP 或 CIDR，逗号分隔，只有来自可信代理的 X-Forwarded-For 才会被采用
+	AKSKSignatureWindow int    `env:"AKSK_SIGNATURE_WINDOW" env-default:"300"`   // AK/SK 签名请求的时间戳允许偏差（秒），nonce 在两倍时间内不能重复使用
+	AKSKMaxBodySize     int64  `env:"AKSK_MAX_BODY_SIZE" env-default:"10485760"` // AK/SK 签名请求的最大请求体（字节），校验签名前需要读取整个请求体
+	JWKSCacheTTL        int    `env:"JWKS_CACHE_TTL" env-default:"600"`          // jwt/oidc 规则的 JWKS 与 OIDC 配置的刷新间隔（秒）
+
 	ServerRedisHost     string `env:"SERVER_REDIS_HOST" env-default:"localhost"`
 	ServerRedisPort     int    `env:"SERVER_REDIS_PORT" env-default:"6379"`
 	ServerRedisPassword string `env:"SERVER_REDIS_PASSWORD" env-default:""`
@@ -127,6 +170,11 @@ type AppConfig struct {
 	SSOCookieDomain     string `env:"SSO_COOKIE_DOMAIN" env-default:"vvorker.local"`
 	SSOCookieSecure     bool   `env:"SSO_COOKIE_SECURE" env-default:"false"`
 	SSOCookieHttpOnly   bool   `env:"SSO_COOKIE_HTTPONLY" env-default:"false"`
+	// 鉴权结果缓存与熔断，避免 sso 服务变慢时所有受保护的 worker 都被拖慢
+	SSOCacheTTL         int `env:"SSO_CACHE_TTL" env-default:"30"`        // 鉴权通过的结果缓存时间（秒），0 为不缓存
+	SSOTimeout          int `env:"SSO_TIMEOUT" env-default:"5"`           // 调用 sso 认证地址的超时时间（秒）
+	SSOBreakerThreshold int `env:"SSO_BREAKER_THRESHOLD" env-default:"5"` // 连续失败多少次后熔断，0 为不熔断
+	SSOBreakerCooldown  int `env:"SSO_BREAKER_COOLDOWN" env-default:"30"` // 熔断后多少秒再尝试调用
 
 	// 维护用
 	MAN_ASSET_FILE_REPLACE bool `env:"MAN_ASSET_FILE_REPLACE" env-default:"false"` // 每次上传文件总是替换原有文件，即使已经上传过了
@@ -139,6 +187,9 @@ type AppConfig struct {
 
 	EnableLoginOPT bool `env:"ENABLE_LOGIN_OTP" env-default:"true"` // 是否启用登录OTP验证
 
+	EnableMetrics bool   `env:"ENABLE_METRICS" env-default:"false"` // 是否开启 /metrics 监控指标，需要同时配置 METRICS_TOKEN
+	MetricsToken  string `env:"METRICS_TOKEN"`                      // 抓取 /metrics 需要携带 Authorization: Bearer <token>
+
 	DEBUGPProf  bool `env:"DEBUG_PPROF" env-default:"false"` // 是否开启pprof
 	ModeRelease bool `env:"MODE_RELEASE" env-default:"false"`
 }
@@ -160,7 +211,7 @@ var (
 )
 
 func init() {
-	flag.Parse()
+	*EnvPath = parseEnvPath(os.Args[1:])
 
 	AppConfigInstance = &AppConfig{}
 	JwtConf = &JwtConfig{}
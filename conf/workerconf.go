@@ -51,8 +51,24 @@ type KV struct {
 }
 
 type Assets struct {
-	Binding   string `json:"binding"`
-	Directory string `json:"directory"`
+	Binding      string            `json:"binding"`
+	Directory    string            `json:"directory"`
+	CacheControl []AssetsCacheRule `json:"cache_control"` // 按路径设置 Cache-Control，先匹配的规则生效
+
+	IndexFiles    []string `json:"index_files"`    // 访问目录时依次尝试的文件，例如 index.html
+	TrailingSlash string   `json:"trailing_slash"` // 目录索引的规范地址，"add" 补全尾斜杠，"remove" 去掉尾斜杠，为空时不重定向
+	SPAFallback   string   `json:"spa_fallback"`   // 资源不存在时返回的页面，例如 /index.html
+	NotFoundPage  string   `json:"not_found_page"` // 资源不存在且没有 SPA 回退时以 404 返回的页面
+}
+
+const (
+	AssetsTrailingSlashAdd    = "add"
+	AssetsTrailingSlashRemove = "remove"
+)
+
+type AssetsCacheRule struct {
+	Path  string `json:"path"`  // 支持 path.Match 通配符，以 /** 结尾时按前缀匹配
+	Value string `json:"value"` // Cache-Control 响应头的值
 }
 
 type Task struct {
@@ -87,12 +87,30 @@ const (
 	KeyNodeSecret  = "node_secret"
 	KeyNodeProto   = "node_proto"
 	KeyWorkerProto = "worker_proto"
+	// 资源凭证校验通过后，worker 配置中对应的资源
+	KeyWorkerResource = "worker_resource"
+)
+
+const (
+	// 当前版本的副本在 [0, WorkerSlotSize) 与 [WorkerSlotSize, 2*WorkerSlotSize) 两个区间之间交替，
+	// 更新时新旧副本的端口、frp 代理和 capfile 互不冲突
+	WorkerSlotSize = 500
+	// 第二个区间副本的目录后缀，两个区间各自保存一份代码，更新时不会覆盖旧副本正在使用的代码
+	WorkerSlotDirSuffix = "--slot"
+	// 灰度副本的 LocalID 从此值开始，避免与当前版本副本的端口和 frp 代理冲突
+	CanaryLocalIDBase = 1000
+	// 灰度副本的目录后缀，灰度版本的代码和配置与当前版本分开存放
+	CanaryDirSuffix = "--canary"
+	// 记录访问者分流桶号的 cookie，保证同一访问者始终命中同一个版本
+	CanaryCookieName = "vvorker-canary"
 )
 
 const (
 	HeaderNodeName   = "x-node-name"
 	HeaderNodeSecret = "x-secret"
 	HeaderHost       = "Host"
+	// worker 绑定中的资源凭证，只能访问该 worker 配置中的一个资源
+	HeaderResourceToken = "x-resource-token"
 )
 
 const (
@@ -108,4 +126,5 @@ const (
 	EventAddWorker    = "add-worker"
 	EventDeleteWorker = "delete-worker"
 	EventFlushWorker  = "flush-worker"
+	EventFlushCanary  = "flush-canary"
 )
@@ -46,6 +46,11 @@ export default withMermaid({
           { text: "项目配置", link: "/config/vvorker_project_config" },
           { text: "节点配置", link: "/config/node_config" },
           { text: "SSO配置", link: "/config/sso" },
+          { text: "限流配置", link: "/config/ratelimit" },
+          { text: "IP访问限制", link: "/config/ip_rule" },
+          { text: "AK/SK签名", link: "/config/aksk" },
+          { text: "JWT/OIDC", link: "/config/jwt" },
+          { text: "资源凭证", link: "/config/resource_token" },
         ],
       },
     ],
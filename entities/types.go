@@ -124,7 +124,8 @@ type RunWorkerResponse struct {
 }
 
 type AgentFillWorkerReq struct {
-	UID string `json:"uid"`
+	UID       string `json:"uid"`
+	VersionID string `json:"version_id"`
 }
 
 type AgentFillWorkerResp struct {
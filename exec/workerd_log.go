@@ -0,0 +1,73 @@
+package exec
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+	workercopy "vvorker/models/worker_copy"
+	"vvorker/utils"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 单行日志的最大长度，超出部分会拆成多条
+const maxWorkerLogLineSize = 64 * 1024
+
+// workerd --verbose 的日志前缀，如 "workerd/io/worker.c++:1234: info: ..."
+var workerdLogPrefix = regexp.MustCompile(`^\S+:\d+: (debug|info|warning|error|fatal): `)
+
+// parseWorkerdLogLevel 从 workerd 日志前缀中解析日志级别，没有前缀时使用 defaultLevel
+func parseWorkerdLogLevel(line string, defaultLevel string) string {
+	m := workerdLogPrefix.FindStringSubmatch(line)
+	if m == nil {
+		return defaultLevel
+	}
+	if m[1] == "warning" {
+		return "warn"
+	}
+	return m[1]
+}
+
+// scanWorkerdLines 与 bufio.ScanLines 相同，但一行超过 maxWorkerLogLineSize 时直接切分，
+// 避免 Scanner 因 ErrTooLong 停止读取而阻塞 workerd 的输出
+func scanWorkerdLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if i := bytes.IndexByte(data, '\n'); i < 0 && !atEOF && len(data) >= maxWorkerLogLineSize {
+		return maxWorkerLogLineSize, data[:maxWorkerLogLineSize], nil
+	}
+	return bufio.ScanLines(data, atEOF)
+}
+
+// readWorkerdOutput 按行读取 workerd 的标准输出或错误输出，每行写入一条日志，读到 EOF 后返回
+func readWorkerdOutput(r io.Reader, copy *workercopy.WorkerCopy, logType string) {
+	defaultLevel := "info"
+	if logType == "stderr" {
+		defaultLevel = "error"
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4*1024), maxWorkerLogLineSize)
+	scanner.Split(scanWorkerdLines)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		pushWorkerLog(WorkerLog{
+			WorkerLogData: &WorkerLogData{
+				UID:     copy.WorkerUID,
+				LocalID: copy.LocalID,
+				Output:  line,
+				Time:    time.Now(),
+				Type:    logType,
+				Level:   parseWorkerdLogLevel(line, defaultLevel),
+				LogUID:  utils.GenerateUID(),
+			},
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		logrus.Warnf("read workerd %s-%d %s error: %v", copy.WorkerUID, copy.LocalID, logType, err)
+	}
+}
@@ -0,0 +1,60 @@
+package exec
+
+import (
+	"sync"
+)
+
+// 每个订阅者的缓冲大小，消费过慢时新日志会被丢弃，不影响日志落库
+const workerLogSubscriberBuffer = 256
+
+// workerLogHub 将新产生的日志推送给实时查看日志的订阅者
+type workerLogHub struct {
+	lock sync.RWMutex
+	subs map[string]map[chan *WorkerLogData]struct{}
+}
+
+var logHub = &workerLogHub{
+	subs: map[string]map[chan *WorkerLogData]struct{}{},
+}
+
+// SubscribeWorkerLogs 订阅某个 worker 的实时日志，使用完毕后需要调用返回的取消函数
+func SubscribeWorkerLogs(workerUID string) (<-chan *WorkerLogData, func()) {
+	ch := make(chan *WorkerLogData, workerLogSubscriberBuffer)
+
+	logHub.lock.Lock()
+	if logHub.subs[workerUID] == nil {
+		logHub.subs[workerUID] = map[chan *WorkerLogData]struct{}{}
+	}
+	logHub.subs[workerUID][ch] = struct{}{}
+	logHub.lock.Unlock()
+
+	cancel := func() {
+		logHub.lock.Lock()
+		defer logHub.lock.Unlock()
+		delete(logHub.subs[workerUID], ch)
+		if len(logHub.subs[workerUID]) == 0 {
+			delete(logHub.subs, workerUID)
+		}
+	}
+	return ch, cancel
+}
+
+// publishWorkerLogs 将日志分发给对应 worker 的订阅者
+func publishWorkerLogs(logs ...WorkerLog) {
+	logHub.lock.RLock()
+	defer logHub.lock.RUnlock()
+	if len(logHub.subs) == 0 {
+		return
+	}
+	for _, log := range logs {
+		if log.WorkerLogData == nil {
+			continue
+		}
+		for ch := range logHub.subs[log.UID] {
+			select {
+			case ch <- log.WorkerLogData:
+			default:
+			}
+		}
+	}
+}
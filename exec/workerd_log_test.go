@@ -0,0 +1,105 @@
+package exec
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestParseWorkerdLogLevel(t *testing.T) {
+	tests := []struct {
+		line         string
+		defaultLevel string
+		want         string
+	}{
+		{line: "workerd/io/worker.c++:1234: debug: msg", defaultLevel: "info", want: "debug"},
+		{line: "workerd/io/worker.c++:1234: info: msg", defaultLevel: "error", want: "info"},
+		{line: "workerd/server/server.c++:99: warning: msg", defaultLevel: "info", want: "warn"},
+		{line: "kj/async.c++:1: error: msg", defaultLevel: "info", want: "error"},
+		{line: "src/main.c++:7: fatal: msg", defaultLevel: "info", want: "fatal"},
+		{line: "plain console.log output", defaultLevel: "info", want: "info"},
+		{line: "plain stderr output", defaultLevel: "error", want: "error"},
+		{line: "  workerd/io/worker.c++:1234: info: indented", defaultLevel: "error", want: "error"},
+		{line: "worker.c++:abc: info: no line number", defaultLevel: "error", want: "error"},
+		{line: "worker.c++:12: notice: unknown level", defaultLevel: "info", want: "info"},
+		{line: "worker.c++:12: info:missing space", defaultLevel: "error", want: "error"},
+	}
+	for _, tt := range tests {
+		if got := parseWorkerdLogLevel(tt.line, tt.defaultLevel); got != tt.want {
+			t.Errorf("parseWorkerdLogLevel(%q, %q) = %q, want %q", tt.line, tt.defaultLevel, got, tt.want)
+		}
+	}
+}
+
+func scanAll(r io.Reader) ([]string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 16), maxWorkerLogLineSize)
+	scanner.Split(scanWorkerdLines)
+	lines := []string{}
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines, scanner.Err()
+}
+
+func TestScanWorkerdLines(t *testing.T) {
+	long := strings.Repeat("a", maxWorkerLogLineSize)
+	tests := []struct {
+		name  string
+		input string
+		split bool // 每次只读一个字节，模拟一行被拆成多次写入
+		want  []string
+	}{
+		{name: "empty", input: "", want: []string{}},
+		{name: "single line", input: "hello\n", want: []string{"hello"}},
+		{name: "merged lines", input: "a\nb\nc\n", want: []string{"a", "b", "c"}},
+		{name: "split writes", input: "first line\nsecond line\n", split: true, want: []string{"first line", "second line"}},
+		{name: "crlf", input: "a\r\nb\r\n", want: []string{"a", "b"}},
+		{name: "empty lines kept", input: "a\n\nb\n", want: []string{"a", "", "b"}},
+		{name: "last line without newline at eof", input: "a\nb", want: []string{"a", "b"}},
+		{name: "last line without newline split", input: "a\nbc", split: true, want: []string{"a", "bc"}},
+		{name: "line at limit", input: long + "\nnext\n", want: []string{long, "", "next"}},
+		{name: "line over limit", input: long + "bc\nnext\n", want: []string{long, "bc", "next"}},
+		{name: "line over limit split", input: long + "bc\n", split: true, want: []string{long, "bc"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var r io.Reader = strings.NewReader(tt.input)
+			if tt.split {
+				r = iotest.OneByteReader(r)
+			}
+			got, err := scanAll(r)
+			if err != nil {
+				t.Fatalf("scan error: %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d lines, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("line %d = %.20q, want %.20q", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+// 写入端关闭后扫描在 EOF 处结束，不会一直阻塞
+func TestScanWorkerdLinesStopAtEOF(t *testing.T) {
+	pr, pw := io.Pipe()
+	done := make(chan []string)
+	go func() {
+		lines, _ := scanAll(pr)
+		done <- lines
+	}()
+	pw.Write([]byte("one\ntw"))
+	pw.Write([]byte("o\nthree"))
+	pw.Close()
+	got := <-done
+	want := []string{"one", "two", "three"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
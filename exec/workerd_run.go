@@ -3,10 +3,12 @@ package exec
 import (
 	"context"
 	"errors"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strconv"
+	"sync"
 	"syscall"
 	"time"
 	"vvorker/common"
@@ -18,9 +20,11 @@ import (
 	"vvorker/services/control"
 	"vvorker/utils"
 	"vvorker/utils/database"
+	"vvorker/utils/metrics"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-co-op/gocron/v2"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
 	"gorm.io/gorm"
 )
@@ -38,16 +42,21 @@ type execManager struct {
 	scheduler gocron.Scheduler
 
 	schedulerJobs *defs.SyncMap[string, []gocron.Job]
+	// 用于记录副本的重启次数、退出码等状态
+	statusMap  *defs.SyncMap[string, WorkerCopyStatus]
+	statusLock sync.Mutex
 }
 
 var ExecManager *execManager
 
 type WorkerLogData struct {
-	UID    string    `gorm:"index" json:"uid"`
-	Output string    `json:"output"`
-	Time   time.Time `gorm:"index" json:"time"`
-	Type   string    `gorm:"index" json:"type"`
-	LogUID string    `gorm:"index" json:"log_uid"`
+	UID     string    `gorm:"index" json:"uid"`
+	LocalID uint      `json:"local_id"` // 产生日志的副本
+	Output  string    `json:"output"`
+	Time    time.Time `gorm:"index" json:"time"`
+	Type    string    `gorm:"index" json:"type"`
+	Level   string    `gorm:"index" json:"level"` // debug info warn error fatal
+	LogUID  string    `gorm:"index" json:"log_uid"`
 }
 
 // 定义合并后的日志模型
@@ -78,12 +87,50 @@ func init() {
 		runningMap:    new(defs.SyncMap[string, bool]), // 初始化运行状态映射
 		scheduler:     scheduler,                       // 初始化调度器
 		schedulerJobs: new(defs.SyncMap[string, []gocron.Job]),
+		statusMap:     new(defs.SyncMap[string, WorkerCopyStatus]),
 	}
 
 	ExecManager.scheduler.Start()
+	metrics.RegisterLogQueueLength("worker_log", func() float64 { return float64(len(workerLogChan)) })
+	metrics.MustRegister(&runningCollector{m: ExecManager})
 	go processWorkerLogs()
 }
 
+// pushWorkerLog 将日志放入队列，队列满时直接丢弃，避免阻塞 workerd 输出的读取
+func pushWorkerLog(log WorkerLog) {
+	select {
+	case workerLogChan <- log:
+	default:
+		metrics.LogQueueDropped.WithLabelValues("worker_log").Inc()
+	}
+}
+
+var runningDesc = prometheus.NewDesc(
+	"vvorker_worker_copy_running",
+	"Whether the workerd copy is running (1) or not (0).",
+	[]string{"copy"}, nil,
+)
+
+// runningCollector 在采集时读取 runningMap，导出每个副本的运行状态
+type runningCollector struct {
+	m *execManager
+}
+
+func (r *runningCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- runningDesc
+}
+
+func (r *runningCollector) Collect(ch chan<- prometheus.Metric) {
+	r.m.runningMap.Range(func(uid string, running bool) bool {
+		v := 0.0
+		if running {
+			v = 1
+		}
+		ch <- prometheus.MustNewConstMetric(runningDesc, prometheus.GaugeValue, v, uid)
+		return true
+	})
+}
+
 // 处理合并后日志的函数
 func processWorkerLogs() {
 	var logs []WorkerLog
@@ -93,23 +140,28 @@ func processWorkerLogs() {
 			logs = append(logs, log)
 			if len(logs) >= batchSize {
 				// 批量插入数据库
-				if err := dbCreateWorkerLogs(logs); err != nil {
-					logrus.Errorf("Failed to batch insert worker logs: %v", err)
-				}
+				flushWorkerLogs(logs)
 				logs = nil
 			}
 		case <-time.After(2 * time.Second):
 			if len(logs) > 0 {
 				// 定时批量插入
-				if err := dbCreateWorkerLogs(logs); err != nil {
-					logrus.Errorf("Failed to batch insert worker logs: %v", err)
-				}
+				flushWorkerLogs(logs)
 				logs = nil
 			}
 		}
 	}
 }
 
+// flushWorkerLogs 日志落库之后再推送给实时查看的用户，
+// 这样订阅之前产生的日志一定能在历史日志中查到，订阅之后落库的日志一定会被推送
+func flushWorkerLogs(logs []WorkerLog) {
+	if err := dbCreateWorkerLogs(logs); err != nil {
+		logrus.Errorf("Failed to batch insert worker logs: %v", err)
+	}
+	publishWorkerLogs(logs...)
+}
+
 type AgentWorkerLogsReq struct {
 	Logs []WorkerLog `json:"logs"`
 }
@@ -142,7 +194,10 @@ func HandleAgentWorkerLogs(c *gin.Context) {
 	if err := c.BindJSON(&req); err != nil {
 		return
 	}
-	if err := dbCreateWorkerLogs(req.Logs); err != nil {
+	err := dbCreateWorkerLogs(req.Logs)
+	// 落库之后再转发给正在实时查看日志的用户
+	publishWorkerLogs(req.Logs...)
+	if err != nil {
 		common.RespErr(c, common.RespCodeInternalError, common.RespMsgInternalError, nil)
 		return
 	}
@@ -166,13 +221,29 @@ func (m *execManager) RunCmd(uid string) {
 			LogUID: utils.GenerateUID(),
 		}})
 
-	var worker entities.Worker
-	if err := db.Where("uid = ?", uid).First(&worker).Error; err != nil {
+	if err := m.ScheduleWorker(uid); err != nil {
 		logrus.Warnf("workerconfig error: %v", err)
 		return
 	}
+
 	var copies []workercopy.WorkerCopy
-	db.Model(&workercopy.WorkerCopy{}).Where(&workercopy.WorkerCopy{WorkerUID: uid}).Find(&copies)
+	db.Model(&workercopy.WorkerCopy{}).Scopes(workercopy.Primary).Where(&workercopy.WorkerCopy{WorkerUID: uid}).Find(&copies)
+
+	for _, copy := range copies {
+		m.RunWorker(&copy)
+	}
+
+}
+
+// ScheduleWorker 按 worker 当前的模板重新注册定时任务，已有的定时任务会先被移除
+func (m *execManager) ScheduleWorker(uid string) error {
+	m.removeSchedulerJobs(uid)
+
+	db := database.GetDB()
+	var worker entities.Worker
+	if err := db.Where("uid = ?", uid).First(&worker).Error; err != nil {
+		return err
+	}
 
 	workerconfig, werr := conf.ParseWorkerConfig(worker.Template)
 	if werr != nil {
@@ -190,7 +261,10 @@ func (m *execManager) RunCmd(uid string) {
 		j, err := s.NewJob(
 			gocron.CronJob(scheduler.Cron, true),
 			gocron.NewTask(
-				control.SendSchedulerEvent,
+				func(workerUID string, cron string, name string) {
+					metrics.SchedulerJobFires.WithLabelValues(workerUID, name).Inc()
+					control.SendSchedulerEvent(workerUID, cron, name)
+				},
 				uid,
 				scheduler.Cron,
 				scheduler.Name,
@@ -204,11 +278,17 @@ func (m *execManager) RunCmd(uid string) {
 		allJobs = append(allJobs, j)
 		m.schedulerJobs.Set(uid, allJobs)
 	}
+	return nil
+}
 
-	for _, copy := range copies {
-		m.RunWorker(&copy)
+func (m *execManager) removeSchedulerJobs(uid string) {
+	allJobs, ok := m.schedulerJobs.Get(uid)
+	if ok {
+		for _, job := range allJobs {
+			ExecManager.scheduler.RemoveJob(job.ID())
+		}
 	}
-
+	m.schedulerJobs.Delete(uid)
 }
 
 func (m *execManager) RunWorker(copy *workercopy.WorkerCopy) {
@@ -216,19 +296,23 @@ func (m *execManager) RunWorker(copy *workercopy.WorkerCopy) {
 
 	c := make(chan struct{})
 	m.chanMap.Set(uid, c)
+	m.statusMap.Set(uid, WorkerCopyStatus{
+		UID:       uid,
+		WorkerUID: copy.WorkerUID,
+		LocalID:   copy.LocalID,
+		State:     WorkerCopyStateStopped,
+	})
 
 	ctx, cancel := context.WithCancel(context.Background())
 	go func(ctx context.Context, uid string, m *execManager) {
 		defer func(uid string, m *execManager) {
 			m.signMap.Delete(uid)
+			m.runningMap.Set(uid, false)
+			m.statusMap.Delete(uid)
 		}(uid, m)
 
 		logrus.Infof("workerd %s running!", uid)
-		workerdDir := filepath.Join(
-			conf.AppConfigInstance.WorkerdDir,
-			defs.WorkerInfoPath,
-			copy.WorkerUID,
-		)
+		workerdDir := copy.Dir()
 
 		for {
 			// 检查上下文是否被取消，如果取消则退出循环
@@ -266,158 +350,51 @@ func (m *execManager) RunWorker(copy *workercopy.WorkerCopy) {
 				logrus.Errorf("Failed to start workerd %s: %v", uid, err)
 				m.runningMap.Set(uid, false)
 
+				if !m.waitRestart(ctx, uid, -1, 0) {
+					return
+				}
 				continue
 			}
+			startedAt := time.Now()
 
 			// 保存进程 ID
 			m.pidMap.Set(uid, cmd.Process.Pid)
 
-			// 读取标准输出并发送到 channel
-			go func(uid string) {
-				maxBufferSize := 4 * 1024
-				buf := make([]byte, maxBufferSize)
-				lineBuffer := make([]byte, 0, maxBufferSize)
-				for {
-					select {
-					case <-ctx.Done(): // 监听上下文取消信号
-						return
-					default:
-						n, err := stdoutPipe.Read(buf)
-						if n > 0 {
-							data := buf[:n]
-							// 累积数据到行缓冲区
-							lineBuffer = append(lineBuffer, data...)
-							if n < maxBufferSize {
-								// 如果读取的数据小于缓冲区大小，说明已经读取完毕
-								// 提取一行
-								line := string(lineBuffer)
-								logUID := utils.GenerateUID()
-								workerLogChan <- WorkerLog{
-									WorkerLogData: &WorkerLogData{
-										UID:    copy.WorkerUID,
-										Output: line,
-										Time:   time.Now(),
-										Type:   "stdout",
-										LogUID: logUID,
-									},
-								}
-								lineBuffer = lineBuffer[:0]
-							} else if len(lineBuffer) > 10240 {
-								// 如果缓冲区过大（超过10KB），强制发送并清空
-
-								line := string(lineBuffer)
-								logUID := utils.GenerateUID()
-								workerLogChan <- WorkerLog{
-									WorkerLogData: &WorkerLogData{
-										UID:    copy.WorkerUID,
-										Output: line,
-										Time:   time.Now(),
-										Type:   "stdout",
-										LogUID: logUID,
-									},
-								}
-								lineBuffer = lineBuffer[:0]
-							}
-						}
-						if err != nil {
-							// 退出前发送剩余的缓冲区数据
-							if len(lineBuffer) > 0 {
-								line := string(lineBuffer)
-								logUID := utils.GenerateUID()
-								workerLogChan <- WorkerLog{
-									WorkerLogData: &WorkerLogData{
-										UID:    copy.WorkerUID,
-										Output: line,
-										Time:   time.Now(),
-										Type:   "stdout",
-										LogUID: logUID,
-									},
-								}
-								lineBuffer = lineBuffer[:0]
-							}
-						}
-					}
+			// 按行读取标准输出和错误输出，进程退出后管道关闭，读取协程随之结束
+			readers := sync.WaitGroup{}
+			for logType, pipe := range map[string]io.Reader{"stdout": stdoutPipe, "stderr": stderrPipe} {
+				if pipe == nil {
+					continue
 				}
-			}(uid)
-
-			// 读取错误输出并发送到 channel
-			go func(uid string) {
-				maxBufferSize := 4 * 1024
-				buf := make([]byte, maxBufferSize)
-				lineBuffer := make([]byte, 0, maxBufferSize)
-				for {
-					select {
-					case <-ctx.Done(): // 监听上下文取消信号
-						return
-					default:
-						n, err := stderrPipe.Read(buf)
-						if n > 0 {
-							data := buf[:n]
-							// 累积数据到行缓冲区
-							lineBuffer = append(lineBuffer, data...)
-							if n < maxBufferSize {
-								// 如果读取的数据小于缓冲区大小，说明已经读取完毕
-								// 提取一行
-								line := string(lineBuffer)
-								logUID := utils.GenerateUID()
-								workerLogChan <- WorkerLog{
-									WorkerLogData: &WorkerLogData{
-										UID:    copy.WorkerUID,
-										Output: line,
-										Time:   time.Now(),
-										Type:   "stderr",
-										LogUID: logUID,
-									},
-								}
-								lineBuffer = lineBuffer[:0]
-							} else if len(lineBuffer) > 10240 {
-								// 如果缓冲区过大（超过10KB），强制发送并清空
-
-								line := string(lineBuffer)
-								logUID := utils.GenerateUID()
-								workerLogChan <- WorkerLog{
-									WorkerLogData: &WorkerLogData{
-										UID:    copy.WorkerUID,
-										Output: line,
-										Time:   time.Now(),
-										Type:   "stderr",
-										LogUID: logUID,
-									},
-								}
-								lineBuffer = lineBuffer[:0]
-							}
-						}
-						if err != nil {
-							// 退出前发送剩余的缓冲区数据
-							if len(lineBuffer) > 0 {
-								line := string(lineBuffer)
-								logUID := utils.GenerateUID()
-								workerLogChan <- WorkerLog{
-									WorkerLogData: &WorkerLogData{
-										UID:    copy.WorkerUID,
-										Output: line,
-										Time:   time.Now(),
-										Type:   "stdout",
-										LogUID: logUID,
-									},
-								}
-								lineBuffer = lineBuffer[:0]
-							}
-						}
-					}
-				}
-			}(uid)
+				readers.Add(1)
+				go func(pipe io.Reader, logType string) {
+					defer readers.Done()
+					readWorkerdOutput(pipe, copy, logType)
+				}(pipe, logType)
+			}
 			m.runningMap.Set(uid, true)
+			m.updateCopyStatus(uid, func(s *WorkerCopyStatus) {
+				s.State = WorkerCopyStateRunning
+				s.NextRestartTime = time.Time{}
+			})
 
+			// 必须先读完管道再 Wait，Wait 会关闭管道
+			readers.Wait()
 			if err := cmd.Wait(); err != nil {
 				logrus.Errorf("Workerd %s : %d exited with error: %v", uid, copy.LocalID, err)
-				m.runningMap.Set(uid, false)
 			}
+			m.runningMap.Set(uid, false)
 
 			if exit, ok := m.signMap.Get(uid); ok && exit {
 				return
 			}
-			time.Sleep(3 * time.Second)
+			if ctx.Err() != nil {
+				return
+			}
+			// 按指数退避等待后重启，避免语法错误等问题导致 workerd 不停重启
+			if !m.waitRestart(ctx, uid, cmd.ProcessState.ExitCode(), time.Since(startedAt)) {
+				return
+			}
 		}
 	}(ctx, uid, m)
 
@@ -438,54 +415,54 @@ func (m *execManager) RunWorker(copy *workercopy.WorkerCopy) {
 func (m *execManager) ExitCmd(uid string) {
 	defer func(uid string, m *execManager) {
 		m.signMap.Delete(uid)
-		m.runningMap.Set(uid, false) // 标记 worker 为停止状态
 	}(uid, m)
 
-	allJobs, ok := m.schedulerJobs.Get(uid)
-	if ok {
-		for _, job := range allJobs {
-			ExecManager.scheduler.RemoveJob(job.ID())
-		}
-	}
+	m.removeSchedulerJobs(uid)
 
 	db := database.GetDB()
+	// 灰度副本由灰度发布单独管理
 	copies := []workercopy.WorkerCopy{}
-	db.Where(&workercopy.WorkerCopy{WorkerUID: uid}).Find(&copies)
+	db.Scopes(workercopy.Primary).Where(&workercopy.WorkerCopy{WorkerUID: uid}).Find(&copies)
 
 	for _, copy := range copies {
-		uid_localid := copy.WorkerUID + "-" + strconv.Itoa(int(copy.LocalID))
-		if channel, ok := m.chanMap.Get(uid_localid); ok {
-			channel <- struct{}{}
-			logrus.Infof("workerd %s is being stopped!", uid_localid)
-		} else {
-			logrus.Warnf("workerd %s is not running, cannot stop it!", uid_localid)
-		}
+		m.ExitWorker(&copy)
+	}
 
-		// 尝试获取进程 ID
-		pid, ok := m.pidMap.Get(uid_localid)
-		if !ok {
-			logrus.Warnf("No process ID found for workerd %s", uid_localid)
-			return
-		} else {
-			logrus.Infof("workerd %s pid is %d", uid_localid, pid)
-		}
+}
 
-		// 获取进程句柄
-		process, err := os.FindProcess(pid)
-		if err != nil {
-			logrus.Errorf("Failed to find process for workerd %s: %v", uid_localid, err)
-			return
-		}
+// ExitWorker 停止 worker 的单个副本并等待进程退出
+func (m *execManager) ExitWorker(copy *workercopy.WorkerCopy) {
+	uid_localid := copy.WorkerUID + "-" + strconv.Itoa(int(copy.LocalID))
+	if channel, ok := m.chanMap.Get(uid_localid); ok {
+		channel <- struct{}{}
+		logrus.Infof("workerd %s is being stopped!", uid_localid)
+	} else {
+		logrus.Warnf("workerd %s is not running, cannot stop it!", uid_localid)
+	}
 
-		// 等待进程退出
-		_, err = process.Wait()
-		if err != nil {
-			logrus.Errorf("Error waiting for workerd %s to exit: %v", uid_localid, err)
-		} else {
-			logrus.Infof("workerd %s has stopped", uid_localid)
-		}
+	// 尝试获取进程 ID
+	pid, ok := m.pidMap.Get(uid_localid)
+	if !ok {
+		logrus.Warnf("No process ID found for workerd %s", uid_localid)
+		return
+	} else {
+		logrus.Infof("workerd %s pid is %d", uid_localid, pid)
 	}
 
+	// 获取进程句柄
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		logrus.Errorf("Failed to find process for workerd %s: %v", uid_localid, err)
+		return
+	}
+
+	// 等待进程退出
+	_, err = process.Wait()
+	if err != nil {
+		logrus.Errorf("Error waiting for workerd %s to exit: %v", uid_localid, err)
+	} else {
+		logrus.Infof("workerd %s has stopped", uid_localid)
+	}
 }
 
 func (m *execManager) ExitAllCmd() {
@@ -493,14 +470,3 @@ func (m *execManager) ExitAllCmd() {
 		m.ExitCmd(uid)
 	}
 }
-
-func (m *execManager) GetWorkerStatusByUID(uid string) int {
-	mu, ok := m.runningMap.Get(uid)
-	if !ok {
-		return 0
-	}
-	if mu {
-		return 1
-	}
-	return 0
-}
@@ -0,0 +1,175 @@
+package exec
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+	"vvorker/conf"
+	workercopy "vvorker/models/worker_copy"
+	"vvorker/services/control"
+	"vvorker/utils"
+	"vvorker/utils/metrics"
+
+	"github.com/sirupsen/logrus"
+)
+
+// workerd 副本状态
+const (
+	WorkerCopyStateStopped   = "stopped"
+	WorkerCopyStateRunning   = "running"
+	WorkerCopyStateBackoff   = "backoff"   // 已退出，等待重启
+	WorkerCopyStateCrashLoop = "crashloop" // 连续多次快速退出
+)
+
+const (
+	// 首次重启的等待时间，之后每次连续失败翻倍
+	restartBackoffBase = time.Second
+	// 运行时间短于该值就退出，视为一次快速失败
+	rapidFailureWindow = 30 * time.Second
+)
+
+type WorkerCopyStatus struct {
+	UID             string    `json:"uid"`
+	WorkerUID       string    `json:"worker_uid"`
+	LocalID         uint      `json:"local_id"`
+	State           string    `json:"state"`
+	RestartCount    int       `json:"restart_count"`
+	RapidFailures   int       `json:"rapid_failures"` // 连续快速失败次数
+	LastExitCode    int       `json:"last_exit_code"`
+	LastExitTime    time.Time `json:"last_exit_time"`
+	NextRestartTime time.Time `json:"next_restart_time"`
+}
+
+type WorkerStatus struct {
+	State  string             `json:"state"`
+	Copies []WorkerCopyStatus `json:"copies"`
+}
+
+// restartBackoff 计算连续失败 failures 次后的重启等待时间
+func restartBackoff(failures int) time.Duration {
+	max := time.Duration(conf.AppConfigInstance.WorkerRestartBackoffMax) * time.Second
+	delay := restartBackoffBase
+	for i := 1; i < failures && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
+
+// updateCopyStatus 在锁内修改副本状态，返回修改后的副本
+func (m *execManager) updateCopyStatus(uid string, fn func(s *WorkerCopyStatus)) WorkerCopyStatus {
+	m.statusLock.Lock()
+	defer m.statusLock.Unlock()
+	s, _ := m.statusMap.Get(uid)
+	fn(&s)
+	m.statusMap.Set(uid, s)
+	return s
+}
+
+// waitRestart 记录本次退出并按退避时间等待，返回 false 表示等待期间 worker 已被停止
+func (m *execManager) waitRestart(ctx context.Context, uid string, exitCode int, uptime time.Duration) bool {
+	var delay time.Duration
+	status := m.updateCopyStatus(uid, func(s *WorkerCopyStatus) {
+		if uptime < rapidFailureWindow {
+			s.RapidFailures++
+		} else {
+			s.RapidFailures = 1
+		}
+		s.LastExitCode = exitCode
+		s.LastExitTime = time.Now()
+		delay = restartBackoff(s.RapidFailures)
+		s.NextRestartTime = s.LastExitTime.Add(delay)
+		if s.RapidFailures >= conf.AppConfigInstance.WorkerCrashLoopThreshold {
+			s.State = WorkerCopyStateCrashLoop
+		} else {
+			s.State = WorkerCopyStateBackoff
+		}
+	})
+
+	if status.State == WorkerCopyStateCrashLoop && status.RapidFailures == conf.AppConfigInstance.WorkerCrashLoopThreshold {
+		msg := fmt.Sprintf("workerd %s is crash looping, exited %d times in a row, last exit code %d",
+			uid, status.RapidFailures, exitCode)
+		logrus.Warn(msg)
+		pushWorkerLog(WorkerLog{
+			WorkerLogData: &WorkerLogData{
+				UID:    status.WorkerUID,
+				Output: msg,
+				Time:   time.Now(),
+				Type:   "warn",
+				LogUID: utils.GenerateUID(),
+			},
+		})
+	}
+	logrus.Infof("workerd %s exited with code %d, restarting in %v", uid, exitCode, delay)
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(delay):
+	}
+
+	m.updateCopyStatus(uid, func(s *WorkerCopyStatus) {
+		s.RestartCount++
+	})
+	metrics.WorkerCopyRestarts.WithLabelValues(uid).Inc()
+	return true
+}
+
+// GetWorkerStatusByUID 返回 worker 所有副本的状态，整体状态取最严重的副本状态
+func (m *execManager) GetWorkerStatusByUID(workerUID string) WorkerStatus {
+	status := WorkerStatus{State: WorkerCopyStateStopped, Copies: []WorkerCopyStatus{}}
+	m.statusMap.Range(func(_ string, s WorkerCopyStatus) bool {
+		if s.WorkerUID == workerUID {
+			status.Copies = append(status.Copies, s)
+		}
+		return true
+	})
+	sort.Slice(status.Copies, func(i, j int) bool { return status.Copies[i].LocalID < status.Copies[j].LocalID })
+
+	severity := map[string]int{
+		WorkerCopyStateStopped:   0,
+		WorkerCopyStateRunning:   1,
+		WorkerCopyStateBackoff:   2,
+		WorkerCopyStateCrashLoop: 3,
+	}
+	for _, s := range status.Copies {
+		if severity[s.State] > severity[status.State] {
+			status.State = s.State
+		}
+	}
+	return status
+}
+
+// 健康检查的轮询间隔
+const healthCheckInterval = 200 * time.Millisecond
+
+// IsRunning 副本进程是否正在运行
+func (m *execManager) IsRunning(copy *workercopy.WorkerCopy) bool {
+	running, ok := m.runningMap.Get(copy.WorkerUID + "-" + strconv.Itoa(int(copy.LocalID)))
+	return ok && running
+}
+
+// WaitHealthy 轮询副本的控制端口直到通过健康检查，副本启动失败进入退避时立即返回错误
+func (m *execManager) WaitHealthy(ctx context.Context, copy *workercopy.WorkerCopy) error {
+	uid := copy.WorkerUID + "-" + strconv.Itoa(int(copy.LocalID))
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+	for {
+		if err := control.CheckHealth(int(copy.ControlPort)); err == nil {
+			return nil
+		}
+		if s, ok := m.statusMap.Get(uid); ok &&
+			(s.State == WorkerCopyStateBackoff || s.State == WorkerCopyStateCrashLoop) {
+			return fmt.Errorf("workerd %s exited with code %d before becoming healthy", uid, s.LastExitCode)
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("workerd %s health check timeout", uid)
+		case <-ticker.C:
+		}
+	}
+}
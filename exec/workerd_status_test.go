@@ -0,0 +1,31 @@
+package exec
+
+import (
+	"testing"
+	"time"
+	"vvorker/conf"
+)
+
+func TestRestartBackoff(t *testing.T) {
+	max := conf.AppConfigInstance.WorkerRestartBackoffMax
+	conf.AppConfigInstance.WorkerRestartBackoffMax = 10
+	defer func() { conf.AppConfigInstance.WorkerRestartBackoffMax = max }()
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{failures: 0, want: time.Second},
+		{failures: 1, want: time.Second},
+		{failures: 2, want: 2 * time.Second},
+		{failures: 3, want: 4 * time.Second},
+		{failures: 4, want: 8 * time.Second},
+		{failures: 5, want: 10 * time.Second},
+		{failures: 100, want: 10 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := restartBackoff(tt.failures); got != tt.want {
+			t.Errorf("restartBackoff(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,419 @@
+package assets
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"path"
+	"path/filepath"
+	"strings"
+	"vvorker/conf"
+	"vvorker/dao"
+	"vvorker/entities"
+	"vvorker/models"
+	"vvorker/utils"
+	"vvorker/utils/blobstore"
+	"vvorker/utils/database"
+
+	"github.com/gin-gonic/gin"
+	"github.com/samber/lo"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+type DeployAssetsResp struct {
+	ReleaseUID string `json:"release_uid"`
+	FileCount  int    `json:"file_count"`
+	Uploaded   int    `json:"uploaded"` // 新保存的文件数，其余文件按哈希复用已有文件
+	Size       int64  `json:"size"`
+}
+
+// DeployAssetsEndpoint 上传构建目录的 tar/zip 包，整体替换 worker 的静态资源
+// 表单字段 worker_uid 与 file，file 支持 .zip、.tar、.tar.gz 与 .tgz
+func DeployAssetsEndpoint(c *gin.Context) {
+	workerUID := c.PostForm("worker_uid")
+	if workerUID == "" {
+		c.JSON(400, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	userID := c.GetUint("uid")
+	if userID == 0 {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+	if !checkAssetsWorker(c, workerUID, userID) {
+		return
+	}
+
+	header, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Failed to get file from form"})
+		return
+	}
+	src, err := header.Open()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to open file"})
+		return
+	}
+	defer src.Close()
+
+	resp := DeployAssetsResp{}
+	upload := &assetsUpload{}
+	// 包内路径重复时以后出现的为准
+	filesMap := make(map[string]models.AssetsReleaseFile)
+	sizes := make(map[string]int64)
+	err = walkAssetsArchive(src, header.Size, header.Filename, func(p string, r io.Reader) error {
+		file, created, err := upload.save(c, userID, p, r)
+		if err != nil {
+			return err
+		}
+		if created {
+			resp.Uploaded++
+		}
+		filesMap[p] = models.AssetsReleaseFile{
+			UID:  file.UID,
+			Path: p,
+			Name: path.Base(p),
+			MIME: filepath.Ext(p),
+			Hash: file.Hash,
+		}
+		sizes[p] = file.Size
+		return nil
+	})
+	if err != nil {
+		logrus.Errorf("Failed to deploy assets: %v", err)
+		upload.cleanup()
+		c.JSON(400, gin.H{"error": "Failed to read archive", "detail": err.Error()})
+		return
+	}
+
+	files := make([]models.AssetsReleaseFile, 0, len(filesMap))
+	for p, f := range filesMap {
+		files = append(files, f)
+		resp.Size += sizes[p]
+	}
+	release := &models.AssetsRelease{
+		UID:       utils.GenerateUID(),
+		WorkerUID: workerUID,
+		UserID:    uint64(userID),
+		FileCount: len(files),
+		Size:      resp.Size,
+	}
+	if err := models.CreateAssetsRelease(release, files); err != nil {
+		logrus.Errorf("Failed to switch assets: %v", err)
+		upload.cleanup()
+		c.JSON(500, gin.H{"error": "Failed to switch assets"})
+		return
+	}
+	pruneAssetsReleases(workerUID)
+
+	resp.ReleaseUID = release.UID
+	resp.FileCount = release.FileCount
+	c.JSON(200, gin.H{
+		"code":    0,
+		"data":    resp,
+		"message": "Assets deployed successfully",
+	})
+}
+
+type RollbackAssetsReq struct {
+	WorkerUID  string `json:"worker_uid"`
+	ReleaseUID string `json:"release_uid"`
+}
+
+// RollbackAssetsEndpoint 将 worker 的静态资源整体切换回保留的发布清单
+func RollbackAssetsEndpoint(c *gin.Context) {
+	var req RollbackAssetsReq
+	if err := c.BindJSON(&req); err != nil {
+		return
+	}
+	if req.WorkerUID == "" || req.ReleaseUID == "" {
+		c.JSON(400, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	userID := c.GetUint("uid")
+	if userID == 0 {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+	if !checkAssetsWorker(c, req.WorkerUID, userID) {
+		return
+	}
+
+	release, err := models.GetAssetsRelease(req.WorkerUID, req.ReleaseUID)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Release not found"})
+		return
+	}
+	if err := models.RollbackAssetsRelease(release); err != nil {
+		logrus.Errorf("Failed to rollback assets: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to rollback assets"})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"code":    0,
+		"data":    release,
+		"message": "Assets rolled back successfully",
+	})
+}
+
+type ListAssetsReleasesReq struct {
+	WorkerUID string `json:"worker_uid"`
+}
+
+func ListAssetsReleasesEndpoint(c *gin.Context) {
+	var req ListAssetsReleasesReq
+	if err := c.BindJSON(&req); err != nil {
+		return
+	}
+	if req.WorkerUID == "" {
+		c.JSON(400, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	userID := c.GetUint("uid")
+	if userID == 0 {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+	if !checkAssetsWorker(c, req.WorkerUID, userID) {
+		return
+	}
+
+	releases, err := models.ListAssetsReleases(req.WorkerUID)
+	if err != nil {
+		logrus.Errorf("Failed to list assets releases: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to list assets releases"})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"code":    0,
+		"data":    releases,
+		"message": "success",
+	})
+}
+
+// checkAssetsWorker 检查 worker 是否存在且属于当前用户，失败时已写入响应
+func checkAssetsWorker(c *gin.Context, workerUID string, userID uint) bool {
+	var w models.Worker
+	if err := database.GetDB().Where(&models.Worker{
+		Worker: &entities.Worker{
+			UID: workerUID,
+		},
+	}).First(&w).Error; err != nil {
+		logrus.Errorf("Worker not found: %v", err)
+		c.JSON(404, gin.H{"error": "Worker not found"})
+		return false
+	}
+
+	if w.UserID != uint64(userID) {
+		c.JSON(403, gin.H{"error": "Forbidden"})
+		return false
+	}
+	return true
+}
+
+var (
+	errAssetsArchiveTooLarge = errors.New("archive exceeds the total size limit")
+	errAssetsFileTooLarge    = errors.New("archive entry exceeds the file size limit")
+	errAssetsTooManyFiles    = errors.New("archive exceeds the file count limit")
+)
+
+// assetsArchiveLimit 按 ASSETS_ARCHIVE_* 限制解压后的总大小、单个文件大小与文件数量，
+// 以实际读出的字节计数，不信任压缩包中记录的大小
+type assetsArchiveLimit struct {
+	total int64
+	files int
+}
+
+// entry 开始读取一个文件，返回超出限制时报错的 reader
+func (l *assetsArchiveLimit) entry(r io.Reader) (io.Reader, error) {
+	l.files++
+	if l.files > conf.AppConfigInstance.AssetsArchiveMaxFiles {
+		return nil, errAssetsTooManyFiles
+	}
+	return &assetsLimitedReader{r: r, limit: l}, nil
+}
+
+type assetsLimitedReader struct {
+	r     io.Reader
+	size  int64
+	limit *assetsArchiveLimit
+}
+
+func (r *assetsLimitedReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.size += int64(n)
+	r.limit.total += int64(n)
+	if r.size > conf.AppConfigInstance.AssetsArchiveMaxFileSize {
+		return n, errAssetsFileTooLarge
+	}
+	if r.limit.total > conf.AppConfigInstance.AssetsArchiveMaxSize {
+		return n, errAssetsArchiveTooLarge
+	}
+	return n, err
+}
+
+// walkAssetsArchive 依次读取压缩包中的普通文件，路径统一为以 / 开头的绝对路径
+// 目录、符号链接等其他类型的条目会被跳过
+func walkAssetsArchive(src multipart.File, size int64, filename string, fn func(p string, r io.Reader) error) error {
+	limit := &assetsArchiveLimit{}
+	name := strings.ToLower(filename)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		zipReader, err := zip.NewReader(src, size)
+		if err != nil {
+			return err
+		}
+		for _, f := range zipReader.File {
+			if !f.Mode().IsRegular() {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return err
+			}
+			r, err := limit.entry(rc)
+			if err == nil {
+				err = fn(assetsArchivePath(f.Name), r)
+			}
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		gz, err := gzip.NewReader(src)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		return walkAssetsTar(gz, limit, fn)
+	case strings.HasSuffix(name, ".tar"):
+		return walkAssetsTar(src, limit, fn)
+	}
+	return fmt.Errorf("unsupported archive: %s", filename)
+}
+
+func walkAssetsTar(r io.Reader, limit *assetsArchiveLimit, fn func(p string, r io.Reader) error) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		entry, err := limit.entry(tr)
+		if err != nil {
+			return err
+		}
+		if err := fn(assetsArchivePath(hdr.Name), entry); err != nil {
+			return err
+		}
+	}
+}
+
+// assetsArchivePath 将 ./index.html、dist\a.js 等包内路径转为资源路径
+func assetsArchivePath(name string) string {
+	return path.Clean("/" + strings.ReplaceAll(name, "\\", "/"))
+}
+
+// assetsUpload 记录一次部署中保存的内容，部署失败时清理，避免留下没有发布清单引用的文件
+type assetsUpload struct {
+	hashes   []string
+	fileUIDs []string
+}
+
+// save 内容写入 blob 存储，按哈希复用当前用户已有的文件记录，不存在时新建
+func (u *assetsUpload) save(c *gin.Context, userID uint, p string, r io.Reader) (*models.File, bool, error) {
+	hash, size, err := blobstore.Put(c, r)
+	if err != nil {
+		return nil, false, err
+	}
+	u.hashes = append(u.hashes, hash)
+
+	file, err := dao.GetFileByHashAndCreator(c, hash, userID)
+	if err == nil {
+		if file.Size == 0 {
+			file.Size = size
+		}
+		return file, false, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, false, err
+	}
+
+	file, err = dao.SaveFile(c, &models.File{
+		CreatedBy:   userID,
+		Hash:        hash,
+		Mimetype:    filepath.Ext(p),
+		Size:        size,
+		InBlobStore: true,
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	u.fileUIDs = append(u.fileUIDs, file.UID)
+	return file, true, nil
+}
+
+// cleanup 删除本次新建且没有被发布清单引用的文件记录，以及没有任何文件记录引用的 blob
+func (u *assetsUpload) cleanup() {
+	db := database.GetDB()
+	for _, uid := range u.fileUIDs {
+		referenced, err := models.AssetsFileReferenced(db, uid)
+		if err != nil || referenced {
+			continue
+		}
+		if err := models.DeleteFileByUID(uid); err != nil {
+			logrus.Errorf("Failed to delete file: %v", err)
+		}
+	}
+	for _, hash := range lo.Uniq(u.hashes) {
+		var count int64
+		if err := db.Model(&models.File{}).Where(&models.File{Hash: hash, InBlobStore: true}).Count(&count).Error; err != nil || count != 0 {
+			continue
+		}
+		if err := blobstore.Delete(context.Background(), hash); err != nil {
+			logrus.Warnf("Failed to delete blob %s: %v", hash, err)
+		}
+	}
+}
+
+// pruneAssetsReleases 清理超出保留数量的发布清单，删除不再被引用的文件
+func pruneAssetsReleases(workerUID string) {
+	fileUIDs, err := models.PruneAssetsReleases(workerUID, conf.AppConfigInstance.AssetsReleaseKeep)
+	if err != nil {
+		logrus.Errorf("Failed to prune assets releases: %v", err)
+		return
+	}
+	db := database.GetDB()
+	for _, uid := range fileUIDs {
+		referenced, err := models.AssetsFileReferenced(db, uid)
+		if err != nil {
+			logrus.Errorf("Failed to count assets: %v", err)
+			continue
+		}
+		if referenced {
+			continue
+		}
+		if err := models.DeleteFileByUID(uid); err != nil {
+			logrus.Errorf("Failed to delete file: %v", err)
+		}
+	}
+}
@@ -1,15 +1,13 @@
 package assets
 
 import (
-	"fmt"
 	"io"
-	"mime"
 	"net/http"
-	"vvorker/conf"
+	"vvorker/defs"
 	"vvorker/entities"
 	"vvorker/ext/kv/src/sys_cache"
-	oss "vvorker/ext/oss/src"
 	"vvorker/models"
+	"vvorker/utils/blobstore"
 	"vvorker/utils/database"
 
 	"github.com/gin-gonic/gin"
@@ -76,20 +74,16 @@ func ClearAssetsEndpoint(c *gin.Context) {
 
 	deleteCount := 0
 	for _, a := range assets {
-		// 如果Assets 中没有任何资源引用了a.UID
-		count := int64(0)
-		if err := db.Model(&models.Assets{}).Where(&models.Assets{
-			UID: a.UID,
-		}).Count(&count).Error; err != nil {
+		// 如果Assets 与保留的发布清单中没有任何资源引用了a.UID
+		referenced, err := models.AssetsFileReferenced(db, a.UID)
+		if err != nil {
 			logrus.Errorf("Failed to count assets: %v", err)
 			c.JSON(500, gin.H{"error": "Failed to count assets"})
 			return
 		}
-		if count == 0 {
+		if !referenced {
 			// 则删除File中UID为a.UID的文件
-			if err := db.Unscoped().Where(&models.File{
-				UID: a.UID,
-			}).Delete(&models.File{}).Error; err != nil {
+			if err := models.DeleteFileByUID(a.UID); err != nil {
 				logrus.Errorf("Failed to delete file: %v", err)
 				c.JSON(500, gin.H{"error": "Failed to delete file"})
 			}
@@ -173,6 +167,8 @@ type GetAssetsReq struct {
 	Path      string `json:"path"`
 }
 
+// GetAssetsEndpoint 返回静态资源，支持条件请求、Range 以及预压缩的 br/gzip 文件
+// 按绑定配置处理目录索引、尾斜杠重定向、SPA 回退与自定义 404 页面
 func GetAssetsEndpoint(c *gin.Context) {
 
 	var req GetAssetsReq
@@ -183,58 +179,133 @@ func GetAssetsEndpoint(c *gin.Context) {
 		c.JSON(400, gin.H{"error": "Invalid request"})
 		return
 	}
+	// 只能读取资源凭证对应 worker 的资源
+	v, _ := c.Get(defs.KeyWorkerResource)
+	res, ok := v.(*models.WorkerResource)
+	if !ok || res.WorkerUID != req.WorkerUID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "resource not allowed"})
+		return
+	}
+	cfg := assetsConfig(req.WorkerUID, res.Binding)
 
+	// 候选路径与对应的预压缩文件一次查出
+	paths := []string{}
+	for _, p := range assetsCandidates(cfg, req.Path) {
+		paths = append(paths, p)
+		for _, e := range assetsEncodings {
+			paths = append(paths, p+e.Suffix)
+		}
+	}
 	db := database.GetDB()
-	var asset models.Assets
-	if err := db.Where(&models.Assets{
-		WorkerUID: req.WorkerUID,
-		Path:      req.Path,
-	}).First(&asset).Error; err != nil {
+	var found []models.Assets
+	if err := db.Where("worker_uid = ? AND path IN ?", req.WorkerUID, paths).Find(&found).Error; err != nil {
+		logrus.Errorf("Failed to find assets: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to find assets"})
+		return
+	}
+	assetsMap := make(map[string]*models.Assets)
+	for i := range found {
+		assetsMap[found[i].Path] = &found[i]
+	}
+
+	assetPath, location, status := resolveAssetPath(cfg, req.Path, func(p string) bool {
+		if _, ok := assetsMap[p]; ok {
+			return true
+		}
+		for _, e := range assetsEncodings {
+			if _, ok := assetsMap[p+e.Suffix]; ok {
+				return true
+			}
+		}
+		return false
+	})
+	if len(location) != 0 {
+		c.Redirect(status, location)
+		return
+	}
+	if len(assetPath) == 0 {
 		c.JSON(404, gin.H{"error": "Asset not found"})
 		return
 	}
 
-	cache, err := sys_cache.Get(AssetBucket + ":" + asset.UID + ":data")
-	if len(cache) == 0 || err != nil {
-		var file models.File
-		if err := db.Where(&models.File{
-			UID: asset.UID,
-		}).First(&file).Error; err != nil {
+	asset := assetsMap[assetPath]
+	mimeType := assetsMime(asset, assetPath)
+	encoding := ""
+	acceptEncoding := c.GetHeader("vvorker-accept-encoding")
+	for _, e := range assetsEncodings {
+		variant, ok := assetsMap[assetPath+e.Suffix]
+		if !ok {
+			continue
+		}
+		c.Header("Vary", "Accept-Encoding")
+		if encoding == "" && acceptsEncoding(acceptEncoding, e.Name) {
+			asset = variant
+			encoding = e.Name
+		}
+	}
+	if asset == nil {
+		c.JSON(404, gin.H{"error": "Asset not found"})
+		return
+	}
+
+	c.Header("Content-Type", mimeType)
+	if encoding != "" {
+		// 由 assets worker 设置 Content-Encoding，避免 workerd 在转发时解压
+		c.Header("vvorker-content-encoding", encoding)
+	}
+	if status == http.StatusNotFound {
+		// 404 页面不参与缓存协商
+		data, err := openAssetData(c, asset)
+		if err != nil {
 			c.JSON(404, gin.H{"error": "File not found"})
 			return
 		}
+		defer data.Close()
+		c.Header("Cache-Control", "no-cache")
+		c.Status(http.StatusNotFound)
+		io.Copy(c.Writer, data)
+		return
+	}
 
-		mimeType := mime.TypeByExtension(file.Mimetype)
-		if mimeType == "" {
-			// 如果没有匹配的 MIME 类型，默认使用 application/octet-stream
-			mimeType = "application/octet-stream"
+	c.Header("Cache-Control", assetsCacheControl(cfg, assetPath))
+	if asset.Hash != "" {
+		etag := `"` + asset.Hash + `"`
+		c.Header("ETag", etag)
+		// 未修改时不需要读取文件内容
+		if inm := c.GetHeader("If-None-Match"); inm != "" && etagMatch(inm, etag) {
+			c.Header("Last-Modified", asset.UpdatedAt.UTC().Format(http.TimeFormat))
+			c.Status(http.StatusNotModified)
+			return
 		}
+	}
 
-		if conf.AppConfigInstance.FileStorageUseOSS {
-			dd, err := oss.DownloadFileFromSysBucket(fmt.Sprintf("files/%d/%s", asset.UserID, asset.Hash))
-			if err == nil {
-				data, err := io.ReadAll(dd)
-				if err != nil {
-					c.Data(http.StatusNotFound, mimeType, file.Data)
-					return
-				}
-				file.Data = data
-			}
-			defer dd.Close()
-		}
+	data, err := openAssetData(c, asset)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "File not found"})
+		return
+	}
+	defer data.Close()
+	// If-Modified-Since、Range 与 If-Range 由 ServeContent 处理
+	http.ServeContent(c.Writer, c.Request, "", asset.UpdatedAt, data)
+}
 
-		sys_cache.Put(AssetBucket+":"+asset.UID+":data", file.Data, 3600)
-		sys_cache.Put(AssetBucket+":"+asset.UID+":mime", []byte(mimeType), 3600)
-		c.Data(200, mimeType, file.Data)
-	} else {
-		bmimeType, _ := sys_cache.Get(AssetBucket + ":" + asset.UID + ":mime")
-		mimeType := string(bmimeType)
-		if mimeType == "" {
-			// 如果没有匹配的 MIME 类型，默认使用 application/octet-stream
-			mimeType = "application/octet-stream"
-		}
-		c.Data(200, mimeType, cache)
+// openAssetData 打开资源对应的文件，文件所在的 blob 会被缓存，不需要每次查询 File
+func openAssetData(c *gin.Context, asset *models.Assets) (blobstore.File, error) {
+	blobKey := AssetBucket + ":" + asset.UID + ":blob"
+	if hash, err := sys_cache.Get(blobKey); err == nil && len(hash) != 0 {
+		return blobstore.OpenCached(c, string(hash))
 	}
+
+	var file models.File
+	if err := database.GetDB().Where(&models.File{
+		UID: asset.UID,
+	}).First(&file).Error; err != nil {
+		return nil, err
+	}
+	if file.InBlobStore {
+		sys_cache.Put(blobKey, []byte(file.Hash), 3600)
+	}
+	return file.OpenSeekable(c)
 }
 
 type CheckAssetsReq struct {
@@ -397,21 +468,17 @@ func DeleteAssetsEndpoint(c *gin.Context) {
 		return
 	}
 
-	// 检查是否还有其他 asset 引用同一个文件
-	count := int64(0)
-	if err := db.Model(&models.Assets{}).Where(&models.Assets{
-		UID: asset.UID,
-	}).Count(&count).Error; err != nil {
+	// 检查是否还有其他 asset 或保留的发布清单引用同一个文件
+	referenced, err := models.AssetsFileReferenced(db, asset.UID)
+	if err != nil {
 		logrus.Errorf("Failed to count assets: %v", err)
 		c.JSON(500, gin.H{"error": "Failed to count assets"})
 		return
 	}
 
-	// 如果没有其他 asset 引用该文件，则删除文件
-	if count == 0 {
-		if err := db.Unscoped().Where(&models.File{
-			UID: asset.UID,
-		}).Delete(&models.File{}).Error; err != nil {
+	// 如果没有其他引用，则删除文件
+	if !referenced {
+		if err := models.DeleteFileByUID(asset.UID); err != nil {
 			logrus.Errorf("Failed to delete file: %v", err)
 			c.JSON(500, gin.H{"error": "Failed to delete file"})
 			return
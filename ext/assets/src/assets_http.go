@@ -0,0 +1,186 @@
+package assets
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"vvorker/conf"
+	"vvorker/entities"
+	"vvorker/ext/kv/src/sys_cache"
+	"vvorker/models"
+	"vvorker/utils/database"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 没有匹配的规则时，每次使用前都向服务端验证，未修改时只返回 304
+const defaultAssetsCacheControl = "public, max-age=0, must-revalidate"
+
+// 预压缩的文件与原文件一同上传，例如 /app.js 对应 /app.js.br 与 /app.js.gz，按顺序优先使用
+var assetsEncodings = []struct {
+	Name   string
+	Suffix string
+}{
+	{Name: "br", Suffix: ".br"},
+	{Name: "gzip", Suffix: ".gz"},
+}
+
+// assetsConfig 获取请求所用绑定的配置，找不到时使用第一个 assets 绑定
+func assetsConfig(workerUID, binding string) *conf.Assets {
+	bytes, err := sys_cache.GlobalCache(AssetBucket+":"+workerUID+":config", func() ([]byte, error) {
+		var w models.Worker
+		if err := database.GetDB().Where(&models.Worker{
+			Worker: &entities.Worker{
+				UID: workerUID,
+			},
+		}).First(&w).Error; err != nil {
+			return nil, err
+		}
+		configs := []conf.Assets{}
+		if wc, err := conf.ParseWorkerConfig(w.Template); err == nil {
+			configs = append(configs, wc.Assets...)
+		}
+		return json.Marshal(configs)
+	}, 60)
+	if err != nil {
+		logrus.Warnf("Failed to get assets config: %v", err)
+		return &conf.Assets{}
+	}
+
+	configs := []conf.Assets{}
+	if err := json.Unmarshal(bytes, &configs); err != nil || len(configs) == 0 {
+		return &conf.Assets{}
+	}
+	for i := range configs {
+		b := configs[i].Binding
+		if len(b) == 0 {
+			b = "assets"
+		}
+		if b == binding {
+			return &configs[i]
+		}
+	}
+	return &configs[0]
+}
+
+// assetsCacheControl 按绑定配置中的规则获取路径对应的 Cache-Control
+func assetsCacheControl(cfg *conf.Assets, p string) string {
+	for _, rule := range cfg.CacheControl {
+		if matchAssetsPath(rule.Path, p) {
+			return rule.Value
+		}
+	}
+	return defaultAssetsCacheControl
+}
+
+// assetsCandidates 解析请求可能用到的全部路径，用于一次查出
+func assetsCandidates(cfg *conf.Assets, p string) []string {
+	dir := strings.TrimSuffix(p, "/")
+	paths := []string{p, dir}
+	for _, index := range cfg.IndexFiles {
+		paths = append(paths, dir+"/"+index)
+	}
+	if len(cfg.SPAFallback) != 0 {
+		paths = append(paths, cfg.SPAFallback)
+	}
+	if len(cfg.NotFoundPage) != 0 {
+		paths = append(paths, cfg.NotFoundPage)
+	}
+	return paths
+}
+
+// resolveAssetPath 依次尝试原路径、目录索引、SPA 回退与 404 页面
+// 返回实际使用的资源路径与状态码，需要重定向时返回重定向地址
+func resolveAssetPath(cfg *conf.Assets, p string, exists func(string) bool) (string, string, int) {
+	hasSlash := strings.HasSuffix(p, "/")
+	dir := strings.TrimSuffix(p, "/")
+
+	if !hasSlash && exists(p) {
+		return p, "", http.StatusOK
+	}
+	if hasSlash && p != "/" && cfg.TrailingSlash == conf.AssetsTrailingSlashRemove && exists(dir) {
+		return "", dir, http.StatusTemporaryRedirect
+	}
+
+	for _, index := range cfg.IndexFiles {
+		candidate := dir + "/" + index
+		if !exists(candidate) {
+			continue
+		}
+		switch {
+		case !hasSlash && cfg.TrailingSlash == conf.AssetsTrailingSlashAdd:
+			return "", p + "/", http.StatusTemporaryRedirect
+		case hasSlash && p != "/" && cfg.TrailingSlash == conf.AssetsTrailingSlashRemove:
+			return "", dir, http.StatusTemporaryRedirect
+		}
+		return candidate, "", http.StatusOK
+	}
+
+	if len(cfg.SPAFallback) != 0 && exists(cfg.SPAFallback) {
+		return cfg.SPAFallback, "", http.StatusOK
+	}
+	if len(cfg.NotFoundPage) != 0 && exists(cfg.NotFoundPage) {
+		return cfg.NotFoundPage, "", http.StatusNotFound
+	}
+	return "", "", http.StatusNotFound
+}
+
+func matchAssetsPath(pattern, p string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, "/**"); ok {
+		return p == prefix || strings.HasPrefix(p, prefix+"/")
+	}
+	ok, _ := path.Match(pattern, p)
+	return ok
+}
+
+// acceptsEncoding 判断 Accept-Encoding 是否接受该编码，q=0 表示拒绝
+// 明确列出的编码优先于 *
+func acceptsEncoding(header, encoding string) bool {
+	wildcard := false
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.TrimSpace(name)
+		if !strings.EqualFold(name, encoding) && name != "*" {
+			continue
+		}
+		accepted := true
+		if q, ok := strings.CutPrefix(strings.ToLower(strings.TrimSpace(params)), "q="); ok {
+			v, err := strconv.ParseFloat(q, 64)
+			accepted = err == nil && v > 0
+		}
+		if name != "*" {
+			return accepted
+		}
+		wildcard = accepted
+	}
+	return wildcard
+}
+
+// etagMatch 按 If-None-Match 的弱比较规则判断 ETag 是否匹配
+func etagMatch(header, etag string) bool {
+	for _, v := range strings.Split(header, ",") {
+		v = strings.TrimSpace(v)
+		if v == "*" || strings.TrimPrefix(v, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func assetsMime(asset *models.Assets, p string) string {
+	mimeType := ""
+	if asset != nil {
+		mimeType = mime.TypeByExtension(asset.MIME)
+	}
+	if mimeType == "" {
+		mimeType = mime.TypeByExtension(path.Ext(p))
+	}
+	if mimeType == "" {
+		// 如果没有匹配的 MIME 类型，默认使用 application/octet-stream
+		mimeType = "application/octet-stream"
+	}
+	return mimeType
+}
@@ -0,0 +1,170 @@
+package assets
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+	"vvorker/conf"
+)
+
+func TestAcceptsEncoding(t *testing.T) {
+	tests := []struct {
+		header   string
+		encoding string
+		want     bool
+	}{
+		{header: "", encoding: "br", want: false},
+		{header: "br", encoding: "br", want: true},
+		{header: "gzip, deflate, br", encoding: "br", want: true},
+		{header: "gzip, deflate", encoding: "br", want: false},
+		{header: "GZIP", encoding: "gzip", want: true},
+		{header: "br;q=0", encoding: "br", want: false},
+		{header: "br;q=0.0", encoding: "br", want: false},
+		{header: "br; q=0.5", encoding: "br", want: true},
+		{header: "br;Q=0", encoding: "br", want: false},
+		{header: "br;q=bad", encoding: "br", want: false},
+		{header: "gzip;q=1.0, br;q=0", encoding: "gzip", want: true},
+		{header: "gzip;q=1.0, br;q=0", encoding: "br", want: false},
+		{header: "*", encoding: "br", want: true},
+		{header: "*;q=0", encoding: "br", want: false},
+		{header: "br;q=0, *", encoding: "br", want: false},
+		{header: "*;q=0, br", encoding: "br", want: true},
+		{header: "*;q=0, gzip", encoding: "br", want: false},
+		{header: "gzip, *", encoding: "br", want: true},
+		{header: "brotli", encoding: "br", want: false},
+	}
+	for _, tt := range tests {
+		if got := acceptsEncoding(tt.header, tt.encoding); got != tt.want {
+			t.Errorf("acceptsEncoding(%q, %q) = %v, want %v", tt.header, tt.encoding, got, tt.want)
+		}
+	}
+}
+
+func TestETagMatch(t *testing.T) {
+	etag := `"abc"`
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{header: `"abc"`, want: true},
+		{header: `"abd"`, want: false},
+		{header: `abc`, want: false},
+		{header: `W/"abc"`, want: true},
+		{header: `w/"abc"`, want: false},
+		{header: `"x", "abc"`, want: true},
+		{header: `"x",W/"abc"`, want: true},
+		{header: `"x", "y"`, want: false},
+		{header: `*`, want: true},
+		{header: ` * `, want: true},
+		{header: `"*"`, want: false},
+	}
+	for _, tt := range tests {
+		if got := etagMatch(tt.header, etag); got != tt.want {
+			t.Errorf("etagMatch(%q, %q) = %v, want %v", tt.header, etag, got, tt.want)
+		}
+	}
+}
+
+func TestResolveAssetPath(t *testing.T) {
+	files := []string{"/index.html", "/app.js", "/docs/index.html", "/404.html", "/spa.html"}
+	index := []string{"index.html"}
+	tests := []struct {
+		name         string
+		cfg          conf.Assets
+		files        []string
+		path         string
+		wantPath     string
+		wantLocation string
+		wantStatus   int
+	}{
+		{name: "file", path: "/app.js", wantPath: "/app.js", wantStatus: http.StatusOK},
+		{name: "missing file", path: "/missing.js", wantStatus: http.StatusNotFound},
+		{name: "file with slash", path: "/app.js/", wantStatus: http.StatusNotFound},
+		{name: "root index", cfg: conf.Assets{IndexFiles: index}, path: "/", wantPath: "/index.html", wantStatus: http.StatusOK},
+		{name: "root without index", path: "/", wantStatus: http.StatusNotFound},
+		{name: "dir index", cfg: conf.Assets{IndexFiles: index}, path: "/docs", wantPath: "/docs/index.html", wantStatus: http.StatusOK},
+		{name: "dir index with slash", cfg: conf.Assets{IndexFiles: index}, path: "/docs/", wantPath: "/docs/index.html", wantStatus: http.StatusOK},
+		{name: "index order", cfg: conf.Assets{IndexFiles: []string{"default.htm", "index.html"}}, path: "/docs/", wantPath: "/docs/index.html", wantStatus: http.StatusOK},
+		{name: "add slash", cfg: conf.Assets{IndexFiles: index, TrailingSlash: conf.AssetsTrailingSlashAdd}, path: "/docs", wantLocation: "/docs/", wantStatus: http.StatusTemporaryRedirect},
+		{name: "add slash keeps slash", cfg: conf.Assets{IndexFiles: index, TrailingSlash: conf.AssetsTrailingSlashAdd}, path: "/docs/", wantPath: "/docs/index.html", wantStatus: http.StatusOK},
+		{name: "add slash on file", cfg: conf.Assets{IndexFiles: index, TrailingSlash: conf.AssetsTrailingSlashAdd}, path: "/app.js", wantPath: "/app.js", wantStatus: http.StatusOK},
+		{name: "remove slash", cfg: conf.Assets{IndexFiles: index, TrailingSlash: conf.AssetsTrailingSlashRemove}, path: "/docs/", wantLocation: "/docs", wantStatus: http.StatusTemporaryRedirect},
+		{name: "remove slash serves index", cfg: conf.Assets{IndexFiles: index, TrailingSlash: conf.AssetsTrailingSlashRemove}, path: "/docs", wantPath: "/docs/index.html", wantStatus: http.StatusOK},
+		{name: "remove slash on file", cfg: conf.Assets{TrailingSlash: conf.AssetsTrailingSlashRemove}, path: "/app.js/", wantLocation: "/app.js", wantStatus: http.StatusTemporaryRedirect},
+		{name: "remove slash keeps root", cfg: conf.Assets{IndexFiles: index, TrailingSlash: conf.AssetsTrailingSlashRemove}, path: "/", wantPath: "/index.html", wantStatus: http.StatusOK},
+		{name: "spa fallback", cfg: conf.Assets{IndexFiles: index, SPAFallback: "/spa.html"}, path: "/app/route", wantPath: "/spa.html", wantStatus: http.StatusOK},
+		{name: "spa fallback over 404 page", cfg: conf.Assets{SPAFallback: "/spa.html", NotFoundPage: "/404.html"}, path: "/app/route", wantPath: "/spa.html", wantStatus: http.StatusOK},
+		{name: "spa fallback not uploaded", cfg: conf.Assets{SPAFallback: "/missing.html", NotFoundPage: "/404.html"}, path: "/app/route", wantPath: "/404.html", wantStatus: http.StatusNotFound},
+		{name: "404 page", cfg: conf.Assets{NotFoundPage: "/404.html"}, path: "/missing", wantPath: "/404.html", wantStatus: http.StatusNotFound},
+		{name: "404 page not uploaded", cfg: conf.Assets{NotFoundPage: "/404.html"}, files: []string{"/app.js"}, path: "/missing", wantStatus: http.StatusNotFound},
+		{name: "existing file over spa", cfg: conf.Assets{SPAFallback: "/spa.html"}, path: "/app.js", wantPath: "/app.js", wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uploaded := files
+			if tt.files != nil {
+				uploaded = tt.files
+			}
+			// 处理请求时只查询候选路径，解析用到的路径必须都在候选路径中
+			candidates := assetsCandidates(&tt.cfg, tt.path)
+			exists := func(p string) bool {
+				if !slices.Contains(candidates, p) {
+					t.Errorf("path %q is not a candidate of %q", p, tt.path)
+				}
+				return slices.Contains(uploaded, p)
+			}
+
+			gotPath, gotLocation, gotStatus := resolveAssetPath(&tt.cfg, tt.path, exists)
+			if gotPath != tt.wantPath || gotLocation != tt.wantLocation || gotStatus != tt.wantStatus {
+				t.Errorf("resolveAssetPath(%q) = (%q, %q, %d), want (%q, %q, %d)", tt.path,
+					gotPath, gotLocation, gotStatus, tt.wantPath, tt.wantLocation, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestMatchAssetsPath(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{pattern: "/index.html", path: "/index.html", want: true},
+		{pattern: "/index.html", path: "/docs/index.html", want: false},
+		{pattern: "/static/**", path: "/static", want: true},
+		{pattern: "/static/**", path: "/static/app.js", want: true},
+		{pattern: "/static/**", path: "/static/js/app.js", want: true},
+		{pattern: "/static/**", path: "/staticfile", want: false},
+		{pattern: "/**", path: "/any/path.js", want: true},
+		{pattern: "/*.js", path: "/app.js", want: true},
+		{pattern: "/*.js", path: "/js/app.js", want: false},
+		{pattern: "/assets/*.css", path: "/assets/a.css", want: true},
+		{pattern: "*.js", path: "/app.js", want: false},
+		{pattern: "/[", path: "/[", want: false},
+	}
+	for _, tt := range tests {
+		if got := matchAssetsPath(tt.pattern, tt.path); got != tt.want {
+			t.Errorf("matchAssetsPath(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestAssetsCacheControl(t *testing.T) {
+	cfg := &conf.Assets{CacheControl: []conf.AssetsCacheRule{
+		{Path: "/static/**", Value: "public, max-age=31536000, immutable"},
+		{Path: "/*.html", Value: "no-cache"},
+	}}
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "/static/app.js", want: "public, max-age=31536000, immutable"},
+		{path: "/index.html", want: "no-cache"},
+		{path: "/docs/index.html", want: defaultAssetsCacheControl},
+	}
+	for _, tt := range tests {
+		if got := assetsCacheControl(cfg, tt.path); got != tt.want {
+			t.Errorf("assetsCacheControl(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
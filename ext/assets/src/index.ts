@@ -5,20 +5,53 @@ import { env } from 'cloudflare:workers'
 const eenv = env as unknown as any
 
 let commonConfig = {
-	"x-secret": eenv.X_SECRET,
+	"x-resource-token": eenv.RESOURCE_TOKEN,
 	"x-node-name": eenv.X_NODENAME,
 }
 
+// 条件请求与 Range 原样转发给 master
+const forwardHeaders = ["if-none-match", "if-modified-since", "if-range", "range"]
+
 export default {
 	async fetch(request: any, env: any) {
 		const url = new URL(request.url);
-		return fetch(`${eenv.MASTER_ENDPOINT}/api/ext/assets/get-assets`, {
-			method: "GET",
-			headers: {
-				...commonConfig,
-				"vvorker-asset-path": url.pathname,
-				"vvorker-asset-worker-uid": eenv.WORKER_UID,
+		const headers: Record<string, string> = {
+			...commonConfig,
+			"vvorker-asset-path": url.pathname,
+			"vvorker-asset-worker-uid": eenv.WORKER_UID,
+			"vvorker-asset-binding": eenv.ASSETS_BINDING || "",
+			"vvorker-accept-encoding": request.headers.get("accept-encoding") || "",
+		}
+		for (const name of forwardHeaders) {
+			const value = request.headers.get(name)
+			if (value) {
+				headers[name] = value
 			}
+		}
+		const resp = await fetch(`${eenv.MASTER_ENDPOINT}/api/ext/assets/get-assets`, {
+			method: request.method === "HEAD" ? "HEAD" : "GET",
+			headers,
+			redirect: "manual",
 		})
+		if (resp.status >= 300 && resp.status < 400 && resp.headers.get("location")) {
+			// 尾斜杠重定向保留原请求的查询参数
+			const respHeaders = new Headers(resp.headers)
+			respHeaders.set("location", resp.headers.get("location") + url.search)
+			return new Response(null, { status: resp.status, headers: respHeaders })
+		}
+		const encoding = resp.headers.get("vvorker-content-encoding")
+		if (!encoding) {
+			return resp
+		}
+		// 预压缩的内容直接返回，不再由 workerd 重新编码
+		const respHeaders = new Headers(resp.headers)
+		respHeaders.delete("vvorker-content-encoding")
+		respHeaders.set("content-encoding", encoding)
+		return new Response(resp.body, {
+			status: resp.status,
+			statusText: resp.statusText,
+			headers: respHeaders,
+			encodeBody: "manual",
+		} as any)
 	},
 };
\ No newline at end of file
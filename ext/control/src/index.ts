@@ -4,6 +4,11 @@ export default {
 		if (body.type === "scheduled") {
 			ctx.waitUntil(env.worker.scheduled(body))
 		}
+		if (body.type === "health") {
+			return new Response(JSON.stringify({
+				code: env.worker ? 0 : 1,
+			}))
+		}
 		return new Response(JSON.stringify({
 			code: 0,
 		}))
@@ -1,10 +1,82 @@
+export interface KVCASResult {
+    // 是否写入
+    ok: boolean;
+    // 操作之后的值，key 不存在时为 null
+    value: string | null;
+    // 操作之后的版本，key 不存在时为空字符串
+    version: string;
+}
+
+// 值可以是字符串或二进制数据，二进制数据按 base64 传输
+export type KVValue = string | ArrayBuffer | ArrayBufferView;
+
+export type KVValueType = "text" | "json" | "arrayBuffer";
+
+// 每个 key 可以附带的元数据，序列化之后不超过 1024 字节
+export interface KVMetadata {
+    contentType?: string;
+    [key: string]: unknown;
+}
+
+export interface KVListKey<M = KVMetadata> {
+    name: string;
+    // 过期时间的 unix 秒数，没有过期时间时不返回
+    expiration?: number;
+    metadata?: M;
+    // 只有 values 为 true 时返回
+    value?: any;
+}
+
+export interface KVListOptions {
+    prefix?: string;
+    // 每页的最大数量，默认且最多为 1000，实际返回的数量可能更少
+    limit?: number;
+    // 上一页返回的 cursor
+    cursor?: string;
+    // 是否同时返回值
+    values?: boolean;
+    type?: KVValueType;
+}
+
+export type KVListResult<M = KVMetadata> = {
+    keys: KVListKey<M>[];
+    list_complete: false;
+    cursor: string;
+} | {
+    keys: KVListKey<M>[];
+    list_complete: true;
+}
+
 export interface KVBinding {
-    get(key: string): Promise<string | null>;
-    set(key: string, value: string, options?: {
+    get(key: string, type?: "text"): Promise<string | null>;
+    get<T = unknown>(key: string, type: "json"): Promise<T | null>;
+    get(key: string, type: "arrayBuffer"): Promise<ArrayBuffer | null>;
+    getWithMetadata<M = KVMetadata>(key: string, type?: "text"): Promise<{ value: string | null, metadata: M | null }>;
+    getWithMetadata<T = unknown, M = KVMetadata>(key: string, type: "json"): Promise<{ value: T | null, metadata: M | null }>;
+    getWithMetadata<M = KVMetadata>(key: string, type: "arrayBuffer"): Promise<{ value: ArrayBuffer | null, metadata: M | null }>;
+    // 写入时没有传 metadata 会删除已有的元数据
+    set(key: string, value: KVValue, options?: {
         EX?: number,
         NX?: boolean,
-        XX?: boolean
+        XX?: boolean,
+        metadata?: KVMetadata
     } | number): Promise<number>;
     del(key: string): Promise<void>;
+    /** @deprecated 使用 list */
     keys(pattern: string, offset: number, size: number): Promise<string[]>;
-}
\ No newline at end of file
+    list<M = KVMetadata>(options?: KVListOptions): Promise<KVListResult<M>>;
+    // 原子地加上 delta（默认 1）并返回新值，ttl 只在 key 没有过期时间时设置
+    incr(key: string, delta?: number, ttl?: number): Promise<number>;
+    decr(key: string, delta?: number, ttl?: number): Promise<number>;
+    mget(keys: string[]): Promise<(string | null)[]>;
+    mset(entries: Record<string, string>, ttl?: number): Promise<void>;
+    // 返回实际删除的数量
+    mdel(keys: string[]): Promise<number>;
+    // 当前值等于 expected（或版本等于 version）时写入，expected 为 null 时要求 key 不存在
+    cas(key: string, expected: string | null | { version: string }, value: string, ttl?: number): Promise<KVCASResult>;
+    getWithVersion(key: string): Promise<KVCASResult>;
+    // 剩余的过期时间（秒），没有过期时间时为 -1，key 不存在时为 -2
+    ttl(key: string): Promise<number>;
+    // 设置过期时间，ttl 小于等于 0 时取消过期，key 不存在时返回 false
+    expire(key: string, ttl: number): Promise<boolean>;
+}
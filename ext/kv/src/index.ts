@@ -1,5 +1,6 @@
 // filepath: src/index.ts
 export * from "./binding"
+import type { KVCASResult, KVListOptions, KVListResult, KVMetadata, KVValue, KVValueType } from "./binding"
 import { WorkerEntrypoint, env } from 'cloudflare:workers'
 
 const eenv = env as unknown as any
@@ -9,7 +10,7 @@ const prefix = eenv.RESOURCE_ID.length > 0 ? eenv.RESOURCE_ID + ":" : ""
 
 const masterEndpoint = eenv.MASTER_ENDPOINT
 const commonConfig = {
-	"x-secret": eenv.X_SECRET,
+	"x-resource-token": eenv.RESOURCE_TOKEN,
 	"x-node-name": eenv.X_NODENAME,
 	"resource-id": eenv.RESOURCE_ID,
 }
@@ -34,31 +35,96 @@ async function invoke<T>(config: any) {
 	return r.data
 }
 
+// 新增的方法失败时抛出异常，避免计数等操作静默返回错误的结果
+async function mustInvoke<T>(config: any) {
+	const r = await invoke<T>(config)
+	if (r === undefined) {
+		throw new Error(`kv ${config.method} failed`)
+	}
+	return r
+}
+
+function toBase64(data: ArrayBuffer | ArrayBufferView): string {
+	const bytes = data instanceof ArrayBuffer
+		? new Uint8Array(data)
+		: new Uint8Array(data.buffer, data.byteOffset, data.byteLength)
+	let s = ""
+	// 分段转换，避免参数过多
+	for (let i = 0; i < bytes.length; i += 0x8000) {
+		s += String.fromCharCode(...bytes.subarray(i, i + 0x8000))
+	}
+	return btoa(s)
+}
+
+function fromBase64(s: string): ArrayBuffer {
+	const bin = atob(s)
+	const bytes = new Uint8Array(bin.length)
+	for (let i = 0; i < bin.length; i++) {
+		bytes[i] = bin.charCodeAt(i)
+	}
+	return bytes.buffer
+}
+
+// 二进制的值按 base64 传输
+function encodeValue(value: KVValue) {
+	return typeof value === "string"
+		? { value: value }
+		: { value: toBase64(value), encoding: "base64" }
+}
+
+function decodeValue(value: string | null | undefined, type?: KVValueType): any {
+	if (value === null || value === undefined) {
+		return null
+	}
+	switch (type) {
+		case "arrayBuffer":
+			return fromBase64(value)
+		case "json":
+			return JSON.parse(value)
+		default:
+			return value
+	}
+}
+
 export default class KV extends WorkerEntrypoint {
 	constructor(ctx: any, env: any) {
 		super(ctx, env)
 	}
 
 
-	async get(key: string): Promise<string | null> {
-		return await invoke<string>({
+	async get(key: string, type?: KVValueType): Promise<any> {
+		return decodeValue(await invoke<string>({
 			method: "get",
-			key: key
-		}) ?? null
+			key: key,
+			encoding: type === "arrayBuffer" ? "base64" : undefined
+		}), type)
+	}
+
+	async getWithMetadata<M = KVMetadata>(key: string, type?: KVValueType): Promise<{ value: any, metadata: M | null }> {
+		const r = await invoke<{ value: string | null, metadata: M | null }>({
+			method: "getWithMetadata",
+			key: key,
+			encoding: type === "arrayBuffer" ? "base64" : undefined
+		})
+		return {
+			value: decodeValue(r?.value, type),
+			metadata: r?.metadata ?? null
+		}
 	}
 
-	async set(key: string, value: string, options?: {
+	async set(key: string, value: KVValue, options?: {
 		EX?: number,
 		NX?: boolean,
-		XX?: boolean
+		XX?: boolean,
+		metadata?: KVMetadata
 	} | number): Promise<number> {
+		const { metadata, ...opts } = typeof options === "number" ? { EX: options } : options ?? {}
 		return await invoke<number>({
 			method: "set",
 			key: key,
-			value: value,
-			options: typeof options === "number" ? {
-				EX: options
-			} : options
+			...encodeValue(value),
+			metadata: metadata,
+			options: opts
 		}) ?? -1
 	}
 
@@ -77,4 +143,96 @@ export default class KV extends WorkerEntrypoint {
 			size: size
 		}) ?? []
 	}
+
+	async list<M = KVMetadata>(options?: KVListOptions): Promise<KVListResult<M>> {
+		const r = await mustInvoke<KVListResult<M>>({
+			method: "list",
+			prefix: options?.prefix ?? "",
+			limit: options?.limit ?? 0,
+			cursor: options?.cursor ?? "",
+			values: options?.values ?? false,
+			encoding: options?.type === "arrayBuffer" ? "base64" : undefined
+		})
+		if (options?.values) {
+			for (const k of r.keys) {
+				k.value = decodeValue(k.value, options.type)
+			}
+		}
+		return r
+	}
+
+	async incr(key: string, delta?: number, ttl?: number): Promise<number> {
+		return await mustInvoke<number>({
+			method: "incr",
+			key: key,
+			delta: delta ?? 1,
+			options: { EX: ttl ?? 0 }
+		})
+	}
+
+	async decr(key: string, delta?: number, ttl?: number): Promise<number> {
+		return await mustInvoke<number>({
+			method: "decr",
+			key: key,
+			delta: delta ?? 1,
+			options: { EX: ttl ?? 0 }
+		})
+	}
+
+	async mget(keys: string[]): Promise<(string | null)[]> {
+		return await mustInvoke<(string | null)[]>({
+			method: "mget",
+			keys: keys
+		})
+	}
+
+	async mset(entries: Record<string, string>, ttl?: number): Promise<void> {
+		await mustInvoke<null>({
+			method: "mset",
+			entries: Object.entries(entries).map(([key, value]) => ({ key, value })),
+			options: { EX: ttl ?? 0 }
+		})
+	}
+
+	async mdel(keys: string[]): Promise<number> {
+		return await mustInvoke<number>({
+			method: "mdel",
+			keys: keys
+		})
+	}
+
+	async cas(key: string, expected: string | null | { version: string }, value: string, ttl?: number): Promise<KVCASResult> {
+		const condition = expected !== null && typeof expected === "object"
+			? { version: expected.version }
+			: { expected: expected }
+		return await mustInvoke<KVCASResult>({
+			method: "cas",
+			key: key,
+			value: value,
+			...condition,
+			options: { EX: ttl ?? 0 }
+		})
+	}
+
+	async getWithVersion(key: string): Promise<KVCASResult> {
+		return await mustInvoke<KVCASResult>({
+			method: "getWithVersion",
+			key: key
+		})
+	}
+
+	async ttl(key: string): Promise<number> {
+		return await mustInvoke<number>({
+			method: "ttl",
+			key: key
+		})
+	}
+
+	async expire(key: string, ttl: number): Promise<boolean> {
+		return await mustInvoke<boolean>({
+			method: "expire",
+			key: key,
+			options: { EX: ttl }
+		})
+	}
 }
\ No newline at end of file
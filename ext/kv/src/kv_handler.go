@@ -1,15 +1,19 @@
 package kv
 
 import (
+	"encoding/json"
 	"net/http"
+	"strings"
 	"vvorker/common"
 	"vvorker/conf"
+	"vvorker/defs"
 	"vvorker/entities"
 	_ "vvorker/ext/kv/src/kv_nutsdb"
 	kvnutsdb "vvorker/ext/kv/src/kv_nutsdb"
 	kvredis "vvorker/ext/kv/src/kv_redis"
 	kvtypes "vvorker/ext/kv/src/kv_types"
 	"vvorker/models"
+	"vvorker/rpc"
 	"vvorker/utils"
 	"vvorker/utils/database"
 
@@ -120,13 +124,63 @@ func DeleteKVResourcesEndpoint(c *gin.Context) {
 
 /////////////////////
 
-func InvokeKVEndpoint(c *gin.Context) {
+// servesKVLocally redis 各节点可以直接访问，nutsdb 只有 master 上的是主库，其他节点需要转发给 master
+func servesKVLocally() bool {
+	return conf.AppConfigInstance.KVProvider == "redis" || conf.IsMaster()
+}
+
+// bindKVRequest 读取请求并检查只访问资源凭证对应的 KV
+func bindKVRequest(c *gin.Context) (*kvtypes.InvokeKVRequest, bool) {
 	var req = kvtypes.InvokeKVRequest{}
 	if err := c.ShouldBindWith(&req, binding.JSON); err != nil {
 		common.RespErr(c, http.StatusBadRequest, "invalid request", gin.H{"error": err.Error()})
+		return nil, false
+	}
+	v, _ := c.Get(defs.KeyWorkerResource)
+	if res, ok := v.(*models.WorkerResource); !ok || len(res.ResourceID) == 0 || res.ResourceID != req.RID {
+		common.RespErr(c, http.StatusForbidden, "forbidden", gin.H{"error": "resource not allowed"})
+		return nil, false
+	}
+	return &req, true
+}
+
+// InvokeKVEndpoint worker 通过本节点访问 KV，所有节点都提供
+func InvokeKVEndpoint(c *gin.Context) {
+	req, ok := bindKVRequest(c)
+	if !ok {
+		return
+	}
+	if !servesKVLocally() {
+		forwardKVInvoke(c, req)
+		return
+	}
+	invokeKV(c, req)
+}
+
+// AgentInvokeKVEndpoint 节点转发的 KV 请求，master 会再次校验转发来的资源凭证
+func AgentInvokeKVEndpoint(c *gin.Context) {
+	req, ok := bindKVRequest(c)
+	if !ok {
+		return
+	}
+	invokeKV(c, req)
+}
+
+// forwardKVInvoke 把请求连同 worker 的资源凭证转发给 master，并原样返回结果
+func forwardKVInvoke(c *gin.Context, req *kvtypes.InvokeKVRequest) {
+	url := conf.AppConfigInstance.MasterEndpoint + "/api/agent/kv/invoke"
+	reqResp, err := rpc.RPCWrapper().
+		SetHeader(defs.HeaderResourceToken, c.GetHeader(defs.HeaderResourceToken)).
+		SetBody(req).
+		Post(url)
+	if err != nil {
+		common.RespErr(c, http.StatusBadGateway, "Failed to forward KV request", gin.H{"error": err.Error()})
 		return
 	}
+	c.Data(reqResp.StatusCode, reqResp.GetContentType(), reqResp.Bytes())
+}
 
+func invokeKV(c *gin.Context, req *kvtypes.InvokeKVRequest) {
 	switch req.Method {
 	case "get":
 		{
@@ -135,47 +189,35 @@ func InvokeKVEndpoint(c *gin.Context) {
 				common.RespErr(c, http.StatusInternalServerError, "Failed to get KV resource", gin.H{"error": err.Error()})
 				return
 			}
-			if value != nil {
-				common.RespOK(c, "success", string(value))
-			} else {
-				common.RespOK(c, "success", nil)
+			common.RespOK(c, "success", req.EncodeValue(value))
+		}
+	case "getWithMetadata":
+		{
+			value, metadata, err := kvStorage.GetWithMetadata(req.RID, req.Key)
+			if err != nil {
+				common.RespErr(c, http.StatusInternalServerError, "Failed to get KV resource", gin.H{"error": err.Error()})
+				return
 			}
+			common.RespOK(c, "success", KVValueWithMetadata{
+				Value:    req.EncodeValue(value),
+				Metadata: metadata,
+			})
 		}
 	case "set":
 		{
-			if req.Options.NX {
-				code, err := kvStorage.PutNX(req.RID, req.Key, []byte(req.Value), req.Options.EX)
-				if code != 0 {
-					common.RespOK(c, "success", code)
-					return
-				}
-				if err != nil {
-					common.RespErr(c, http.StatusInternalServerError, "Failed to setNX KV resource", gin.H{"error": err.Error()})
-					return
-				}
-
-			} else if req.Options.XX {
-				code, err := kvStorage.PutXX(req.RID, req.Key, []byte(req.Value), req.Options.EX)
-				if code != 0 {
-					common.RespOK(c, "success", code)
-					return
-				}
-				if err != nil {
-					common.RespErr(c, http.StatusInternalServerError, "Failed to setXX KV resource", gin.H{"error": err.Error()})
-					return
-				}
-
-			} else {
-				code, err := kvStorage.Put(req.RID, req.Key, []byte(req.Value), req.Options.EX)
-				if code != 0 {
-					common.RespOK(c, "success", code)
-					return
-				}
-				if err != nil {
-					common.RespErr(c, http.StatusInternalServerError, "Failed to setEX KV resource", gin.H{"error": err.Error()})
-					return
-				}
-
+			value, err := req.DecodeValue(req.Value)
+			if err != nil {
+				common.RespErr(c, http.StatusBadRequest, "invalid request", gin.H{"error": err.Error()})
+				return
+			}
+			metadata := req.MetadataBytes()
+			if len(metadata) > kvtypes.MaxMetadataSize {
+				common.RespErr(c, http.StatusBadRequest, "invalid request", gin.H{"error": "metadata too large"})
+				return
+			}
+			if _, err := kvStorage.PutWithMetadata(req.RID, req.Key, value, metadata, req.Options.EX, req.Options.NX, req.Options.XX); err != nil {
+				common.RespErr(c, http.StatusInternalServerError, "Failed to set KV resource", gin.H{"error": err.Error()})
+				return
 			}
 			common.RespOK(c, "success", nil)
 		}
@@ -189,15 +231,231 @@ func InvokeKVEndpoint(c *gin.Context) {
 		}
 	case "keys":
 		{
-			keys, err := kvStorage.Keys(req.RID, req.Key, req.Offset, req.Size)
+			keys, err := listKeys(req)
 			if err != nil {
 				common.RespErr(c, http.StatusInternalServerError, "Failed to get KV resource", gin.H{"error": err.Error()})
 				return
 			}
 			common.RespOK(c, "success", keys)
 		}
+	case "list":
+		{
+			limit := req.Limit
+			if limit <= 0 {
+				limit = kvtypes.DefaultListLimit
+			}
+			if limit > kvtypes.MaxListLimit {
+				common.RespErr(c, http.StatusBadRequest, "invalid request", gin.H{"error": "limit too large"})
+				return
+			}
+			result, err := kvStorage.List(req.RID, req.Prefix, req.Cursor, limit, req.Values)
+			if err != nil {
+				common.RespErr(c, http.StatusInternalServerError, "Failed to list KV resource", gin.H{"error": err.Error()})
+				return
+			}
+			resp := KVListResp{
+				Keys:         make([]KVListKeyResp, 0, len(result.Keys)),
+				ListComplete: result.ListComplete,
+				Cursor:       result.Cursor,
+			}
+			for _, k := range result.Keys {
+				item := KVListKeyResp{Name: k.Name, Metadata: k.Metadata, Expiration: k.Expiration}
+				if req.Values {
+					item.Value = req.EncodeValue(k.Value)
+				}
+				resp.Keys = append(resp.Keys, item)
+			}
+			common.RespOK(c, "success", resp)
+		}
+	case "incr", "decr":
+		{
+			delta := int64(1)
+			if req.Delta != nil {
+				delta = *req.Delta
+			}
+			if req.Method == "decr" {
+				delta = -delta
+			}
+			value, err := kvStorage.Incr(req.RID, req.Key, delta, req.Options.EX)
+			if err != nil {
+				common.RespErr(c, http.StatusInternalServerError, "Failed to incr KV resource", gin.H{"error": err.Error()})
+				return
+			}
+			common.RespOK(c, "success", value)
+		}
+	case "mget":
+		{
+			if !checkKVBatch(c, len(req.Keys)) {
+				return
+			}
+			values, err := kvStorage.MGet(req.RID, req.Keys)
+			if err != nil {
+				common.RespErr(c, http.StatusInternalServerError, "Failed to get KV resource", gin.H{"error": err.Error()})
+				return
+			}
+			result := make([]*string, len(values))
+			for i, v := range values {
+				result[i] = req.EncodeValue(v)
+			}
+			common.RespOK(c, "success", result)
+		}
+	case "mset":
+		{
+			if !checkKVBatch(c, len(req.Entries)) {
+				return
+			}
+			for i := range req.Entries {
+				value, err := req.DecodeValue(req.Entries[i].Value)
+				if err != nil {
+					common.RespErr(c, http.StatusBadRequest, "invalid request", gin.H{"error": err.Error()})
+					return
+				}
+				req.Entries[i].Value = string(value)
+			}
+			if err := kvStorage.MSet(req.RID, req.Entries, req.Options.EX); err != nil {
+				common.RespErr(c, http.StatusInternalServerError, "Failed to set KV resource", gin.H{"error": err.Error()})
+				return
+			}
+			common.RespOK(c, "success", nil)
+		}
+	case "mdel":
+		{
+			if !checkKVBatch(c, len(req.Keys)) {
+				return
+			}
+			count, err := kvStorage.MDel(req.RID, req.Keys)
+			if err != nil {
+				common.RespErr(c, http.StatusInternalServerError, "Failed to delete KV resource", gin.H{"error": err.Error()})
+				return
+			}
+			common.RespOK(c, "success", count)
+		}
+	case "cas":
+		{
+			value, err := req.DecodeValue(req.Value)
+			if err != nil {
+				common.RespErr(c, http.StatusBadRequest, "invalid request", gin.H{"error": err.Error()})
+				return
+			}
+			match, err := req.CASMatcher()
+			if err != nil {
+				common.RespErr(c, http.StatusBadRequest, "invalid request", gin.H{"error": err.Error()})
+				return
+			}
+			ok, current, err := kvStorage.CAS(req.RID, req.Key, match, value, req.Options.EX)
+			if err != nil {
+				common.RespErr(c, http.StatusInternalServerError, "Failed to cas KV resource", gin.H{"error": err.Error()})
+				return
+			}
+			common.RespOK(c, "success", newKVCASResp(req, ok, current))
+		}
+	case "getWithVersion":
+		{
+			value, err := kvStorage.Get(req.RID, req.Key)
+			if err != nil {
+				common.RespErr(c, http.StatusInternalServerError, "Failed to get KV resource", gin.H{"error": err.Error()})
+				return
+			}
+			common.RespOK(c, "success", newKVCASResp(req, value != nil, value))
+		}
+	case "ttl":
+		{
+			ttl, err := kvStorage.TTL(req.RID, req.Key)
+			if err != nil {
+				common.RespErr(c, http.StatusInternalServerError, "Failed to get KV resource ttl", gin.H{"error": err.Error()})
+				return
+			}
+			common.RespOK(c, "success", ttl)
+		}
+	case "expire":
+		{
+			ok, err := kvStorage.Expire(req.RID, req.Key, req.Options.EX)
+			if err != nil {
+				common.RespErr(c, http.StatusInternalServerError, "Failed to expire KV resource", gin.H{"error": err.Error()})
+				return
+			}
+			common.RespOK(c, "success", ok)
+		}
 	default:
 		common.RespErr(c, http.StatusBadRequest, "invalid request", gin.H{"error": "invalid request"})
 		return
 	}
 }
+
+// listKeys 兼容旧的 keys 方法，pattern 只支持末尾的 *，按 offset 与 size 返回 key
+func listKeys(req *kvtypes.InvokeKVRequest) ([]string, error) {
+	pattern := req.Pattern
+	if pattern == "" {
+		pattern = req.Key
+	}
+	prefix := strings.TrimSuffix(pattern, "*")
+	size := req.Size
+	if size <= 0 || size > kvtypes.MaxListLimit {
+		size = kvtypes.MaxListLimit
+	}
+
+	keys := []string{}
+	skip := max(req.Offset, 0)
+	cursor := ""
+	for {
+		result, err := kvStorage.List(req.RID, prefix, cursor, kvtypes.MaxListLimit, false)
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range result.Keys {
+			if skip > 0 {
+				skip--
+				continue
+			}
+			keys = append(keys, k.Name)
+			if len(keys) == size {
+				return keys, nil
+			}
+		}
+		if result.ListComplete {
+			return keys, nil
+		}
+		cursor = result.Cursor
+	}
+}
+
+type KVListKeyResp struct {
+	Name       string          `json:"name"`
+	Value      *string         `json:"value,omitempty"`
+	Metadata   json.RawMessage `json:"metadata,omitempty"`
+	Expiration int64           `json:"expiration,omitempty"` // unix 秒数
+}
+
+type KVListResp struct {
+	Keys         []KVListKeyResp `json:"keys"`
+	ListComplete bool            `json:"list_complete"`
+	Cursor       string          `json:"cursor,omitempty"`
+}
+
+// 批量操作一次最多处理的 key 数量
+const maxKVBatchSize = 1000
+
+func checkKVBatch(c *gin.Context, size int) bool {
+	if size > maxKVBatchSize {
+		common.RespErr(c, http.StatusBadRequest, "invalid request", gin.H{"error": "too many keys"})
+		return false
+	}
+	return true
+}
+
+type KVCASResp struct {
+	OK      bool    `json:"ok"`
+	Value   *string `json:"value"`
+	Version string  `json:"version"`
+}
+
+// newKVCASResp cas 与 getWithVersion 的结果，value 为操作之后的值
+func newKVCASResp(req *kvtypes.InvokeKVRequest, ok bool, value []byte) KVCASResp {
+	return KVCASResp{OK: ok, Value: req.EncodeValue(value), Version: kvtypes.KVVersion(value)}
+}
+
+// KVValueWithMetadata getWithMetadata 的结果，没有元数据时 metadata 为 null
+type KVValueWithMetadata struct {
+	Value    *string         `json:"value"`
+	Metadata json.RawMessage `json:"metadata"`
+}
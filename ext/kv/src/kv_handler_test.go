@@ -0,0 +1,286 @@
+package kv
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"vvorker/common"
+	kvtypes "vvorker/ext/kv/src/kv_types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// pagedKV 只实现测试用到的方法，List 每页最多返回 pageSize 个 key，cursor 为下一页的下标
+type pagedKV struct {
+	kvtypes.IKVStorage
+	keys     []string
+	pageSize int
+	prefixes []string
+}
+
+func (s *pagedKV) List(bucket string, prefix string, cursor string, limit int, withValues bool) (*kvtypes.KVListResult, error) {
+	s.prefixes = append(s.prefixes, prefix)
+	start := 0
+	if cursor != "" {
+		n, err := strconv.Atoi(cursor)
+		if err != nil || n < 0 {
+			return nil, errors.New("invalid cursor")
+		}
+		start = n
+	}
+	matched := []string{}
+	for _, k := range s.keys {
+		if strings.HasPrefix(k, prefix) {
+			matched = append(matched, k)
+		}
+	}
+	start = min(start, len(matched))
+	end := min(start+min(limit, s.pageSize), len(matched))
+	result := &kvtypes.KVListResult{Keys: []kvtypes.KVListKey{}, ListComplete: end == len(matched)}
+	for _, k := range matched[start:end] {
+		result.Keys = append(result.Keys, kvtypes.KVListKey{Name: k})
+	}
+	if !result.ListComplete {
+		result.Cursor = strconv.Itoa(end)
+	}
+	return result, nil
+}
+
+func useTestKV(t *testing.T, s kvtypes.IKVStorage) {
+	t.Helper()
+	origin := kvStorage
+	kvStorage = s
+	t.Cleanup(func() { kvStorage = origin })
+}
+
+// invokeTestKV 调用 invokeKV 并返回响应中的 code 与 data
+func invokeTestKV(t *testing.T, req *kvtypes.InvokeKVRequest) (int, json.RawMessage) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/", nil)
+	invokeKV(c, req)
+
+	var resp struct {
+		Code int             `json:"code"`
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response %q: %v", w.Body.String(), err)
+	}
+	return resp.Code, resp.Data
+}
+
+func TestListKeys(t *testing.T) {
+	keys := []string{"b0"}
+	for i := 0; i < 10; i++ {
+		keys = append(keys, "k"+strconv.Itoa(i))
+	}
+	// 旧的 keys 按 key 的顺序跳过 offset 个，最多返回 size 个
+	oldKeys := func(prefix string, offset, size int) []string {
+		matched := []string{}
+		for _, k := range keys {
+			if strings.HasPrefix(k, prefix) {
+				matched = append(matched, k)
+			}
+		}
+		offset = min(max(offset, 0), len(matched))
+		return matched[offset:min(offset+size, len(matched))]
+	}
+
+	tests := []struct {
+		name       string
+		req        kvtypes.InvokeKVRequest
+		want       []string
+		wantPrefix string
+	}{
+		{name: "all", req: kvtypes.InvokeKVRequest{Pattern: "k*"}, want: oldKeys("k", 0, 10), wantPrefix: "k"},
+		{name: "offset and size across pages", req: kvtypes.InvokeKVRequest{Pattern: "k*", Offset: 2, Size: 3}, want: oldKeys("k", 2, 3), wantPrefix: "k"},
+		{name: "size past end", req: kvtypes.InvokeKVRequest{Pattern: "k*", Offset: 8, Size: 5}, want: oldKeys("k", 8, 5), wantPrefix: "k"},
+		{name: "offset past end", req: kvtypes.InvokeKVRequest{Pattern: "k*", Offset: 20, Size: 5}, want: []string{}, wantPrefix: "k"},
+		{name: "negative offset", req: kvtypes.InvokeKVRequest{Pattern: "k*", Offset: -3, Size: 2}, want: oldKeys("k", 0, 2), wantPrefix: "k"},
+		{name: "key as pattern", req: kvtypes.InvokeKVRequest{Key: "k"}, want: oldKeys("k", 0, 10), wantPrefix: "k"},
+		{name: "pattern over key", req: kvtypes.InvokeKVRequest{Key: "k", Pattern: "b*"}, want: []string{"b0"}, wantPrefix: "b"},
+		{name: "empty pattern", req: kvtypes.InvokeKVRequest{Size: 4}, want: oldKeys("", 0, 4), wantPrefix: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &pagedKV{keys: keys, pageSize: 3}
+			useTestKV(t, s)
+
+			got, err := listKeys(&tt.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got == nil || !slices.Equal(got, tt.want) {
+				t.Errorf("listKeys() = %v, want %v", got, tt.want)
+			}
+			if len(s.prefixes) == 0 || s.prefixes[0] != tt.wantPrefix {
+				t.Errorf("list prefixes = %q, want %q", s.prefixes, tt.wantPrefix)
+			}
+		})
+	}
+}
+
+func TestInvokeKVList(t *testing.T) {
+	keys := []string{"a0", "a1", "a2", "a3", "a4"}
+	tests := []struct {
+		name         string
+		req          kvtypes.InvokeKVRequest
+		wantCode     int
+		wantKeys     []string
+		wantComplete bool
+		wantCursor   string
+	}{
+		{name: "first page", req: kvtypes.InvokeKVRequest{Method: "list", Prefix: "a", Limit: 2}, wantKeys: []string{"a0", "a1"}, wantCursor: "2"},
+		{name: "next page", req: kvtypes.InvokeKVRequest{Method: "list", Prefix: "a", Limit: 2, Cursor: "2"}, wantKeys: []string{"a2", "a3"}, wantCursor: "4"},
+		{name: "last page", req: kvtypes.InvokeKVRequest{Method: "list", Prefix: "a", Limit: 2, Cursor: "4"}, wantKeys: []string{"a4"}, wantComplete: true},
+		{name: "default limit", req: kvtypes.InvokeKVRequest{Method: "list"}, wantKeys: keys, wantComplete: true},
+		{name: "invalid cursor", req: kvtypes.InvokeKVRequest{Method: "list", Cursor: "bad"}, wantCode: http.StatusInternalServerError},
+		{name: "limit too large", req: kvtypes.InvokeKVRequest{Method: "list", Limit: kvtypes.MaxListLimit + 1}, wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTestKV(t, &pagedKV{keys: keys, pageSize: kvtypes.MaxListLimit})
+
+			code, data := invokeTestKV(t, &tt.req)
+			if tt.wantCode != 0 {
+				if code != tt.wantCode {
+					t.Errorf("code = %d, want %d", code, tt.wantCode)
+				}
+				return
+			}
+			if code != common.RespCodeOK {
+				t.Fatalf("code = %d, data = %s", code, data)
+			}
+			var resp KVListResp
+			if err := json.Unmarshal(data, &resp); err != nil {
+				t.Fatal(err)
+			}
+			names := []string{}
+			for _, k := range resp.Keys {
+				names = append(names, k.Name)
+			}
+			if !slices.Equal(names, tt.wantKeys) || resp.ListComplete != tt.wantComplete || resp.Cursor != tt.wantCursor {
+				t.Errorf("list = %v complete %v cursor %q, want %v complete %v cursor %q",
+					names, resp.ListComplete, resp.Cursor, tt.wantKeys, tt.wantComplete, tt.wantCursor)
+			}
+			if bytes.Contains(data, []byte(`"value"`)) {
+				t.Errorf("values returned without values option: %s", data)
+			}
+		})
+	}
+}
+
+// memKV 内存中的值与元数据，只实现 set 与 get 用到的方法
+type memKV struct {
+	kvtypes.IKVStorage
+	values   map[string][]byte
+	metadata map[string][]byte
+}
+
+func newMemKV() *memKV {
+	return &memKV{values: map[string][]byte{}, metadata: map[string][]byte{}}
+}
+
+func (s *memKV) PutWithMetadata(bucket string, key string, value []byte, metadata []byte, ttl int, nx bool, xx bool) (bool, error) {
+	s.values[key] = value
+	if metadata == nil {
+		delete(s.metadata, key)
+	} else {
+		s.metadata[key] = metadata
+	}
+	return true, nil
+}
+
+func (s *memKV) Get(bucket string, key string) ([]byte, error) {
+	return s.values[key], nil
+}
+
+func (s *memKV) GetWithMetadata(bucket string, key string) ([]byte, []byte, error) {
+	return s.values[key], s.metadata[key], nil
+}
+
+func TestInvokeKVSetMetadata(t *testing.T) {
+	// 按 JSON 字符串构造指定长度的元数据
+	metadataOfSize := func(n int) json.RawMessage {
+		return json.RawMessage(`"` + strings.Repeat("a", n-2) + `"`)
+	}
+	tests := []struct {
+		name     string
+		metadata json.RawMessage
+		wantCode int
+		wantMeta []byte
+	}{
+		{name: "no metadata", wantCode: common.RespCodeOK},
+		{name: "null metadata", metadata: json.RawMessage("null"), wantCode: common.RespCodeOK},
+		{name: "small metadata", metadata: json.RawMessage(`{"a":1}`), wantCode: common.RespCodeOK, wantMeta: []byte(`{"a":1}`)},
+		{name: "at limit", metadata: metadataOfSize(kvtypes.MaxMetadataSize), wantCode: common.RespCodeOK, wantMeta: metadataOfSize(kvtypes.MaxMetadataSize)},
+		{name: "over limit", metadata: metadataOfSize(kvtypes.MaxMetadataSize + 1), wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newMemKV()
+			useTestKV(t, s)
+
+			code, data := invokeTestKV(t, &kvtypes.InvokeKVRequest{Method: "set", Key: "k", Value: "v", Metadata: tt.metadata})
+			if code != tt.wantCode {
+				t.Fatalf("code = %d, want %d, data = %s", code, tt.wantCode, data)
+			}
+			if tt.wantCode != common.RespCodeOK {
+				if _, ok := s.values["k"]; ok {
+					t.Error("value written with rejected metadata")
+				}
+				return
+			}
+			if !bytes.Equal(s.metadata["k"], tt.wantMeta) {
+				t.Errorf("metadata = %s, want %s", s.metadata["k"], tt.wantMeta)
+			}
+		})
+	}
+}
+
+func TestInvokeKVBinaryRoundTrip(t *testing.T) {
+	s := newMemKV()
+	useTestKV(t, s)
+	value := []byte{0xff, 0xfe, 0x00, 0xc3, 0x28}
+	encoded := base64.StdEncoding.EncodeToString(value)
+
+	code, data := invokeTestKV(t, &kvtypes.InvokeKVRequest{
+		Method: "set", Key: "bin", Value: encoded, Encoding: kvtypes.EncodingBase64, Metadata: json.RawMessage(`{"t":"bin"}`),
+	})
+	if code != common.RespCodeOK {
+		t.Fatalf("set code = %d, data = %s", code, data)
+	}
+	if !bytes.Equal(s.values["bin"], value) {
+		t.Fatalf("stored value = %v, want %v", s.values["bin"], value)
+	}
+
+	code, data = invokeTestKV(t, &kvtypes.InvokeKVRequest{Method: "get", Key: "bin", Encoding: kvtypes.EncodingBase64})
+	var got string
+	if code != common.RespCodeOK || json.Unmarshal(data, &got) != nil || got != encoded {
+		t.Errorf("get = %d %s, want %q", code, data, encoded)
+	}
+
+	code, data = invokeTestKV(t, &kvtypes.InvokeKVRequest{Method: "getWithMetadata", Key: "bin", Encoding: kvtypes.EncodingBase64})
+	var withMeta KVValueWithMetadata
+	if code != common.RespCodeOK || json.Unmarshal(data, &withMeta) != nil {
+		t.Fatalf("getWithMetadata = %d %s", code, data)
+	}
+	if withMeta.Value == nil || *withMeta.Value != encoded || string(withMeta.Metadata) != `{"t":"bin"}` {
+		t.Errorf("getWithMetadata = %s", data)
+	}
+
+	code, _ = invokeTestKV(t, &kvtypes.InvokeKVRequest{Method: "set", Key: "bad", Value: "not base64!", Encoding: kvtypes.EncodingBase64})
+	if code != http.StatusBadRequest {
+		t.Errorf("set with invalid base64 code = %d, want %d", code, http.StatusBadRequest)
+	}
+}
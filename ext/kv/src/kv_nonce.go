@@ -0,0 +1,67 @@
+package kv
+
+import (
+	"errors"
+	"net/http"
+	"vvorker/common"
+	"vvorker/conf"
+	"vvorker/rpc"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// 已使用的请求 nonce 保存的 bucket
+const nonceBucket = "sys_nonce"
+
+type UseNonceReq struct {
+	Key string `json:"key"`
+	TTL int    `json:"ttl"`
+}
+
+type UseNonceResp struct {
+	Fresh bool `json:"fresh"`
+}
+
+// UseNonce 记录一个 nonce，ttl 秒内再次使用同一个 nonce 时返回 false，用于防止请求重放
+// 与 TakeToken 相同，nutsdb 只在 master 上记录，其他节点转发给 master
+func UseNonce(key string, ttl int) (bool, error) {
+	if servesKVLocally() {
+		return kvStorage.SetIfAbsent(nonceBucket, key, []byte{1}, ttl)
+	}
+
+	url := conf.AppConfigInstance.MasterEndpoint + "/api/agent/nonce"
+	rtype := struct {
+		Code int          `json:"code"`
+		Msg  string       `json:"msg"`
+		Data UseNonceResp `json:"data"`
+	}{}
+
+	reqResp, err := rpc.RPCWrapper().
+		SetBody(&UseNonceReq{Key: key, TTL: ttl}).
+		SetSuccessResult(&rtype).
+		Post(url)
+
+	if err != nil || reqResp.StatusCode >= 299 || rtype.Code != common.RespCodeOK {
+		return false, errors.New("use nonce error")
+	}
+	return rtype.Data.Fresh, nil
+}
+
+func AgentUseNonceEndpoint(c *gin.Context) {
+	var req UseNonceReq
+	if err := c.ShouldBindWith(&req, binding.JSON); err != nil {
+		common.RespErr(c, http.StatusBadRequest, "invalid request", gin.H{"error": err.Error()})
+		return
+	}
+	if req.Key == "" || req.TTL <= 0 {
+		common.RespErr(c, http.StatusBadRequest, "invalid request", gin.H{"error": "invalid request"})
+		return
+	}
+	fresh, err := kvStorage.SetIfAbsent(nonceBucket, req.Key, []byte{1}, req.TTL)
+	if err != nil {
+		common.RespErr(c, http.StatusInternalServerError, "use nonce error", gin.H{"error": err.Error()})
+		return
+	}
+	common.RespOK(c, common.RespMsgOK, UseNonceResp{Fresh: fresh})
+}
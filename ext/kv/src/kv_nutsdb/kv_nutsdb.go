@@ -1,9 +1,17 @@
 package kvnutsdb
 
 import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
 	"vvorker/conf"
 	"vvorker/defs"
+	kvtypes "vvorker/ext/kv/src/kv_types"
 	"vvorker/ext/kv/src/sys_cache"
 
 	"github.com/nutsdb/nutsdb"
@@ -47,6 +55,33 @@ func ExistBucket(bucket string) error {
 	})
 }
 
+// existMetaBucket 值与元数据的 bucket 都需要存在
+func existMetaBucket(bucket string) {
+	ExistBucket(bucket)
+	ExistBucket(kvtypes.MetaBucket(bucket))
+}
+
+// delMeta 删除 key 的元数据，没有元数据时忽略
+func delMeta(tx *nutsdb.Tx, bucket string, key []byte) error {
+	err := tx.Delete(kvtypes.MetaBucket(bucket), key)
+	if errors.Is(err, nutsdb.ErrKeyNotFound) {
+		return nil
+	}
+	return err
+}
+
+// syncMetaTTL 值的过期时间变化后同步到元数据，避免元数据比值先过期或残留
+func syncMetaTTL(tx *nutsdb.Tx, bucket string, key []byte, ttl uint32) error {
+	m, err := tx.Get(kvtypes.MetaBucket(bucket), key)
+	if errors.Is(err, nutsdb.ErrKeyNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Put(kvtypes.MetaBucket(bucket), key, m, ttl)
+}
+
 func (r *KVNutsDB) Put(bucket string, key string, value []byte, ttl int) (int, error) {
 	ExistBucket(bucket)
 	code := 0
@@ -107,33 +142,333 @@ func (r *KVNutsDB) Get(bucket string, key string) ([]byte, error) {
 }
 
 func (r *KVNutsDB) Del(bucket string, key string) error {
-	ExistBucket(bucket)
+	existMetaBucket(bucket)
 	return db.Update(func(tx *nutsdb.Tx) error {
+		if err := delMeta(tx, bucket, []byte(key)); err != nil {
+			return err
+		}
 		return tx.Delete(bucket, []byte(key))
 	})
 }
 
-func (r *KVNutsDB) Keys(bucket string, prefix string, offset int, size int) ([]string, error) {
-	ExistBucket(bucket)
-	var result []string
-	err := db.View(
-		func(tx *nutsdb.Tx) error {
-			prefixBytes := []byte(prefix)
-			// Based on compiler feedback, tx.PrefixScan appears to return a slice of keys ([]byte),
-			// not a slice of Entry structs.
-			entries, err := tx.PrefixScan(bucket, prefixBytes, offset, size)
-			if err != nil {
+func (r *KVNutsDB) PutWithMetadata(bucket string, key string, value []byte, metadata []byte, ttl int, nx bool, xx bool) (bool, error) {
+	existMetaBucket(bucket)
+	written := false
+	err := db.Update(func(tx *nutsdb.Tx) error {
+		k := []byte(key)
+		if nx || xx {
+			_, err := tx.Get(bucket, k)
+			if err != nil && !errors.Is(err, nutsdb.ErrKeyNotFound) {
 				return err
 			}
-			result = make([]string, 0, len(entries))
-			for _, entry := range entries {
-				result = append(result, string(entry))
+			if exists := err == nil; (nx && exists) || (xx && !exists) {
+				return nil
 			}
+		}
+		if err := tx.Put(bucket, k, value, uint32(ttl)); err != nil {
+			return err
+		}
+		written = true
+		if metadata == nil {
+			return delMeta(tx, bucket, k)
+		}
+		return tx.Put(kvtypes.MetaBucket(bucket), k, metadata, uint32(ttl))
+	})
+	return written && err == nil, err
+}
+
+func (r *KVNutsDB) GetWithMetadata(bucket string, key string) ([]byte, []byte, error) {
+	existMetaBucket(bucket)
+	var value, metadata []byte
+	err := db.View(func(tx *nutsdb.Tx) error {
+		v, err := tx.Get(bucket, []byte(key))
+		if errors.Is(err, nutsdb.ErrKeyNotFound) {
 			return nil
-		})
+		}
+		if err != nil {
+			return err
+		}
+		value = v
+		m, err := tx.Get(kvtypes.MetaBucket(bucket), []byte(key))
+		if errors.Is(err, nutsdb.ErrKeyNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		metadata = m
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return value, metadata, nil
+}
+
+// List 从 cursor 之后 seek 到第一个匹配前缀的 key 顺序扫描，cursor 为上一页最后一个 key
+// 按 key 的顺序扫描，翻页期间写入的 key 只要排在 cursor 之后就会被列出
+func (r *KVNutsDB) List(bucket string, prefix string, cursor string, limit int, withValues bool) (*kvtypes.KVListResult, error) {
+	existMetaBucket(bucket)
+	start := []byte(prefix)
+	after := []byte(nil)
+	if cursor != "" {
+		c, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil || !bytes.HasPrefix(c, start) {
+			return nil, errors.New("invalid cursor")
+		}
+		start, after = c, c
+	}
 
+	result := &kvtypes.KVListResult{Keys: []kvtypes.KVListKey{}, ListComplete: true}
+	err := db.View(func(tx *nutsdb.Tx) error {
+		it := nutsdb.NewIterator(tx, bucket, nutsdb.IteratorOptions{})
+		if it == nil {
+			return nil
+		}
+		defer it.Release()
+		for ok := it.Seek(start); ok && bytes.HasPrefix(it.Key(), []byte(prefix)); ok = it.Next() {
+			record := it.Item().Record
+			if bytes.Equal(it.Key(), after) || record.IsExpired() {
+				continue
+			}
+			if len(result.Keys) == limit {
+				// 还有下一页
+				result.ListComplete = false
+				result.Cursor = base64.RawURLEncoding.EncodeToString([]byte(result.Keys[limit-1].Name))
+				return nil
+			}
+			key := kvtypes.KVListKey{Name: string(it.Key())}
+			if record.TTL != nutsdb.Persistent {
+				key.Expiration = int64(record.Timestamp/1000) + int64(record.TTL)
+			}
+			if withValues {
+				v, err := it.Value()
+				if err != nil {
+					return err
+				}
+				key.Value = v
+			}
+			m, err := tx.Get(kvtypes.MetaBucket(bucket), it.Key())
+			if err != nil && !errors.Is(err, nutsdb.ErrKeyNotFound) {
+				return err
+			}
+			key.Metadata = m
+			result.Keys = append(result.Keys, key)
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
 	return result, nil
 }
+
+// TakeToken 在一个写事务中读取并更新令牌桶，同一节点上的并发请求不会重复取令牌
+func (r *KVNutsDB) TakeToken(bucket string, key string, capacity float64, rate float64) (bool, int64, error) {
+	ExistBucket(bucket)
+	allowed, retryAfter := false, int64(0)
+	err := db.Update(func(tx *nutsdb.Tx) error {
+		state := kvtypes.TokenBucket{}
+		v, err := tx.Get(bucket, []byte(key))
+		if err == nil {
+			json.Unmarshal(v, &state)
+		} else if !errors.Is(err, nutsdb.ErrKeyNotFound) {
+			return err
+		}
+		allowed, retryAfter = state.Take(capacity, rate, time.Now().UnixMilli())
+		data, err := json.Marshal(state)
+		if err != nil {
+			return err
+		}
+		return tx.Put(bucket, []byte(key), data, uint32(kvtypes.TokenBucketTTL(capacity, rate)))
+	})
+	return allowed, retryAfter, err
+}
+
+func (r *KVNutsDB) SetIfAbsent(bucket string, key string, value []byte, ttl int) (bool, error) {
+	ExistBucket(bucket)
+	written := false
+	err := db.Update(func(tx *nutsdb.Tx) error {
+		_, err := tx.Get(bucket, []byte(key))
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, nutsdb.ErrKeyNotFound) {
+			return err
+		}
+		written = true
+		return tx.Put(bucket, []byte(key), value, uint32(ttl))
+	})
+	return written && err == nil, err
+}
+
+// remainingTTL 写入新值时保留原有的剩余过期时间，nutsdb 的 ttl 从写入时重新计算
+func remainingTTL(tx *nutsdb.Tx, bucket string, key []byte) (uint32, error) {
+	ttl, err := tx.GetTTL(bucket, key)
+	if err != nil {
+		return 0, err
+	}
+	if ttl < 0 {
+		return nutsdb.Persistent, nil
+	}
+	// 剩余不足 1 秒时按 1 秒计算，避免变为永不过期
+	return uint32(max(ttl, 1)), nil
+}
+
+func (r *KVNutsDB) Incr(bucket string, key string, delta int64, ttl int) (int64, error) {
+	existMetaBucket(bucket)
+	result := int64(0)
+	err := db.Update(func(tx *nutsdb.Tx) error {
+		k := []byte(key)
+		newTTL := uint32(ttl)
+		v, err := tx.Get(bucket, k)
+		switch {
+		case err == nil:
+			if result, err = strconv.ParseInt(string(v), 10, 64); err != nil {
+				return fmt.Errorf("value is not an integer: %w", err)
+			}
+			if newTTL, err = remainingTTL(tx, bucket, k); err != nil {
+				return err
+			}
+			if newTTL == nutsdb.Persistent && ttl > 0 {
+				newTTL = uint32(ttl)
+				if err := syncMetaTTL(tx, bucket, k, newTTL); err != nil {
+					return err
+				}
+			}
+		case !errors.Is(err, nutsdb.ErrKeyNotFound):
+			return err
+		}
+		if (delta > 0 && result > math.MaxInt64-delta) || (delta < 0 && result < math.MinInt64-delta) {
+			return errors.New("increment would overflow")
+		}
+		result += delta
+		return tx.Put(bucket, k, []byte(strconv.FormatInt(result, 10)), newTTL)
+	})
+	return result, err
+}
+
+func (r *KVNutsDB) MGet(bucket string, keys []string) ([][]byte, error) {
+	ExistBucket(bucket)
+	values := make([][]byte, len(keys))
+	err := db.View(func(tx *nutsdb.Tx) error {
+		for i, key := range keys {
+			v, err := tx.Get(bucket, []byte(key))
+			if errors.Is(err, nutsdb.ErrKeyNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			values[i] = v
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+func (r *KVNutsDB) MSet(bucket string, entries []kvtypes.KVEntry, ttl int) error {
+	existMetaBucket(bucket)
+	return db.Update(func(tx *nutsdb.Tx) error {
+		for _, e := range entries {
+			if err := tx.Put(bucket, []byte(e.Key), []byte(e.Value), uint32(ttl)); err != nil {
+				return err
+			}
+			if err := delMeta(tx, bucket, []byte(e.Key)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *KVNutsDB) MDel(bucket string, keys []string) (int, error) {
+	existMetaBucket(bucket)
+	count := 0
+	err := db.Update(func(tx *nutsdb.Tx) error {
+		count = 0
+		for _, key := range keys {
+			if err := delMeta(tx, bucket, []byte(key)); err != nil {
+				return err
+			}
+			err := tx.Delete(bucket, []byte(key))
+			if errors.Is(err, nutsdb.ErrKeyNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+func (r *KVNutsDB) CAS(bucket string, key string, match func(current []byte) bool, value []byte, ttl int) (bool, []byte, error) {
+	existMetaBucket(bucket)
+	swapped := false
+	var current []byte
+	err := db.Update(func(tx *nutsdb.Tx) error {
+		v, err := tx.Get(bucket, []byte(key))
+		if err != nil && !errors.Is(err, nutsdb.ErrKeyNotFound) {
+			return err
+		}
+		current = v
+		if !match(v) {
+			return nil
+		}
+		if err := tx.Put(bucket, []byte(key), value, uint32(ttl)); err != nil {
+			return err
+		}
+		if err := delMeta(tx, bucket, []byte(key)); err != nil {
+			return err
+		}
+		swapped, current = true, value
+		return nil
+	})
+	if err != nil {
+		return false, nil, err
+	}
+	return swapped, current, nil
+}
+
+func (r *KVNutsDB) TTL(bucket string, key string) (int64, error) {
+	ExistBucket(bucket)
+	ttl := int64(-2)
+	err := db.View(func(tx *nutsdb.Tx) error {
+		t, err := tx.GetTTL(bucket, []byte(key))
+		if errors.Is(err, nutsdb.ErrKeyNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		ttl = t
+		return nil
+	})
+	return ttl, err
+}
+
+func (r *KVNutsDB) Expire(bucket string, key string, ttl int) (bool, error) {
+	existMetaBucket(bucket)
+	found := false
+	err := db.Update(func(tx *nutsdb.Tx) error {
+		v, err := tx.Get(bucket, []byte(key))
+		if errors.Is(err, nutsdb.ErrKeyNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		found = true
+		if err := tx.Put(bucket, []byte(key), v, uint32(max(ttl, 0))); err != nil {
+			return err
+		}
+		return syncMetaTTL(tx, bucket, []byte(key), uint32(max(ttl, 0)))
+	})
+	return found, err
+}
@@ -0,0 +1,408 @@
+package kvnutsdb
+
+import (
+	"encoding/base64"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+	"vvorker/defs"
+	kvtypes "vvorker/ext/kv/src/kv_types"
+	"vvorker/ext/kv/src/sys_cache"
+
+	"github.com/nutsdb/nutsdb"
+)
+
+// TestMain 关闭 init 打开的库，改用临时目录
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "kv-nutsdb-test")
+	if err != nil {
+		panic(err)
+	}
+	db.Close()
+	if db, err = nutsdb.Open(nutsdb.DefaultOptions, nutsdb.WithDir(dir)); err != nil {
+		panic(err)
+	}
+	buckets = defs.NewSyncMap(map[string]bool{})
+	sys_cache.InitCache(db)
+
+	code := m.Run()
+	db.Close()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func metaTTL(t *testing.T, bucket, key string) int64 {
+	t.Helper()
+	ttl := int64(-2)
+	db.View(func(tx *nutsdb.Tx) error {
+		if v, err := tx.GetTTL(kvtypes.MetaBucket(bucket), []byte(key)); err == nil {
+			ttl = v
+		}
+		return nil
+	})
+	return ttl
+}
+
+func ptr(s string) *string {
+	return &s
+}
+
+// assertTTL want 为 -1、-2 时精确比较，否则允许 1 秒的误差
+func assertTTL(t *testing.T, got, want int64) {
+	t.Helper()
+	if want < 0 && got != want || want >= 0 && (got > want || got < want-1) {
+		t.Errorf("ttl = %d, want %d", got, want)
+	}
+}
+
+func TestIncr(t *testing.T) {
+	r := &KVNutsDB{}
+	bucket := "test_incr"
+	tests := []struct {
+		name     string
+		init     *string // 初始值，nil 表示 key 不存在
+		initTTL  int
+		initMeta bool
+		delta    int64
+		ttl      int
+		want     int64
+		wantErr  bool
+		wantTTL  int64
+	}{
+		{name: "new key", delta: 1, want: 1, wantTTL: -1},
+		{name: "new key with ttl", delta: 3, ttl: 30, want: 3, wantTTL: 30},
+		{name: "add", init: ptr("5"), delta: 2, want: 7, wantTTL: -1},
+		{name: "negative", init: ptr("5"), delta: -10, want: -5, wantTTL: -1},
+		{name: "not integer", init: ptr("abc"), delta: 1, wantErr: true},
+		{name: "overflow", init: ptr(strconv.FormatInt(math.MaxInt64, 10)), delta: 1, wantErr: true},
+		{name: "underflow", init: ptr(strconv.FormatInt(math.MinInt64, 10)), delta: -1, wantErr: true},
+		{name: "keep remaining ttl", init: ptr("1"), initTTL: 100, delta: 1, want: 2, wantTTL: 100},
+		{name: "ttl does not extend", init: ptr("1"), initTTL: 100, delta: 1, ttl: 500, want: 2, wantTTL: 100},
+		{name: "ttl for persistent key", init: ptr("1"), initMeta: true, delta: 1, ttl: 50, want: 2, wantTTL: 50},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := tt.name
+			if tt.init != nil {
+				var meta []byte
+				if tt.initMeta {
+					meta = []byte(`{"a":1}`)
+				}
+				if _, err := r.PutWithMetadata(bucket, key, []byte(*tt.init), meta, tt.initTTL, false, false); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := r.Incr(bucket, key, tt.delta, tt.ttl)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Incr() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				// 失败时保持原值
+				if v, _ := r.Get(bucket, key); string(v) != *tt.init {
+					t.Errorf("value = %q after failed incr, want %q", v, *tt.init)
+				}
+				return
+			}
+			if got != tt.want {
+				t.Errorf("Incr() = %d, want %d", got, tt.want)
+			}
+			ttl, err := r.TTL(bucket, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertTTL(t, ttl, tt.wantTTL)
+			if tt.initMeta {
+				assertTTL(t, metaTTL(t, bucket, key), tt.wantTTL)
+			}
+		})
+	}
+}
+
+func TestCAS(t *testing.T) {
+	r := &KVNutsDB{}
+	bucket := "test_cas"
+	tests := []struct {
+		name        string
+		init        *string
+		req         kvtypes.InvokeKVRequest
+		wantSwapped bool
+		wantCurrent *string
+	}{
+		{name: "absent key", req: kvtypes.InvokeKVRequest{}, wantSwapped: true, wantCurrent: ptr("new")},
+		{name: "absent key with expected", req: kvtypes.InvokeKVRequest{Expected: ptr("old")}, wantCurrent: nil},
+		{name: "existing key without expected", init: ptr("old"), req: kvtypes.InvokeKVRequest{}, wantCurrent: ptr("old")},
+		{name: "value match", init: ptr("old"), req: kvtypes.InvokeKVRequest{Expected: ptr("old")}, wantSwapped: true, wantCurrent: ptr("new")},
+		{name: "value mismatch", init: ptr("old"), req: kvtypes.InvokeKVRequest{Expected: ptr("other")}, wantCurrent: ptr("old")},
+		{name: "base64 value match", init: ptr("\xff\x00"), req: kvtypes.InvokeKVRequest{Expected: ptr("/wA="), Encoding: kvtypes.EncodingBase64}, wantSwapped: true, wantCurrent: ptr("new")},
+		{name: "version match", init: ptr("old"), req: kvtypes.InvokeKVRequest{Version: kvtypes.KVVersion([]byte("old"))}, wantSwapped: true, wantCurrent: ptr("new")},
+		{name: "version overrides expected", init: ptr("old"), req: kvtypes.InvokeKVRequest{Version: kvtypes.KVVersion([]byte("old")), Expected: ptr("other")}, wantSwapped: true, wantCurrent: ptr("new")},
+		{name: "stale version", init: ptr("old"), req: kvtypes.InvokeKVRequest{Version: kvtypes.KVVersion([]byte("older"))}, wantCurrent: ptr("old")},
+		{name: "version on absent key", req: kvtypes.InvokeKVRequest{Version: kvtypes.KVVersion([]byte("old"))}, wantCurrent: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := tt.name
+			if tt.init != nil {
+				if _, err := r.PutWithMetadata(bucket, key, []byte(*tt.init), []byte(`{"a":1}`), 0, false, false); err != nil {
+					t.Fatal(err)
+				}
+			}
+			match, err := tt.req.CASMatcher()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			swapped, current, err := r.CAS(bucket, key, match, []byte("new"), 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if swapped != tt.wantSwapped {
+				t.Errorf("swapped = %v, want %v", swapped, tt.wantSwapped)
+			}
+			if (current == nil) != (tt.wantCurrent == nil) || tt.wantCurrent != nil && string(current) != *tt.wantCurrent {
+				t.Errorf("current = %q, want %v", current, tt.wantCurrent)
+			}
+
+			value, meta, err := r.GetWithMetadata(bucket, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(value) != string(current) {
+				t.Errorf("stored value = %q, want %q", value, current)
+			}
+			// 写入时删除已有的元数据，未写入时保留
+			if wantMeta := tt.init != nil && !swapped; (meta != nil) != wantMeta {
+				t.Errorf("metadata = %s, want kept %v", meta, wantMeta)
+			}
+		})
+	}
+}
+
+func TestMDel(t *testing.T) {
+	r := &KVNutsDB{}
+	bucket := "test_mdel"
+	tests := []struct {
+		name string
+		init []string
+		keys []string
+		want int
+		kept []string
+	}{
+		{name: "empty", init: []string{"a"}, keys: []string{}, want: 0, kept: []string{"a"}},
+		{name: "all exist", init: []string{"a", "b"}, keys: []string{"a", "b"}, want: 2},
+		{name: "some missing", init: []string{"a", "b", "c"}, keys: []string{"a", "missing", "c"}, want: 2, kept: []string{"b"}},
+		{name: "duplicate keys", init: []string{"a"}, keys: []string{"a", "a"}, want: 1},
+		{name: "none exist", keys: []string{"x", "y"}, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := bucket + "_" + tt.name
+			for _, k := range tt.init {
+				if _, err := r.PutWithMetadata(b, k, []byte("v"), []byte(`{}`), 0, false, false); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := r.MDel(b, tt.keys)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("MDel() = %d, want %d", got, tt.want)
+			}
+			kept := map[string]bool{}
+			for _, k := range tt.kept {
+				kept[k] = true
+			}
+			for _, k := range tt.init {
+				v, meta, err := r.GetWithMetadata(b, k)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if (v != nil) != kept[k] || (meta != nil) != kept[k] {
+					t.Errorf("key %s: value %q, metadata %s, want kept %v", k, v, meta, kept[k])
+				}
+			}
+		})
+	}
+}
+
+// TestExpire 与 kv_redis 的 TestExpire 使用相同的用例，两种存储的行为需要一致
+func TestExpire(t *testing.T) {
+	r := &KVNutsDB{}
+	bucket := "test_expire"
+	tests := []struct {
+		name      string
+		exists    bool
+		initTTL   int
+		ttl       int
+		wantFound bool
+		wantTTL   int64
+	}{
+		{name: "missing key", ttl: 10, wantFound: false, wantTTL: -2},
+		{name: "missing key zero", ttl: 0, wantFound: false, wantTTL: -2},
+		{name: "set ttl", exists: true, ttl: 100, wantFound: true, wantTTL: 100},
+		{name: "shorten ttl", exists: true, initTTL: 100, ttl: 10, wantFound: true, wantTTL: 10},
+		{name: "zero removes ttl", exists: true, initTTL: 100, ttl: 0, wantFound: true, wantTTL: -1},
+		{name: "negative removes ttl", exists: true, initTTL: 100, ttl: -5, wantFound: true, wantTTL: -1},
+		{name: "zero on persistent key", exists: true, ttl: 0, wantFound: true, wantTTL: -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := tt.name
+			if tt.exists {
+				if _, err := r.PutWithMetadata(bucket, key, []byte("v"), []byte(`{}`), tt.initTTL, false, false); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			found, err := r.Expire(bucket, key, tt.ttl)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if found != tt.wantFound {
+				t.Errorf("Expire() = %v, want %v", found, tt.wantFound)
+			}
+			ttl, err := r.TTL(bucket, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertTTL(t, ttl, tt.wantTTL)
+			if tt.exists {
+				assertTTL(t, metaTTL(t, bucket, key), tt.wantTTL)
+				if v, _ := r.Get(bucket, key); string(v) != "v" {
+					t.Errorf("value = %q after expire", v)
+				}
+			}
+		})
+	}
+}
+
+func TestList(t *testing.T) {
+	r := &KVNutsDB{}
+	bucket := "test_list"
+	for _, k := range []string{"a1", "a2", "a3", "a4", "a5", "b1"} {
+		var meta []byte
+		if k == "a2" {
+			meta = []byte(`{"m":2}`)
+		}
+		if _, err := r.PutWithMetadata(bucket, k, []byte("v-"+k), meta, 0, false, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		prefix    string
+		limit     int
+		wantPages [][]string
+	}{
+		{name: "pages across limit", prefix: "a", limit: 2, wantPages: [][]string{{"a1", "a2"}, {"a3", "a4"}, {"a5"}}},
+		{name: "limit equals count", prefix: "a", limit: 5, wantPages: [][]string{{"a1", "a2", "a3", "a4", "a5"}}},
+		{name: "all keys", limit: 4, wantPages: [][]string{{"a1", "a2", "a3", "a4"}, {"a5", "b1"}}},
+		{name: "no match", prefix: "c", limit: 2, wantPages: [][]string{{}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cursor := ""
+			for i, want := range tt.wantPages {
+				result, err := r.List(bucket, tt.prefix, cursor, tt.limit, true)
+				if err != nil {
+					t.Fatal(err)
+				}
+				names := []string{}
+				for _, k := range result.Keys {
+					names = append(names, k.Name)
+					if string(k.Value) != "v-"+k.Name {
+						t.Errorf("value of %s = %q", k.Name, k.Value)
+					}
+					// 元数据跟随 key 返回，元数据 bucket 中的 key 不会单独列出
+					if wantMeta := k.Name == "a2"; (k.Metadata != nil) != wantMeta {
+						t.Errorf("metadata of %s = %s", k.Name, k.Metadata)
+					}
+				}
+				if !slices.Equal(names, want) {
+					t.Fatalf("page %d = %v, want %v", i, names, want)
+				}
+				last := i == len(tt.wantPages)-1
+				if result.ListComplete != last {
+					t.Fatalf("page %d list complete = %v, want %v", i, result.ListComplete, last)
+				}
+				if last {
+					if result.Cursor != "" {
+						t.Errorf("cursor on last page = %q", result.Cursor)
+					}
+					return
+				}
+				// cursor 为本页最后一个 key 的 base64
+				if wantCursor := base64.RawURLEncoding.EncodeToString([]byte(want[len(want)-1])); result.Cursor != wantCursor {
+					t.Fatalf("page %d cursor = %q, want %q", i, result.Cursor, wantCursor)
+				}
+				cursor = result.Cursor
+			}
+		})
+	}
+}
+
+func TestListWithoutValues(t *testing.T) {
+	r := &KVNutsDB{}
+	bucket := "test_list_no_values"
+	if _, err := r.Put(bucket, "k", []byte("v"), 100); err != nil {
+		t.Fatal(err)
+	}
+	result, err := r.List(bucket, "", "", 10, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Keys) != 1 || result.Keys[0].Value != nil {
+		t.Fatalf("keys = %+v", result.Keys)
+	}
+	if exp := result.Keys[0].Expiration - time.Now().Unix(); exp < 98 || exp > 101 {
+		t.Errorf("expiration in %d seconds, want 100", exp)
+	}
+}
+
+func TestListCursor(t *testing.T) {
+	r := &KVNutsDB{}
+	bucket := "test_list_cursor"
+	for _, k := range []string{"a1", "a2", "a3"} {
+		r.Put(bucket, k, []byte("v"), 0)
+	}
+
+	tests := []struct {
+		name    string
+		prefix  string
+		cursor  string
+		want    []string
+		wantErr bool
+	}{
+		{name: "after cursor", prefix: "a", cursor: base64.RawURLEncoding.EncodeToString([]byte("a1")), want: []string{"a2", "a3"}},
+		{name: "cursor of deleted key", prefix: "a", cursor: base64.RawURLEncoding.EncodeToString([]byte("a15")), want: []string{"a2", "a3"}},
+		{name: "not base64", prefix: "a", cursor: "!!!", wantErr: true},
+		{name: "std base64 padding", prefix: "a", cursor: base64.StdEncoding.EncodeToString([]byte("a1")), wantErr: true},
+		{name: "other prefix", prefix: "a", cursor: base64.RawURLEncoding.EncodeToString([]byte("b1")), wantErr: true},
+		{name: "redis cursor", prefix: "a", cursor: "0-1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := r.List(bucket, tt.prefix, tt.cursor, 10, false)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("List() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			names := []string{}
+			for _, k := range result.Keys {
+				names = append(names, k.Name)
+			}
+			if !slices.Equal(names, tt.want) {
+				t.Errorf("keys = %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package kv
+
+import (
+	"errors"
+	"net/http"
+	"vvorker/common"
+	"vvorker/conf"
+	"vvorker/rpc"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// 令牌桶状态保存的 bucket
+const rateLimitBucket = "sys_ratelimit"
+
+type TakeTokenReq struct {
+	Key      string  `json:"key"`
+	Capacity float64 `json:"capacity"`
+	Rate     float64 `json:"rate"`
+}
+
+type TakeTokenResp struct {
+	Allowed    bool  `json:"allowed"`
+	RetryAfter int64 `json:"retry_after"` // 毫秒
+}
+
+// TakeToken 从共享的令牌桶中取出一个令牌，所有节点共用同一份计数
+// redis 各节点可以直接访问，nutsdb 只在 master 上计数，其他节点转发给 master
+func TakeToken(key string, capacity float64, rate float64) (bool, int64, error) {
+	if servesKVLocally() {
+		return kvStorage.TakeToken(rateLimitBucket, key, capacity, rate)
+	}
+
+	url := conf.AppConfigInstance.MasterEndpoint + "/api/agent/ratelimit"
+	rtype := struct {
+		Code int           `json:"code"`
+		Msg  string        `json:"msg"`
+		Data TakeTokenResp `json:"data"`
+	}{}
+
+	reqResp, err := rpc.RPCWrapper().
+		SetBody(&TakeTokenReq{Key: key, Capacity: capacity, Rate: rate}).
+		SetSuccessResult(&rtype).
+		Post(url)
+
+	if err != nil || reqResp.StatusCode >= 299 || rtype.Code != common.RespCodeOK {
+		return false, 0, errors.New("take token error")
+	}
+	return rtype.Data.Allowed, rtype.Data.RetryAfter, nil
+}
+
+func AgentTakeTokenEndpoint(c *gin.Context) {
+	var req TakeTokenReq
+	if err := c.ShouldBindWith(&req, binding.JSON); err != nil {
+		common.RespErr(c, http.StatusBadRequest, "invalid request", gin.H{"error": err.Error()})
+		return
+	}
+	if req.Key == "" || req.Capacity <= 0 || req.Rate <= 0 {
+		common.RespErr(c, http.StatusBadRequest, "invalid request", gin.H{"error": "invalid request"})
+		return
+	}
+	allowed, retryAfter, err := kvStorage.TakeToken(rateLimitBucket, req.Key, req.Capacity, req.Rate)
+	if err != nil {
+		common.RespErr(c, http.StatusInternalServerError, "take token error", gin.H{"error": err.Error()})
+		return
+	}
+	common.RespOK(c, common.RespMsgOK, TakeTokenResp{
+		Allowed:    allowed,
+		RetryAfter: retryAfter,
+	})
+}
@@ -2,9 +2,12 @@ package kvredis
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 	"vvorker/conf"
+	kvtypes "vvorker/ext/kv/src/kv_types"
 
 	"github.com/redis/go-redis/v9"
 	"github.com/sirupsen/logrus"
@@ -13,8 +16,84 @@ import (
 type KVRedis struct {
 }
 
+// 令牌桶脚本，使用 redis 的时间，各节点的时钟不一致也不影响计数
+var takeTokenScript = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'time')
+local tokens = tonumber(state[1])
+local last = tonumber(state[2])
+if tokens == nil or last == nil then
+	tokens = capacity
+elseif now > last then
+	tokens = math.min(capacity, tokens + (now - last) / 1000 * rate)
+end
+local allowed = 0
+local retry = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	retry = math.ceil((1 - tokens) / rate * 1000)
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'time', now)
+redis.call('EXPIRE', KEYS[1], tonumber(ARGV[3]))
+return {allowed, retry}
+`)
+
+// 计数脚本，key 没有过期时间时才设置 ttl，固定窗口的计数不会被后续请求延长
+var incrScript = redis.NewScript(`
+local v = redis.call('INCRBY', KEYS[1], ARGV[1])
+if tonumber(ARGV[2]) > 0 and redis.call('TTL', KEYS[1]) == -1 then
+	redis.call('EXPIRE', KEYS[1], tonumber(ARGV[2]))
+	redis.call('EXPIRE', KEYS[2], tonumber(ARGV[2]))
+end
+return v
+`)
+
+// 写入值与元数据的脚本，KEYS[2] 为元数据的 key，ARGV[5] 为 1 时写入元数据，否则删除已有的元数据
+var putWithMetadataScript = redis.NewScript(`
+local exists = redis.call('EXISTS', KEYS[1])
+if (ARGV[3] == '1' and exists == 1) or (ARGV[4] == '1' and exists == 0) then
+	return 0
+end
+local ttl = tonumber(ARGV[2])
+if ttl > 0 then
+	redis.call('SET', KEYS[1], ARGV[1], 'EX', ttl)
+else
+	redis.call('SET', KEYS[1], ARGV[1])
+end
+if ARGV[5] == '1' then
+	if ttl > 0 then
+		redis.call('SET', KEYS[2], ARGV[6], 'EX', ttl)
+	else
+		redis.call('SET', KEYS[2], ARGV[6])
+	end
+else
+	redis.call('DEL', KEYS[2])
+end
+return 1
+`)
+
+// CAS 冲突时的最大重试次数，重试时重新比较当前值
+const casMaxRetries = 8
+
 var rdb *redis.Client
 
+// metaKey 元数据的 key，与值的 key 前缀不同，按 bucket 列出 key 时不会包含元数据
+func metaKey(bucket string, key string) string {
+	return kvtypes.MetaBucket(bucket) + ":" + key
+}
+
+func boolArg(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
 func init() {
 	if conf.AppConfigInstance.KVProvider == "redis" {
 		rdb = redis.NewClient(&redis.Options{
@@ -78,14 +157,286 @@ func (r *KVRedis) Get(bucket string, key string) ([]byte, error) {
 }
 
 func (r *KVRedis) Del(bucket string, key string) error {
-	return rdb.Del(context.Background(), bucket+":"+key).Err()
+	return rdb.Del(context.Background(), bucket+":"+key, metaKey(bucket, key)).Err()
+}
+
+func (r *KVRedis) PutWithMetadata(bucket string, key string, value []byte, metadata []byte, ttl int, nx bool, xx bool) (bool, error) {
+	n, err := putWithMetadataScript.Run(context.Background(), rdb, []string{bucket + ":" + key, metaKey(bucket, key)},
+		value, max(ttl, 0), boolArg(nx), boolArg(xx), boolArg(metadata != nil), metadata).Int()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
 }
 
-func (r *KVRedis) Keys(bucket string, prefix string, offset int, size int) ([]string, error) {
-	result, err := rdb.Keys(context.Background(), bucket+":"+prefix).Result()
+func (r *KVRedis) GetWithMetadata(bucket string, key string) ([]byte, []byte, error) {
+	result, err := rdb.MGet(context.Background(), bucket+":"+key, metaKey(bucket, key)).Result()
 	if err != nil {
+		return nil, nil, err
+	}
+	value, ok := result[0].(string)
+	if !ok {
+		return nil, nil, nil
+	}
+	if metadata, ok := result[1].(string); ok {
+		return []byte(value), []byte(metadata), nil
+	}
+	return []byte(value), nil, nil
+}
+
+// escapeGlob 转义 MATCH 模式中的特殊字符，前缀按原样匹配
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, ch := range s {
+		switch ch {
+		case '\\', '*', '?', '[', ']':
+			b.WriteRune('\\')
+		}
+		b.WriteRune(ch)
+	}
+	return b.String()
+}
+
+// 单次 list 最多执行的 SCAN 次数，匹配的 key 稀疏时提前返回，由调用方继续翻页
+const maxListScans = 16
+
+// List 使用 SCAN 遍历，cursor 为 SCAN 的游标以及该批次中已经返回的数量
+// 与 SCAN 一致，翻页期间一直存在的 key 至少会返回一次，但可能重复
+func (r *KVRedis) List(bucket string, prefix string, cursor string, limit int, withValues bool) (*kvtypes.KVListResult, error) {
+	ctx := context.Background()
+	scan, skip := uint64(0), 0
+	if cursor != "" {
+		if _, err := fmt.Sscanf(cursor, "%d-%d", &scan, &skip); err != nil || skip < 0 {
+			return nil, errors.New("invalid cursor")
+		}
+	}
+
+	result := &kvtypes.KVListResult{Keys: []kvtypes.KVListKey{}, ListComplete: true}
+	match := escapeGlob(bucket+":"+prefix) + "*"
+	names := []string{}
+	for i := 0; ; i++ {
+		batch, next, err := rdb.Scan(ctx, scan, match, int64(limit)).Result()
+		if err != nil {
+			return nil, err
+		}
+		batch = batch[min(skip, len(batch)):]
+		if room := limit - len(names); len(batch) > room {
+			// 本批次没有返回完，下一页从同一个游标开始并跳过已经返回的部分
+			names = append(names, batch[:room]...)
+			result.ListComplete = false
+			result.Cursor = fmt.Sprintf("%d-%d", scan, skip+room)
+			break
+		}
+		names = append(names, batch...)
+		scan, skip = next, 0
+		if scan == 0 {
+			break
+		}
+		if len(names) == limit || i+1 >= maxListScans {
+			result.ListComplete = false
+			result.Cursor = fmt.Sprintf("%d-0", scan)
+			break
+		}
+	}
+	if len(names) == 0 {
+		return result, nil
+	}
+
+	ttls := make([]*redis.DurationCmd, len(names))
+	metas := make([]*redis.StringCmd, len(names))
+	values := make([]*redis.StringCmd, len(names))
+	_, err := rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, name := range names {
+			key := strings.TrimPrefix(name, bucket+":")
+			ttls[i] = pipe.TTL(ctx, name)
+			metas[i] = pipe.Get(ctx, metaKey(bucket, key))
+			if withValues {
+				values[i] = pipe.Get(ctx, name)
+			}
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
 		return nil, err
 	}
 
+	now := time.Now().Unix()
+	for i, name := range names {
+		ttl, err := ttls[i].Result()
+		if err != nil {
+			return nil, err
+		}
+		if ttl == -2 {
+			// 扫描之后已经过期或被删除
+			continue
+		}
+		key := kvtypes.KVListKey{Name: strings.TrimPrefix(name, bucket+":")}
+		if ttl > 0 {
+			key.Expiration = now + int64(ttl/time.Second)
+		}
+		if m, err := metas[i].Bytes(); err == nil {
+			key.Metadata = m
+		}
+		if withValues {
+			v, err := values[i].Bytes()
+			if err != nil {
+				continue
+			}
+			key.Value = v
+		}
+		result.Keys = append(result.Keys, key)
+	}
 	return result, nil
 }
+
+func (r *KVRedis) TakeToken(bucket string, key string, capacity float64, rate float64) (bool, int64, error) {
+	result, err := takeTokenScript.Run(context.Background(), rdb, []string{bucket + ":" + key},
+		capacity, rate, kvtypes.TokenBucketTTL(capacity, rate)).Int64Slice()
+	if err != nil {
+		return false, 0, err
+	}
+	if len(result) != 2 {
+		return false, 0, fmt.Errorf("unexpected token bucket result: %v", result)
+	}
+	return result[0] == 1, result[1], nil
+}
+
+func (r *KVRedis) SetIfAbsent(bucket string, key string, value []byte, ttl int) (bool, error) {
+	return rdb.SetNX(context.Background(), bucket+":"+key, value, time.Second*time.Duration(ttl)).Result()
+}
+
+func (r *KVRedis) Incr(bucket string, key string, delta int64, ttl int) (int64, error) {
+	return incrScript.Run(context.Background(), rdb, []string{bucket + ":" + key, metaKey(bucket, key)}, delta, ttl).Int64()
+}
+
+func (r *KVRedis) MGet(bucket string, keys []string) ([][]byte, error) {
+	if len(keys) == 0 {
+		return [][]byte{}, nil
+	}
+	fullKeys := make([]string, len(keys))
+	for i, key := range keys {
+		fullKeys[i] = bucket + ":" + key
+	}
+	result, err := rdb.MGet(context.Background(), fullKeys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	values := make([][]byte, len(result))
+	for i, v := range result {
+		if s, ok := v.(string); ok {
+			values[i] = []byte(s)
+		}
+	}
+	return values, nil
+}
+
+func (r *KVRedis) MSet(bucket string, entries []kvtypes.KVEntry, ttl int) error {
+	_, err := rdb.TxPipelined(context.Background(), func(pipe redis.Pipeliner) error {
+		for _, e := range entries {
+			pipe.Set(context.Background(), bucket+":"+e.Key, e.Value, time.Second*time.Duration(ttl))
+			pipe.Del(context.Background(), metaKey(bucket, e.Key))
+		}
+		return nil
+	})
+	return err
+}
+
+func (r *KVRedis) MDel(bucket string, keys []string) (int, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	fullKeys := make([]string, len(keys))
+	metaKeys := make([]string, len(keys))
+	for i, key := range keys {
+		fullKeys[i] = bucket + ":" + key
+		metaKeys[i] = metaKey(bucket, key)
+	}
+	var n *redis.IntCmd
+	_, err := rdb.TxPipelined(context.Background(), func(pipe redis.Pipeliner) error {
+		n = pipe.Del(context.Background(), fullKeys...)
+		pipe.Del(context.Background(), metaKeys...)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int(n.Val()), nil
+}
+
+// CAS 使用 WATCH 保证比较与写入之间没有其他写入，冲突时重试
+func (r *KVRedis) CAS(bucket string, key string, match func(current []byte) bool, value []byte, ttl int) (bool, []byte, error) {
+	ctx := context.Background()
+	fullKey := bucket + ":" + key
+	for i := 0; i < casMaxRetries; i++ {
+		swapped := false
+		var current []byte
+		err := rdb.Watch(ctx, func(tx *redis.Tx) error {
+			v, err := tx.Get(ctx, fullKey).Bytes()
+			if err != nil && err != redis.Nil {
+				return err
+			}
+			if err == nil {
+				current = v
+			}
+			if !match(current) {
+				return nil
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.Set(ctx, fullKey, value, time.Second*time.Duration(ttl))
+				pipe.Del(ctx, metaKey(bucket, key))
+				return nil
+			})
+			if err == nil {
+				swapped, current = true, value
+			}
+			return err
+		}, fullKey)
+		if err == redis.TxFailedErr {
+			continue
+		}
+		if err != nil {
+			return false, nil, err
+		}
+		return swapped, current, nil
+	}
+	return false, nil, errors.New("cas conflict, too many retries")
+}
+
+func (r *KVRedis) TTL(bucket string, key string) (int64, error) {
+	d, err := rdb.TTL(context.Background(), bucket+":"+key).Result()
+	if err != nil {
+		return 0, err
+	}
+	// -1 与 -2 按原样返回
+	if d < 0 {
+		return int64(d), nil
+	}
+	return int64(d / time.Second), nil
+}
+
+// Expire 同时修改元数据的过期时间
+func (r *KVRedis) Expire(bucket string, key string, ttl int) (bool, error) {
+	ctx := context.Background()
+	if ttl <= 0 {
+		exists, err := rdb.Exists(ctx, bucket+":"+key).Result()
+		if err != nil || exists == 0 {
+			return false, err
+		}
+		_, err = rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Persist(ctx, bucket+":"+key)
+			pipe.Persist(ctx, metaKey(bucket, key))
+			return nil
+		})
+		return err == nil, err
+	}
+	var ok *redis.BoolCmd
+	_, err := rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		ok = pipe.Expire(ctx, bucket+":"+key, time.Second*time.Duration(ttl))
+		pipe.Expire(ctx, metaKey(bucket, key), time.Second*time.Duration(ttl))
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return ok.Val(), nil
+}
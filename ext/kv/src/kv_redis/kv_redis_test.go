@@ -0,0 +1,196 @@
+package kvredis
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 令牌桶脚本需要真实的 redis，按节点配置连接，连不上时跳过
+func requireRedis(t *testing.T) {
+	t.Helper()
+	if rdb == nil {
+		t.Skip("redis kv provider not configured")
+	}
+	if err := rdb.Ping(context.Background()).Err(); err != nil {
+		t.Skipf("redis not available: %v", err)
+	}
+}
+
+func TestTakeToken(t *testing.T) {
+	requireRedis(t)
+	r := &KVRedis{}
+	bucket := "test_token_bucket"
+
+	tests := []struct {
+		name     string
+		capacity float64
+		rate     float64
+		burst    int
+		wait     time.Duration
+	}{
+		{name: "burst", capacity: 3, rate: 1, burst: 3},
+		{name: "refill", capacity: 1, rate: 20, burst: 1, wait: 100 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := fmt.Sprintf("%s-%d", tt.name, time.Now().UnixNano())
+			defer rdb.Del(context.Background(), bucket+":"+key)
+
+			for i := 0; i < tt.burst; i++ {
+				ok, _, err := r.TakeToken(bucket, key, tt.capacity, tt.rate)
+				if err != nil || !ok {
+					t.Fatalf("take %d: ok=%v err=%v", i, ok, err)
+				}
+			}
+			ok, retry, err := r.TakeToken(bucket, key, tt.capacity, tt.rate)
+			if err != nil || ok {
+				t.Fatalf("take over capacity: ok=%v err=%v", ok, err)
+			}
+			if retry <= 0 || retry > int64(1000/tt.rate) {
+				t.Fatalf("retry after %dms out of range", retry)
+			}
+			if tt.wait == 0 {
+				return
+			}
+			time.Sleep(tt.wait)
+			if ok, _, err := r.TakeToken(bucket, key, tt.capacity, tt.rate); err != nil || !ok {
+				t.Fatalf("take after refill: ok=%v err=%v", ok, err)
+			}
+		})
+	}
+}
+
+// TestExpire 与 kv_nutsdb 的 TestExpire 使用相同的用例，两种存储的行为需要一致
+func TestExpire(t *testing.T) {
+	requireRedis(t)
+	r := &KVRedis{}
+	bucket := fmt.Sprintf("test_expire_%d", time.Now().UnixNano())
+	tests := []struct {
+		name      string
+		exists    bool
+		initTTL   int
+		ttl       int
+		wantFound bool
+		wantTTL   int64
+	}{
+		{name: "missing key", ttl: 10, wantFound: false, wantTTL: -2},
+		{name: "missing key zero", ttl: 0, wantFound: false, wantTTL: -2},
+		{name: "set ttl", exists: true, ttl: 100, wantFound: true, wantTTL: 100},
+		{name: "shorten ttl", exists: true, initTTL: 100, ttl: 10, wantFound: true, wantTTL: 10},
+		{name: "zero removes ttl", exists: true, initTTL: 100, ttl: 0, wantFound: true, wantTTL: -1},
+		{name: "negative removes ttl", exists: true, initTTL: 100, ttl: -5, wantFound: true, wantTTL: -1},
+		{name: "zero on persistent key", exists: true, ttl: 0, wantFound: true, wantTTL: -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := tt.name
+			defer rdb.Del(context.Background(), bucket+":"+key, metaKey(bucket, key))
+			if tt.exists {
+				if _, err := r.PutWithMetadata(bucket, key, []byte("v"), []byte(`{}`), tt.initTTL, false, false); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			found, err := r.Expire(bucket, key, tt.ttl)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if found != tt.wantFound {
+				t.Errorf("Expire() = %v, want %v", found, tt.wantFound)
+			}
+			ttl, err := r.TTL(bucket, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertTTL(t, ttl, tt.wantTTL)
+			if tt.exists {
+				d, err := rdb.TTL(context.Background(), metaKey(bucket, key)).Result()
+				if err != nil {
+					t.Fatal(err)
+				}
+				metaTTL := int64(d)
+				if d > 0 {
+					metaTTL = int64(d / time.Second)
+				}
+				assertTTL(t, metaTTL, tt.wantTTL)
+			}
+		})
+	}
+}
+
+// assertTTL want 为 -1、-2 时精确比较，否则允许 1 秒的误差
+func assertTTL(t *testing.T, got, want int64) {
+	t.Helper()
+	if want < 0 && got != want || want >= 0 && (got > want || got < want-1) {
+		t.Errorf("ttl = %d, want %d", got, want)
+	}
+}
+
+func TestList(t *testing.T) {
+	requireRedis(t)
+	r := &KVRedis{}
+	bucket := fmt.Sprintf("test_list_%d", time.Now().UnixNano())
+	want := []string{}
+	for i := 0; i < 7; i++ {
+		key := fmt.Sprintf("a%d", i)
+		want = append(want, key)
+		if _, err := r.PutWithMetadata(bucket, key, []byte("v-"+key), []byte(`{}`), 0, false, false); err != nil {
+			t.Fatal(err)
+		}
+		defer rdb.Del(context.Background(), bucket+":"+key, metaKey(bucket, key))
+	}
+	r.Put(bucket, "b0", []byte("v"), 0)
+	defer rdb.Del(context.Background(), bucket+":b0")
+
+	// SCAN 可能重复返回，但翻页结束时每个 key 至少出现一次
+	seen := map[string]bool{}
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > 100 {
+			t.Fatal("list did not complete")
+		}
+		result, err := r.List(bucket, "a", cursor, 2, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result.Keys) > 2 {
+			t.Fatalf("page has %d keys, limit 2", len(result.Keys))
+		}
+		for _, k := range result.Keys {
+			if !strings.HasPrefix(k.Name, "a") || strings.Contains(k.Name, "#meta") {
+				t.Errorf("unexpected key %q", k.Name)
+			}
+			if string(k.Value) != "v-"+k.Name || string(k.Metadata) != `{}` {
+				t.Errorf("key %s: value %q, metadata %s", k.Name, k.Value, k.Metadata)
+			}
+			seen[k.Name] = true
+		}
+		if result.ListComplete {
+			break
+		}
+		var scan uint64
+		var skip int
+		if _, err := fmt.Sscanf(result.Cursor, "%d-%d", &scan, &skip); err != nil {
+			t.Fatalf("cursor %q is not scan-skip", result.Cursor)
+		}
+		cursor = result.Cursor
+	}
+	for _, k := range want {
+		if !seen[k] {
+			t.Errorf("key %s not listed", k)
+		}
+	}
+}
+
+func TestListInvalidCursor(t *testing.T) {
+	requireRedis(t)
+	r := &KVRedis{}
+	for _, cursor := range []string{"abc", "1", "1--1", "YTE"} {
+		if _, err := r.List("test_list_cursor", "", cursor, 10, false); err == nil {
+			t.Errorf("List() with cursor %q succeeded", cursor)
+		}
+	}
+}
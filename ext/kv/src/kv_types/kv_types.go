@@ -1,5 +1,14 @@
 package kvtypes
 
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"math"
+)
+
 type InvokeKVOptions struct {
 	EX int  `json:"EX"`
 	NX bool `json:"NX"`
@@ -7,13 +16,122 @@ type InvokeKVOptions struct {
 }
 
 type InvokeKVRequest struct {
-	RID     string          `json:"rid"`
-	Key     string          `json:"key"`
-	Value   string          `json:"value"`
-	Method  string          `json:"method"`
-	Options InvokeKVOptions `json:"options"`
-	Offset  int             `json:"offset"`
-	Size    int             `json:"size"`
+	RID      string          `json:"rid"`
+	Key      string          `json:"key"`
+	Value    string          `json:"value"`
+	Method   string          `json:"method"`
+	Options  InvokeKVOptions `json:"options"`
+	Offset   int             `json:"offset"`
+	Size     int             `json:"size"`
+	Pattern  string          `json:"pattern"`  // keys 的匹配模式，只支持末尾的 *
+	Prefix   string          `json:"prefix"`   // list 的前缀
+	Cursor   string          `json:"cursor"`   // list 上一页返回的 cursor，为空时从头开始
+	Limit    int             `json:"limit"`    // list 每页的最大数量
+	Values   bool            `json:"values"`   // list 是否返回值
+	Keys     []string        `json:"keys"`     // mget、mdel
+	Entries  []KVEntry       `json:"entries"`  // mset
+	Delta    *int64          `json:"delta"`    // incr、decr，默认为 1
+	Expected *string         `json:"expected"` // cas 期望的当前值，为空且没有 version 时要求 key 不存在
+	Version  string          `json:"version"`  // cas 期望的当前版本
+	Encoding string          `json:"encoding"` // 值的编码，为 base64 时请求与响应中的值都按 base64 编码
+	Metadata json.RawMessage `json:"metadata"` // set 时写入的元数据，为空时删除已有的元数据
+}
+
+const EncodingBase64 = "base64"
+
+// list 每页的默认与最大数量
+const (
+	DefaultListLimit = 1000
+	MaxListLimit     = 1000
+)
+
+// KVListKey list 返回的一个 key，Expiration 为过期时间的 unix 秒数，没有过期时间时为 0
+type KVListKey struct {
+	Name       string
+	Value      []byte
+	Metadata   []byte
+	Expiration int64
+}
+
+// KVListResult list 的一页结果，ListComplete 为 false 时使用 Cursor 获取下一页
+// 一页返回的数量可能少于 limit，是否结束以 ListComplete 为准
+type KVListResult struct {
+	Keys         []KVListKey
+	ListComplete bool
+	Cursor       string
+}
+
+// 元数据的最大长度
+const MaxMetadataSize = 1024
+
+// DecodeValue 按请求的编码解析值
+func (r *InvokeKVRequest) DecodeValue(value string) ([]byte, error) {
+	if r.Encoding == EncodingBase64 {
+		return base64.StdEncoding.DecodeString(value)
+	}
+	return []byte(value), nil
+}
+
+// EncodeValue 按请求的编码返回值，value 为 nil 时返回 nil
+func (r *InvokeKVRequest) EncodeValue(value []byte) *string {
+	if value == nil {
+		return nil
+	}
+	s := string(value)
+	if r.Encoding == EncodingBase64 {
+		s = base64.StdEncoding.EncodeToString(value)
+	}
+	return &s
+}
+
+// MetadataBytes 请求中的元数据，未设置或为 null 时返回 nil
+func (r *InvokeKVRequest) MetadataBytes() []byte {
+	if len(r.Metadata) == 0 || string(r.Metadata) == "null" {
+		return nil
+	}
+	return r.Metadata
+}
+
+// CASMatcher cas 判断当前值是否符合预期，有 version 时按版本比较，否则按 expected 比较
+// 两者都为空时要求 key 不存在
+func (r *InvokeKVRequest) CASMatcher() (func(current []byte) bool, error) {
+	var expected []byte
+	if r.Expected != nil {
+		v, err := r.DecodeValue(*r.Expected)
+		if err != nil {
+			return nil, err
+		}
+		expected = v
+	}
+	return func(current []byte) bool {
+		if r.Version != "" {
+			return KVVersion(current) == r.Version
+		}
+		if r.Expected == nil {
+			return current == nil
+		}
+		return current != nil && bytes.Equal(current, expected)
+	}, nil
+}
+
+// KVEntry mset 的一个键值对
+type KVEntry struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// MetaBucket 保存元数据的 bucket，与值分开保存，列出 key 时不会包含元数据
+func MetaBucket(bucket string) string {
+	return bucket + "#meta"
+}
+
+// KVVersion 值的版本，取值的 sha256 前 16 位，key 不存在时为空
+func KVVersion(value []byte) string {
+	if value == nil {
+		return ""
+	}
+	sum := sha256.Sum256(value)
+	return hex.EncodeToString(sum[:8])
 }
 
 type IKVStorage interface {
@@ -22,6 +140,60 @@ type IKVStorage interface {
 	PutXX(bucket string, key string, value []byte, ttl int) (int, error)
 	Get(bucket string, key string) ([]byte, error)
 	Del(bucket string, key string) error
-	Keys(bucket string, prefix string, offset int, size int) ([]string, error)
+	// List 按前缀列出 key 以及元数据与过期时间，withValues 为 true 时同时返回值
+	// cursor 为上一页返回的不透明字符串，为空时从头开始
+	List(bucket string, prefix string, cursor string, limit int, withValues bool) (*KVListResult, error)
+	// TakeToken 从令牌桶中取出一个令牌，capacity 为桶容量，rate 为每秒补充的令牌数
+	// 令牌不足时返回 false 以及需要等待的毫秒数
+	TakeToken(bucket string, key string, capacity float64, rate float64) (bool, int64, error)
+	// PutWithMetadata 写入值与元数据，元数据与值的过期时间相同，metadata 为 nil 时删除已有的元数据
+	// nx 为 true 时仅在 key 不存在时写入，xx 为 true 时仅在 key 存在时写入，返回是否写入
+	PutWithMetadata(bucket string, key string, value []byte, metadata []byte, ttl int, nx bool, xx bool) (bool, error)
+	// GetWithMetadata 读取值与元数据，key 不存在时都为 nil
+	GetWithMetadata(bucket string, key string) ([]byte, []byte, error)
+	// SetIfAbsent 仅在 key 不存在时写入，返回是否写入
+	SetIfAbsent(bucket string, key string, value []byte, ttl int) (bool, error)
+	// Incr 原子地加上 delta 并返回新值，key 不存在时从 0 开始
+	// ttl 大于 0 且 key 没有过期时间时设置过期时间，已有的过期时间保持不变
+	Incr(bucket string, key string, delta int64, ttl int) (int64, error)
+	// MGet 批量读取，不存在的 key 对应 nil
+	MGet(bucket string, keys []string) ([][]byte, error)
+	// MSet 在一个事务中批量写入，会删除这些 key 已有的元数据
+	MSet(bucket string, entries []KVEntry, ttl int) error
+	// MDel 批量删除，返回实际删除的数量
+	MDel(bucket string, keys []string) (int, error)
+	// CAS 当前值满足 match 时写入 value 并删除已有的元数据，current 为 nil 表示 key 不存在
+	// 返回是否写入以及操作之后的值
+	CAS(bucket string, key string, match func(current []byte) bool, value []byte, ttl int) (bool, []byte, error)
+	// TTL 剩余的过期时间（秒），没有过期时间时为 -1，key 不存在时为 -2
+	TTL(bucket string, key string) (int64, error)
+	// Expire 设置过期时间，ttl 小于等于 0 时取消过期，key 不存在时返回 false
+	Expire(bucket string, key string, ttl int) (bool, error)
 	Close()
 }
+
+// TokenBucket 令牌桶的状态，Time 为上次取令牌的毫秒时间戳
+type TokenBucket struct {
+	Tokens float64 `json:"tokens"`
+	Time   int64   `json:"time"`
+}
+
+// Take 按经过的时间补充令牌后取出一个
+func (b *TokenBucket) Take(capacity float64, rate float64, now int64) (bool, int64) {
+	if b.Time == 0 {
+		b.Tokens = capacity
+	} else if now > b.Time {
+		b.Tokens = math.Min(capacity, b.Tokens+float64(now-b.Time)/1000*rate)
+	}
+	b.Time = now
+	if b.Tokens >= 1 {
+		b.Tokens--
+		return true, 0
+	}
+	return false, int64(math.Ceil((1 - b.Tokens) / rate * 1000))
+}
+
+// TokenBucketTTL 令牌桶装满所需的秒数，超过这个时间未访问的状态可以丢弃
+func TokenBucketTTL(capacity float64, rate float64) int {
+	return int(math.Ceil(capacity/rate)) + 1
+}
@@ -0,0 +1,215 @@
+package kvtypes
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestTokenBucketTake(t *testing.T) {
+	type take struct {
+		now   int64
+		ok    bool
+		retry int64
+	}
+	tests := []struct {
+		name     string
+		capacity float64
+		rate     float64
+		takes    []take
+	}{
+		{
+			name:     "burst up to capacity",
+			capacity: 3,
+			rate:     1,
+			takes: []take{
+				{now: 1000, ok: true},
+				{now: 1000, ok: true},
+				{now: 1000, ok: true},
+				{now: 1000, ok: false, retry: 1000},
+			},
+		},
+		{
+			name:     "refill by elapsed time",
+			capacity: 2,
+			rate:     2,
+			takes: []take{
+				{now: 1000, ok: true},
+				{now: 1000, ok: true},
+				{now: 1250, ok: false, retry: 250},
+				{now: 1500, ok: true},
+				{now: 1500, ok: false, retry: 500},
+			},
+		},
+		{
+			name:     "refill capped at capacity",
+			capacity: 2,
+			rate:     10,
+			takes: []take{
+				{now: 1000, ok: true},
+				{now: 1000, ok: true},
+				{now: 61000, ok: true},
+				{now: 61000, ok: true},
+				{now: 61000, ok: false, retry: 100},
+			},
+		},
+		{
+			name:     "clock going back does not refill",
+			capacity: 1,
+			rate:     1,
+			takes: []take{
+				{now: 5000, ok: true},
+				{now: 3000, ok: false, retry: 1000},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &TokenBucket{}
+			for i, tk := range tt.takes {
+				ok, retry := b.Take(tt.capacity, tt.rate, tk.now)
+				if ok != tk.ok || retry != tk.retry {
+					t.Fatalf("take %d at %d: got (%v, %d), want (%v, %d)", i, tk.now, ok, retry, tk.ok, tk.retry)
+				}
+			}
+		})
+	}
+}
+
+func TestTokenBucketTTL(t *testing.T) {
+	tests := []struct {
+		capacity float64
+		rate     float64
+		want     int
+	}{
+		{capacity: 10, rate: 10, want: 2},
+		{capacity: 10, rate: 3, want: 5},
+		{capacity: 1, rate: 100, want: 2},
+	}
+	for _, tt := range tests {
+		if got := TokenBucketTTL(tt.capacity, tt.rate); got != tt.want {
+			t.Errorf("TokenBucketTTL(%v, %v) = %d, want %d", tt.capacity, tt.rate, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeValue(t *testing.T) {
+	tests := []struct {
+		name     string
+		encoding string
+		value    string
+		want     []byte
+		wantErr  bool
+	}{
+		{name: "plain", value: "hello", want: []byte("hello")},
+		{name: "plain empty", value: "", want: []byte{}},
+		{name: "plain keeps bytes", value: "\xff\xfe", want: []byte{0xff, 0xfe}},
+		{name: "base64", encoding: EncodingBase64, value: "aGVsbG8=", want: []byte("hello")},
+		{name: "base64 binary", encoding: EncodingBase64, value: "//4AAQ==", want: []byte{0xff, 0xfe, 0x00, 0x01}},
+		{name: "base64 invalid", encoding: EncodingBase64, value: "not base64!", wantErr: true},
+		{name: "base64 url alphabet", encoding: EncodingBase64, value: "__4AAQ==", wantErr: true},
+		{name: "unknown encoding as plain", encoding: "hex", value: "ff", want: []byte("ff")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &InvokeKVRequest{Encoding: tt.encoding}
+			got, err := r.DecodeValue(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DecodeValue() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !bytes.Equal(got, tt.want) {
+				t.Errorf("DecodeValue() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncodeValue(t *testing.T) {
+	tests := []struct {
+		name     string
+		encoding string
+		value    []byte
+		want     *string
+	}{
+		{name: "nil", value: nil, want: nil},
+		{name: "nil base64", encoding: EncodingBase64, value: nil, want: nil},
+		{name: "plain", value: []byte("hello"), want: ptr("hello")},
+		{name: "plain empty", value: []byte{}, want: ptr("")},
+		{name: "base64", encoding: EncodingBase64, value: []byte("hello"), want: ptr("aGVsbG8=")},
+		{name: "base64 empty", encoding: EncodingBase64, value: []byte{}, want: ptr("")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &InvokeKVRequest{Encoding: tt.encoding}
+			got := r.EncodeValue(tt.value)
+			if (got == nil) != (tt.want == nil) || got != nil && *got != *tt.want {
+				t.Errorf("EncodeValue() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// 非 UTF-8 的值只有按 base64 编码才能原样往返
+func TestValueRoundTrip(t *testing.T) {
+	values := [][]byte{
+		{0xff, 0xfe, 0xfd},
+		{0x00, 0x80, 0xc3, 0x28},
+		[]byte("中文\x00\xff"),
+		{},
+	}
+	r := &InvokeKVRequest{Encoding: EncodingBase64}
+	for _, v := range values {
+		encoded := r.EncodeValue(v)
+		if encoded == nil {
+			t.Fatalf("EncodeValue(%v) = nil", v)
+		}
+		got, err := r.DecodeValue(*encoded)
+		if err != nil || !bytes.Equal(got, v) {
+			t.Errorf("round trip of %v = %v, %v", v, got, err)
+		}
+	}
+}
+
+func TestMetadataBytes(t *testing.T) {
+	tests := []struct {
+		name     string
+		metadata json.RawMessage
+		want     []byte
+	}{
+		{name: "unset", metadata: nil, want: nil},
+		{name: "empty", metadata: json.RawMessage{}, want: nil},
+		{name: "null", metadata: json.RawMessage("null"), want: nil},
+		{name: "object", metadata: json.RawMessage(`{"a":1}`), want: []byte(`{"a":1}`)},
+		{name: "string", metadata: json.RawMessage(`"x"`), want: []byte(`"x"`)},
+		{name: "false", metadata: json.RawMessage("false"), want: []byte("false")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &InvokeKVRequest{Metadata: tt.metadata}
+			if got := r.MetadataBytes(); (got == nil) != (tt.want == nil) || !bytes.Equal(got, tt.want) {
+				t.Errorf("MetadataBytes() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// 请求中的 metadata 按原始 JSON 保存
+func TestMetadataFromRequest(t *testing.T) {
+	var r InvokeKVRequest
+	if err := json.Unmarshal([]byte(`{"metadata": {"b": [1, 2]}}`), &r); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(r.MetadataBytes()); got != `{"b": [1, 2]}` {
+		t.Errorf("MetadataBytes() = %s", got)
+	}
+	if err := json.Unmarshal([]byte(`{"metadata": null}`), &r); err != nil {
+		t.Fatal(err)
+	}
+	if got := r.MetadataBytes(); got != nil {
+		t.Errorf("MetadataBytes() = %s, want nil", got)
+	}
+}
+
+func ptr(s string) *string {
+	return &s
+}
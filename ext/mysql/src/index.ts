@@ -5,7 +5,7 @@ import { WorkerEntrypoint, env } from 'cloudflare:workers'
 const eenv = env as unknown as any
 
 let commonConfig = {
-	"x-secret": eenv.X_SECRET,
+	"x-resource-token": eenv.RESOURCE_TOKEN,
 	"x-node-name": eenv.X_NODENAME,
 }
 
@@ -194,6 +194,20 @@ func DownloadFile(objectName string) (io.ReadCloser, error) {
 	return body, nil
 }
 
+func RemoveFile(objectName string) error {
+	bucketName := conf.AppConfigInstance.FileStorageOSSBucket
+	client, err := getSysBucketClient()
+	if err != nil {
+		return err
+	}
+	bucket, err := client.Bucket(bucketName)
+	if err != nil {
+		return err
+	}
+
+	return bucket.DeleteObject(conf.AppConfigInstance.FileStorageOSSPrefix + objectName)
+}
+
 func getSysBucketClient() (*aoss.Client, error) {
 
 	endpoint := fmt.Sprintf("%s:%d", conf.AppConfigInstance.ServerMinioHost, conf.AppConfigInstance.ServerMinioPort)
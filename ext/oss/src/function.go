@@ -15,6 +15,7 @@ import (
 func init() {
 	funcs.SetUploadFileToSysBucket(UploadFileToSysBucket)
 	funcs.SetDownloadFileFromSysBucket(DownloadFileFromSysBucket)
+	funcs.SetDeleteFileFromSysBucket(DeleteFileFromSysBucket)
 }
 
 func UploadFileToSysBucket(path string, obj io.Reader) error {
@@ -39,6 +40,17 @@ func DownloadFileFromSysBucket(path string) (io.ReadCloser, error) {
 	}
 }
 
+func DeleteFileFromSysBucket(path string) error {
+	switch conf.AppConfigInstance.ServerOSSType {
+	case "aliyun":
+		return RemoveFile(path)
+	case "aliyun1":
+		return alioss1.RemoveFile(path)
+	default:
+		return RemoveFile(path)
+	}
+}
+
 func getSysMinioClient() (*MinioClient, error) {
 	endpoint := fmt.Sprintf("%s:%d", conf.AppConfigInstance.ServerMinioHost, conf.AppConfigInstance.ServerMinioPort)
 	accessKeyID := conf.AppConfigInstance.ServerMinioAccess
@@ -92,3 +104,15 @@ func UploadFile(path string, obj io.Reader) error {
 
 	return nil
 }
+
+func RemoveFile(path string) error {
+	mc, err := getSysMinioClient()
+	if err != nil {
+		return err
+	}
+
+	return mc.Client.RemoveObject(context.Background(),
+		conf.AppConfigInstance.FileStorageOSSBucket,
+		conf.AppConfigInstance.FileStorageOSSPrefix+path,
+		minio.RemoveObjectOptions{})
+}
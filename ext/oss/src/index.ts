@@ -33,7 +33,7 @@ const {
 	USE_SSL,
 	REGION,
 	RESOURCE_ID,
-	X_SECRET,
+	RESOURCE_TOKEN,
 	X_NODENAME
 } = env1;
 
@@ -45,7 +45,7 @@ let commonConfig = {
 	Region: REGION,
 	Bucket: BUCKET,
 	ResourceID: RESOURCE_ID,
-	"x-secret": X_SECRET,
+	"x-resource-token": RESOURCE_TOKEN,
 	"x-node-name": X_NODENAME,
 }
 
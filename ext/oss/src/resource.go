@@ -0,0 +1,37 @@
+package oss
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"vvorker/common"
+	"vvorker/conf"
+	"vvorker/defs"
+	"vvorker/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ResourceHeaders 用资源凭证对应的 worker 配置覆盖请求头中的 bucket、密钥与资源 ID
+// 各 oss 实现仍然从请求头读取参数，worker 传入的值不会生效
+func ResourceHeaders() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		v, _ := c.Get(defs.KeyWorkerResource)
+		res, ok := v.(*models.WorkerResource)
+		// 单 bucket 模式使用服务端的密钥，只能访问资源 ID 对应的目录
+		if !ok || res.OSS == nil || (conf.AppConfigInstance.MinioSingleBucketMode && len(res.ResourceID) == 0) {
+			common.RespErr(c, http.StatusForbidden, "forbidden", nil)
+			c.Abort()
+			return
+		}
+		h := c.Request.Header
+		h.Set("ResourceID", res.ResourceID)
+		h.Set("Bucket", res.OSS.Bucket)
+		h.Set("Endpoint", fmt.Sprintf("%s:%d", res.OSS.Host, res.OSS.Port))
+		h.Set("AccessKeyID", res.OSS.AccessKeyId)
+		h.Set("SecretAccessKey", res.OSS.AccessKeySecret)
+		h.Set("UseSSL", strconv.FormatBool(res.OSS.UseSSL))
+		h.Set("Region", res.OSS.Region)
+		c.Next()
+	}
+}
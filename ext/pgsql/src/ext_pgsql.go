@@ -3,7 +3,10 @@ package pgsql
 import (
 	"database/sql"
 	"fmt"
+	"net"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"vvorker/common"
 	"vvorker/conf"
@@ -455,11 +458,43 @@ func ExecuteSQLPgSQLEndpoint(c *gin.Context) {
 	CommonDBQuery(dbConns, c, "postgres")
 }
 
+// sqlConnectionString 与生成绑定时一致，平台创建的数据库通过本节点的端口访问
+func sqlConnectionString(res *models.WorkerResource, sqltype string) string {
+	cfg := *res.SQL
+	if len(res.ResourceID) != 0 {
+		cfg.Host = "localhost"
+		cfg.Port = conf.AppConfigInstance.ClientPostgrePort
+		if sqltype == "mysql" {
+			cfg.Port = conf.AppConfigInstance.ClientMySQLPort
+		}
+	}
+	addr := net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
+	if sqltype == "mysql" {
+		return fmt.Sprintf("%s:%s@tcp(%s)/%s", cfg.User, cfg.Password, addr, cfg.Database)
+	}
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(cfg.User, cfg.Password),
+		Host:     addr,
+		Path:     "/" + cfg.Database,
+		RawQuery: "sslmode=disable",
+	}
+	return u.String()
+}
+
 func CommonDBQuery(conns *defs.SyncMap[string, *sql.DB], c *gin.Context, sqltype string) {
 	var req = entities.ExecuteSQLReq{}
 	if err := c.BindJSON(&req); err != nil {
 		return
 	}
+	// 连接参数取自资源凭证对应的 worker 配置，忽略请求中的 connection_string
+	v, _ := c.Get(defs.KeyWorkerResource)
+	res, ok := v.(*models.WorkerResource)
+	if !ok || res.SQL == nil {
+		common.RespErr(c, http.StatusForbidden, "forbidden", gin.H{"error": "resource not allowed"})
+		return
+	}
+	req.ConnectionString = sqlConnectionString(res, sqltype)
 	dbConn, ok := conns.Get(req.ConnectionString)
 	if !ok {
 		dbConn2, err := sql.Open(sqltype, req.ConnectionString)
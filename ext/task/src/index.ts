@@ -8,11 +8,11 @@ const eenv = env as unknown as any
 const {
 	MASTER_ENDPOINT,
 	WORKER_UID,
-	X_SECRET,
+	RESOURCE_TOKEN,
 	X_NODENAME
 } = eenv
 let commonConfig = {
-	"x-secret": X_SECRET,
+	"x-resource-token": RESOURCE_TOKEN,
 	"x-node-name": X_NODENAME,
 	"Content-Type": "application/json",
 }
@@ -89,3 +89,23 @@ func DownloadFileFromSysBucket(path string) (io.ReadCloser, error) {
 	}
 	return downloadFileFromSysBucket(path)
 }
+
+type DeleteFileFromSysBucketFunc func(path string) error
+
+// deleteFileFromSysBucket is the actual function that will be called
+var deleteFileFromSysBucket DeleteFileFromSysBucketFunc
+
+// SetDeleteFileFromSysBucket sets the function that will be used for deleting file from system bucket
+// This should be called during package initialization
+func SetDeleteFileFromSysBucket(fn DeleteFileFromSysBucketFunc) {
+	deleteFileFromSysBucket = fn
+}
+
+// DeleteFileFromSysBucket deletes a file from system bucket
+// This is a wrapper around the actual implementation that can be set during initialization
+func DeleteFileFromSysBucket(path string) error {
+	if deleteFileFromSysBucket == nil {
+		panic("DeleteFileFromSysBucket function not initialized. Call SetDeleteFileFromSysBucket during package initialization.")
+	}
+	return deleteFileFromSysBucket(path)
+}
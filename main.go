@@ -2,6 +2,7 @@ package main
 
 import (
 	"embed"
+	"flag"
 	"fmt"
 	"vvorker/conf"
 	"vvorker/exec"
@@ -41,6 +42,7 @@ func printBanner() {
 }
 
 func main() {
+	flag.Parse()
 	if conf.AppConfigInstance.ModeRelease {
 		gin.SetMode(gin.ReleaseMode)
 	}
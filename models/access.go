@@ -1,6 +1,10 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
 
 type AccessKey struct {
 	gorm.Model
@@ -19,7 +23,7 @@ type InternalServerWhiteList struct {
 type ExternalServerAKSK struct {
 	gorm.Model
 	WorkerUID      string `json:"worker_uid" gorm:"index"`
-	AccessKey      string `json:"access_key"`
+	AccessKey      string `json:"access_key" gorm:"index"`
 	SecretKey      string `json:"secret_key"`
 	Description    string `json:"description"`
 	Forever        bool   `json:"forever"`
@@ -28,17 +32,22 @@ type ExternalServerAKSK struct {
 
 type ExternalServerToken struct {
 	gorm.Model
-	WorkerUID      string `json:"worker_uid" gorm:"index"`
-	Token          string `json:"token"`
-	Description    string `json:"description"`
-	Forever        bool   `json:"forever"`
-	ExpirationTime string `json:"expiration_time"`
+	WorkerUID      string     `json:"worker_uid" gorm:"index"`
+	Token          string     `json:"token"` // 只在迁移前的旧数据中保存明文，现在只保存哈希
+	TokenHash      string     `json:"-" gorm:"index;size:64"`
+	TokenPrefix    string     `json:"token_prefix"` // 明文的前几位，用于在列表中区分
+	Description    string     `json:"description"`
+	Forever        bool       `json:"forever"`
+	ExpirationTime string     `json:"expiration_time"`
+	ExpiresAt      *time.Time `json:"expires_at"` // 为空时不过期
+	LastUsedAt     *time.Time `json:"last_used_at"`
+	RotatedAt      *time.Time `json:"rotated_at"` // 轮换后旧 token 在 ExpiresAt 之前仍然可用
 }
 
 type AccessRule struct {
 	gorm.Model
 	WorkerUID   string `json:"worker_uid" gorm:"index"`
-	RuleType    string `json:"rule_type"` // "internal", "aksk", "token", "sso", "open"
+	RuleType    string `json:"rule_type"` // "internal", "aksk", "token", "sso", "open", "ratelimit", "ip"
 	Path        string `json:"path"`
 	Description string `json:"description"`
 	Length      int    `json:"length"`
@@ -46,3 +55,30 @@ type AccessRule struct {
 	Data        string `json:"data"`
 	Status      int    `json:"status" gorm:"default:1"` // 1 enable 2 disable
 }
+
+// expiration_time 支持的格式，不带时区的按本地时间解析
+var expirationTimeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+func ParseExpirationTime(s string) (time.Time, error) {
+	var err error
+	for _, layout := range expirationTimeLayouts {
+		var t time.Time
+		if t, err = time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+// Expired 非永久有效的密钥超过过期时间，或过期时间无法解析时视为已过期
+func (a *ExternalServerAKSK) Expired(now time.Time) bool {
+	if a.Forever {
+		return false
+	}
+	t, err := ParseExpirationTime(a.ExpirationTime)
+	return err != nil || !now.Before(t)
+}
@@ -0,0 +1,84 @@
+package models
+
+import (
+	"encoding/json"
+	"net/netip"
+	"strings"
+)
+
+// IPAccessRule ip 规则的 Data，Deny 优先于 Allow，Allow 为空时不限制来源
+type IPAccessRule struct {
+	Allow          []string `json:"allow"`
+	Deny           []string `json:"deny"`
+	TrustedProxies []string `json:"trusted_proxies"` // 在全局 TRUSTED_PROXIES 之外额外信任的代理
+}
+
+type IPRuleMatcher struct {
+	Allow          []netip.Prefix
+	Deny           []netip.Prefix
+	TrustedProxies []netip.Prefix
+}
+
+// ParseIPAccessRule 解析 ip 规则的 Data，不合法的 IP 或 CIDR 返回错误
+func ParseIPAccessRule(data string) (*IPRuleMatcher, error) {
+	rule := IPAccessRule{}
+	if err := json.Unmarshal([]byte(data), &rule); err != nil {
+		return nil, err
+	}
+	m := &IPRuleMatcher{}
+	var err error
+	if m.Allow, err = ParsePrefixes(rule.Allow); err != nil {
+		return nil, err
+	}
+	if m.Deny, err = ParsePrefixes(rule.Deny); err != nil {
+		return nil, err
+	}
+	if m.TrustedProxies, err = ParsePrefixes(rule.TrustedProxies); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ParsePrefixes 解析 CIDR 列表，单个 IP 视为只包含该地址的网段
+func ParsePrefixes(list []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(list))
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, err
+			}
+			addr = addr.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	return prefixes, nil
+}
+
+func PrefixesContain(prefixes []netip.Prefix, addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allowed 判断来源 IP 是否允许访问
+func (m *IPRuleMatcher) Allowed(addr netip.Addr) bool {
+	if PrefixesContain(m.Deny, addr) {
+		return false
+	}
+	return len(m.Allow) == 0 || PrefixesContain(m.Allow, addr)
+}
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestIPRuleMatcherAllowed(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		addr string
+		want bool
+	}{
+		{name: "empty allow", data: `{}`, addr: "203.0.113.7", want: true},
+		{name: "cidr allow hit", data: `{"allow":["10.0.0.0/8"]}`, addr: "10.1.2.3", want: true},
+		{name: "cidr allow miss", data: `{"allow":["10.0.0.0/8"]}`, addr: "192.168.1.1", want: false},
+		{name: "single ip allow", data: `{"allow":["192.168.1.1"]}`, addr: "192.168.1.1", want: true},
+		{name: "unmasked cidr", data: `{"allow":["10.1.2.3/24"]}`, addr: "10.1.2.200", want: true},
+		{name: "cidr deny", data: `{"deny":["10.0.0.0/8"]}`, addr: "10.1.2.3", want: false},
+		{name: "cidr deny miss", data: `{"deny":["10.0.0.0/8"]}`, addr: "192.168.1.1", want: true},
+		{name: "deny over allow", data: `{"allow":["10.0.0.0/8"],"deny":["10.1.0.0/16"]}`, addr: "10.1.2.3", want: false},
+		{name: "allow outside deny", data: `{"allow":["10.0.0.0/8"],"deny":["10.1.0.0/16"]}`, addr: "10.2.0.1", want: true},
+		{name: "ipv4 mapped ipv6", data: `{"allow":["10.0.0.0/8"]}`, addr: "::ffff:10.0.0.1", want: true},
+		{name: "ipv6 cidr", data: `{"allow":["2001:db8::/32"]}`, addr: "2001:db8::1", want: true},
+		{name: "ipv6 cidr miss", data: `{"allow":["2001:db8::/32"]}`, addr: "2001:db9::1", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := ParseIPAccessRule(tt.data)
+			if err != nil {
+				t.Fatalf("ParseIPAccessRule: %v", err)
+			}
+			if got := m.Allowed(netip.MustParseAddr(tt.addr)); got != tt.want {
+				t.Errorf("Allowed(%s) = %v, want %v", tt.addr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseIPAccessRuleInvalid(t *testing.T) {
+	tests := []string{
+		`{"allow":["10.0.0.0/33"]}`,
+		`{"deny":["not-an-ip"]}`,
+		`{"trusted_proxies":["10.0.0"]}`,
+		`not json`,
+	}
+	for _, data := range tests {
+		if _, err := ParseIPAccessRule(data); err == nil {
+			t.Errorf("ParseIPAccessRule(%s) expected error", data)
+		}
+	}
+}
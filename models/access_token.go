@@ -0,0 +1,138 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+	"vvorker/utils"
+	"vvorker/utils/database"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// 最后使用时间的更新间隔，避免每个请求都写数据库
+const tokenLastUsedInterval = time.Minute
+
+func HashAccessToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func accessTokenPrefix(token string) string {
+	if len(token) < 3 {
+		return token
+	}
+	return token[:3]
+}
+
+// NewExternalServerToken 生成新的 token，返回的明文只在创建时可见，记录中只保存哈希
+func NewExternalServerToken(workerUID, description string, forever bool, expiresAt *time.Time) (*ExternalServerToken, string) {
+	token := utils.GenerateUID()
+	t := &ExternalServerToken{
+		WorkerUID:   workerUID,
+		TokenHash:   HashAccessToken(token),
+		TokenPrefix: accessTokenPrefix(token),
+		Description: description,
+		Forever:     forever,
+	}
+	if !forever && expiresAt != nil {
+		t.ExpiresAt = expiresAt
+		t.ExpirationTime = expiresAt.Format(time.RFC3339)
+	}
+	return t, token
+}
+
+// Expired 永久有效或没有过期时间的 token 不会过期
+func (t *ExternalServerToken) Expired(now time.Time) bool {
+	if t.Forever || t.ExpiresAt == nil {
+		return false
+	}
+	return !now.Before(*t.ExpiresAt)
+}
+
+// FindExternalServerToken 按哈希查找 worker 的 token
+func FindExternalServerToken(workerUID, token string) (*ExternalServerToken, error) {
+	var t ExternalServerToken
+	if err := database.GetDB().Where(&ExternalServerToken{
+		WorkerUID: workerUID,
+		TokenHash: HashAccessToken(token),
+	}).First(&t).Error; err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// TouchLastUsed 更新最后使用时间，间隔内的重复调用会被忽略
+func (t *ExternalServerToken) TouchLastUsed(now time.Time) {
+	if t.LastUsedAt != nil && now.Sub(*t.LastUsedAt) < tokenLastUsedInterval {
+		return
+	}
+	go func() {
+		if err := database.GetDB().Model(&ExternalServerToken{}).Where("id = ?", t.ID).
+			Update("last_used_at", now).Error; err != nil {
+			logrus.WithError(err).Warnf("update token %d last used error", t.ID)
+		}
+	}()
+}
+
+// RotateExternalServerToken 生成新的 token 替换旧 token，旧 token 在 grace 之后过期
+// 旧 token 原本的过期时间更早时保持不变
+func RotateExternalServerToken(old *ExternalServerToken, grace time.Duration) (*ExternalServerToken, string, error) {
+	now := time.Now()
+	t, token := NewExternalServerToken(old.WorkerUID, old.Description, old.Forever, old.ExpiresAt)
+
+	graceUntil := now.Add(grace)
+	if old.Forever || old.ExpiresAt == nil || old.ExpiresAt.After(graceUntil) {
+		old.ExpiresAt = &graceUntil
+	}
+	old.Forever = false
+	err := database.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&ExternalServerToken{}).Where("id = ?", old.ID).Updates(map[string]interface{}{
+			"forever":         false,
+			"expires_at":      old.ExpiresAt,
+			"expiration_time": old.ExpiresAt.Format(time.RFC3339),
+			"rotated_at":      now,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Create(t).Error
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return t, token, nil
+}
+
+// MigrateExternalServerTokens 将旧数据中的明文 token 转为哈希，字符串形式的过期时间转为 ExpiresAt
+// 过期时间无法解析的 token 直接过期
+func MigrateExternalServerTokens() {
+	db := database.GetDB()
+	tokens := []ExternalServerToken{}
+	if err := db.Where("token_hash = ? OR token_hash IS NULL", "").Find(&tokens).Error; err != nil {
+		logrus.WithError(err).Error("load access tokens error")
+		return
+	}
+	for _, t := range tokens {
+		if t.Token == "" {
+			continue
+		}
+		updates := map[string]interface{}{
+			"token_hash":   HashAccessToken(t.Token),
+			"token_prefix": accessTokenPrefix(t.Token),
+			"token":        "",
+		}
+		if !t.Forever && t.ExpiresAt == nil && t.ExpirationTime != "" {
+			expiresAt, err := ParseExpirationTime(t.ExpirationTime)
+			if err != nil {
+				// 与 AK/SK 一致，无法解析的过期时间视为已过期
+				logrus.WithError(err).Warnf("parse token %d expiration time error, token expired", t.ID)
+				expiresAt = time.Now()
+			}
+			updates["expires_at"] = expiresAt
+		}
+		if err := db.Model(&ExternalServerToken{}).Where("id = ?", t.ID).Updates(updates).Error; err != nil {
+			logrus.WithError(err).Errorf("migrate token %d error", t.ID)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package models
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+	"vvorker/conf"
+	"vvorker/defs"
+	"vvorker/utils/database"
+)
+
+func setupTokenTestDB(t *testing.T) {
+	t.Helper()
+	conf.AppConfigInstance.DBType = defs.DBTypeSqlite
+	conf.AppConfigInstance.DBPath = filepath.Join(t.TempDir(), "db.sqlite")
+	database.InitDB()
+	if err := database.GetDB().AutoMigrate(&ExternalServerToken{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func loadToken(t *testing.T, id uint) *ExternalServerToken {
+	t.Helper()
+	var tk ExternalServerToken
+	if err := database.GetDB().First(&tk, id).Error; err != nil {
+		t.Fatal(err)
+	}
+	return &tk
+}
+
+func TestExternalServerTokenExpired(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Minute)
+	future := now.Add(time.Minute)
+	tests := []struct {
+		name  string
+		token ExternalServerToken
+		want  bool
+	}{
+		{name: "forever", token: ExternalServerToken{Forever: true, ExpiresAt: &past}, want: false},
+		{name: "no expiry", token: ExternalServerToken{}, want: false},
+		{name: "past", token: ExternalServerToken{ExpiresAt: &past}, want: true},
+		{name: "exactly now", token: ExternalServerToken{ExpiresAt: &now}, want: true},
+		{name: "future", token: ExternalServerToken{ExpiresAt: &future}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.token.Expired(now); got != tt.want {
+				t.Errorf("Expired() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRotateExternalServerToken(t *testing.T) {
+	setupTokenTestDB(t)
+	hour := time.Now().Add(time.Hour)
+	fiveMin := time.Now().Add(5 * time.Minute)
+	tests := []struct {
+		name      string
+		forever   bool
+		expiresAt *time.Time
+		grace     time.Duration
+		// 旧 token 期望的过期时间距现在的间隔
+		wantOld time.Duration
+	}{
+		{name: "forever", forever: true, grace: 10 * time.Minute, wantOld: 10 * time.Minute},
+		{name: "no expiry", grace: 10 * time.Minute, wantOld: 10 * time.Minute},
+		{name: "grace shorter than expiry", expiresAt: &hour, grace: 10 * time.Minute, wantOld: 10 * time.Minute},
+		{name: "grace longer than expiry", expiresAt: &fiveMin, grace: time.Hour, wantOld: 5 * time.Minute},
+		{name: "zero grace", expiresAt: &hour, grace: 0, wantOld: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old, oldPlain := NewExternalServerToken("worker-"+tt.name, "desc", tt.forever, tt.expiresAt)
+			if err := database.GetDB().Create(old).Error; err != nil {
+				t.Fatal(err)
+			}
+
+			start := time.Now()
+			nt, plain, err := RotateExternalServerToken(old, tt.grace)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if plain == oldPlain || nt.TokenHash != HashAccessToken(plain) {
+				t.Fatalf("unexpected new token")
+			}
+			if nt.Forever != tt.forever || nt.Description != "desc" {
+				t.Errorf("new token = %+v", nt)
+			}
+			if tt.expiresAt != nil && (nt.ExpiresAt == nil || !nt.ExpiresAt.Equal(*tt.expiresAt)) {
+				t.Errorf("new token expires at %v, want %v", nt.ExpiresAt, tt.expiresAt)
+			}
+
+			stored := loadToken(t, old.ID)
+			if stored.Forever || stored.RotatedAt == nil || stored.ExpiresAt == nil {
+				t.Fatalf("old token = %+v", stored)
+			}
+			if d := stored.ExpiresAt.Sub(start) - tt.wantOld; d < -time.Second || d > time.Second {
+				t.Errorf("old token expires at %v, want about now+%v", stored.ExpiresAt, tt.wantOld)
+			}
+			if tt.grace > 0 && stored.Expired(start) {
+				t.Errorf("old token expired inside grace window")
+			}
+			if !stored.Expired(start.Add(tt.wantOld + time.Second)) {
+				t.Errorf("old token not expired after grace window")
+			}
+
+			found, err := FindExternalServerToken(old.WorkerUID, plain)
+			if err != nil || found.ID != nt.ID {
+				t.Errorf("FindExternalServerToken() = %v, %v", found, err)
+			}
+			if found, err := FindExternalServerToken(old.WorkerUID, oldPlain); err != nil || found.ID != old.ID {
+				t.Errorf("old token lookup = %v, %v", found, err)
+			}
+		})
+	}
+}
+
+func TestMigrateExternalServerTokens(t *testing.T) {
+	setupTokenTestDB(t)
+	future := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	tests := []struct {
+		name           string
+		forever        bool
+		expirationTime string
+		wantExpiresAt  bool
+		wantExpired    bool
+	}{
+		{name: "rfc3339", expirationTime: future.Format(time.RFC3339), wantExpiresAt: true, wantExpired: false},
+		{name: "past date", expirationTime: "2000-01-02", wantExpiresAt: true, wantExpired: true},
+		{name: "unparseable", expirationTime: "next tuesday", wantExpiresAt: true, wantExpired: true},
+		{name: "forever unparseable", forever: true, expirationTime: "next tuesday", wantExpired: false},
+		{name: "empty", wantExpired: false},
+	}
+	ids := make([]uint, len(tests))
+	for i, tt := range tests {
+		tk := &ExternalServerToken{
+			WorkerUID:      "worker",
+			Token:          "legacy-token-" + tt.name,
+			Forever:        tt.forever,
+			ExpirationTime: tt.expirationTime,
+		}
+		if err := database.GetDB().Create(tk).Error; err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = tk.ID
+	}
+
+	MigrateExternalServerTokens()
+
+	now := time.Now()
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tk := loadToken(t, ids[i])
+			plain := "legacy-token-" + tt.name
+			if tk.Token != "" || tk.TokenHash != HashAccessToken(plain) || tk.TokenPrefix != plain[:3] {
+				t.Errorf("token not migrated: %+v", tk)
+			}
+			if (tk.ExpiresAt != nil) != tt.wantExpiresAt {
+				t.Errorf("ExpiresAt = %v, want set %v", tk.ExpiresAt, tt.wantExpiresAt)
+			}
+			if tt.name == "rfc3339" && tk.ExpiresAt != nil && !tk.ExpiresAt.Equal(future) {
+				t.Errorf("ExpiresAt = %v, want %v", tk.ExpiresAt, future)
+			}
+			if got := tk.Expired(now); got != tt.wantExpired {
+				t.Errorf("Expired() = %v, want %v", got, tt.wantExpired)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package models
+
+import (
+	"vvorker/utils/database"
+
+	"gorm.io/gorm"
+)
+
+// AssetsRelease 一次整体发布的静态资源清单，切换时整体替换 worker 的 Assets，保留旧清单用于回滚
+type AssetsRelease struct {
+	gorm.Model
+	UID       string `gorm:"uniqueIndex" json:"uid"`
+	WorkerUID string `gorm:"index" json:"worker_uid"`
+	UserID    uint64 `json:"user_id"`
+	FileCount int    `json:"file_count"`
+	Size      int64  `json:"size"`
+	Active    bool   `json:"active"` // 当前生效的清单
+}
+
+func (r *AssetsRelease) TableName() string {
+	return "assets_releases"
+}
+
+// AssetsReleaseFile 清单中的一个文件，UID 为 File 的 UID
+type AssetsReleaseFile struct {
+	gorm.Model
+	ReleaseUID string `gorm:"index"`
+	UID        string `gorm:"index"`
+	Path       string
+	Name       string
+	MIME       string
+	Hash       string
+}
+
+func (f *AssetsReleaseFile) TableName() string {
+	return "assets_release_files"
+}
+
+func GetAssetsRelease(workerUID, releaseUID string) (*AssetsRelease, error) {
+	var release AssetsRelease
+	db := database.GetDB()
+	if err := db.Where(&AssetsRelease{WorkerUID: workerUID, UID: releaseUID}).First(&release).Error; err != nil {
+		return nil, err
+	}
+	return &release, nil
+}
+
+func ListAssetsReleases(workerUID string) ([]AssetsRelease, error) {
+	releases := []AssetsRelease{}
+	db := database.GetDB()
+	if err := db.Where(&AssetsRelease{WorkerUID: workerUID}).Order("id desc").Find(&releases).Error; err != nil {
+		return nil, err
+	}
+	return releases, nil
+}
+
+// CreateAssetsRelease 保存清单并立即切换，worker 的 Assets 在同一个事务中整体替换
+func CreateAssetsRelease(release *AssetsRelease, files []AssetsReleaseFile) error {
+	return database.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(release).Error; err != nil {
+			return err
+		}
+		for i := range files {
+			files[i].ReleaseUID = release.UID
+		}
+		if len(files) > 0 {
+			if err := tx.CreateInBatches(files, 100).Error; err != nil {
+				return err
+			}
+		}
+		return switchAssetsRelease(tx, release, files)
+	})
+}
+
+// RollbackAssetsRelease 将 worker 的 Assets 整体切换回指定清单
+func RollbackAssetsRelease(release *AssetsRelease) error {
+	return database.GetDB().Transaction(func(tx *gorm.DB) error {
+		files := []AssetsReleaseFile{}
+		if err := tx.Where(&AssetsReleaseFile{ReleaseUID: release.UID}).Find(&files).Error; err != nil {
+			return err
+		}
+		return switchAssetsRelease(tx, release, files)
+	})
+}
+
+func switchAssetsRelease(tx *gorm.DB, release *AssetsRelease, files []AssetsReleaseFile) error {
+	if err := tx.Unscoped().Where(&Assets{WorkerUID: release.WorkerUID}).Delete(&Assets{}).Error; err != nil {
+		return err
+	}
+	assets := make([]Assets, 0, len(files))
+	for _, f := range files {
+		assets = append(assets, Assets{
+			UserID:    release.UserID,
+			UID:       f.UID,
+			WorkerUID: release.WorkerUID,
+			Name:      f.Name,
+			MIME:      f.MIME,
+			Hash:      f.Hash,
+			Path:      f.Path,
+		})
+	}
+	if len(assets) > 0 {
+		if err := tx.CreateInBatches(assets, 100).Error; err != nil {
+			return err
+		}
+	}
+	if err := tx.Model(&AssetsRelease{}).Where(&AssetsRelease{WorkerUID: release.WorkerUID}).
+		Update("active", false).Error; err != nil {
+		return err
+	}
+	release.Active = true
+	return tx.Model(release).Update("active", true).Error
+}
+
+// PruneAssetsReleases 只保留最近 keep 个清单，当前生效的清单不会被删除，返回被删除清单引用的文件 UID
+func PruneAssetsReleases(workerUID string, keep int) ([]string, error) {
+	if keep <= 0 {
+		return nil, nil
+	}
+	db := database.GetDB()
+	releases := []AssetsRelease{}
+	if err := db.Where(&AssetsRelease{WorkerUID: workerUID}).Order("id desc").Find(&releases).Error; err != nil {
+		return nil, err
+	}
+
+	fileUIDs := []string{}
+	for i, r := range releases {
+		if i < keep || r.Active {
+			continue
+		}
+		uids := []string{}
+		if err := db.Model(&AssetsReleaseFile{}).Where(&AssetsReleaseFile{ReleaseUID: r.UID}).Distinct().Pluck("uid", &uids).Error; err != nil {
+			return nil, err
+		}
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Unscoped().Where(&AssetsReleaseFile{ReleaseUID: r.UID}).Delete(&AssetsReleaseFile{}).Error; err != nil {
+				return err
+			}
+			return tx.Unscoped().Delete(&r).Error
+		}); err != nil {
+			return nil, err
+		}
+		fileUIDs = append(fileUIDs, uids...)
+	}
+	return fileUIDs, nil
+}
+
+// AssetsFileReferenced 文件是否仍被 Assets 或保留的发布清单引用
+func AssetsFileReferenced(db *gorm.DB, fileUID string) (bool, error) {
+	count := int64(0)
+	if err := db.Model(&Assets{}).Where(&Assets{UID: fileUID}).Count(&count).Error; err != nil {
+		return false, err
+	}
+	if count > 0 {
+		return true, nil
+	}
+	if err := db.Model(&AssetsReleaseFile{}).Where(&AssetsReleaseFile{UID: fileUID}).Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
@@ -1,16 +1,164 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"vvorker/conf"
+	"vvorker/ext/kv/src/sys_cache"
+	"vvorker/funcs"
+	"vvorker/utils/blobstore"
+	"vvorker/utils/database"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
 
 type File struct {
 	gorm.Model
-	UID       string `json:"uid"`
-	CreatedBy uint   `json:"created_by"` // userID
-	Hash      string `json:"hash"`
-	Mimetype  string `json:"mimetype"`
-	Data      []byte `json:"data"`
+	UID         string `json:"uid"`
+	CreatedBy   uint   `json:"created_by"` // userID
+	Hash        string `json:"hash"`
+	Mimetype    string `json:"mimetype"`
+	Data        []byte `json:"data"`                               // 迁移到 blob 存储之前的文件内容
+	Size        int64  `json:"size"`                               // 文件大小，迁移前的文件为 0
+	InBlobStore bool   `gorm:"default:false" json:"in_blob_store"` // 内容按 Hash 保存在 blob 存储中
 }
 
 func (f *File) TableName() string {
 	return "files"
 }
+
+// Open 读取文件内容，兼容保存在数据库或 OSS files/ 目录下的旧文件
+func (f *File) Open(ctx context.Context) (io.ReadCloser, error) {
+	if f.InBlobStore {
+		return blobstore.Open(ctx, f.Hash)
+	}
+	if len(f.Data) != 0 || !conf.AppConfigInstance.FileStorageUseOSS {
+		return io.NopCloser(bytes.NewReader(f.Data)), nil
+	}
+	return funcs.DownloadFileFromSysBucket(fmt.Sprintf("files/%d/%s", f.CreatedBy, f.Hash))
+}
+
+// OpenSeekable 读取可以随机访问的文件内容，小文件缓存在 sys_cache，大文件缓存在本地磁盘
+func (f *File) OpenSeekable(ctx context.Context) (blobstore.File, error) {
+	if f.InBlobStore {
+		return blobstore.OpenCached(ctx, f.Hash)
+	}
+
+	cacheKey := "file:" + f.UID + ":data"
+	if data, err := sys_cache.Get(cacheKey); err == nil && len(data) != 0 {
+		return blobstore.NewBytesFile(data), nil
+	}
+	rc, err := f.Open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) <= conf.AppConfigInstance.BlobCacheMaxSize {
+		sys_cache.Put(cacheKey, data, 3600)
+	}
+	return blobstore.NewBytesFile(data), nil
+}
+
+// NewBlobFile 将内容保存到 blob 存储并返回对应的文件记录，记录需要由调用方保存
+func NewBlobFile(ctx context.Context, createdBy uint, mimetype string, data []byte) (*File, error) {
+	hash, err := blobstore.PutBytes(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+	return &File{
+		CreatedBy:   createdBy,
+		Hash:        hash,
+		Mimetype:    mimetype,
+		Size:        int64(len(data)),
+		InBlobStore: true,
+	}, nil
+}
+
+// NewBlobFileFromReader 边读取边写入 blob 存储，不在内存中保存整个文件
+func NewBlobFileFromReader(ctx context.Context, createdBy uint, mimetype string, r io.Reader) (*File, error) {
+	hash, size, err := blobstore.Put(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	return &File{
+		CreatedBy:   createdBy,
+		Hash:        hash,
+		Mimetype:    mimetype,
+		Size:        size,
+		InBlobStore: true,
+	}, nil
+}
+
+// DeleteFileByUID 删除文件记录，没有其他文件引用相同内容时同时删除 blob
+func DeleteFileByUID(uid string) error {
+	db := database.GetDB()
+	files := []File{}
+	if err := db.Where(&File{UID: uid}).Find(&files).Error; err != nil {
+		return err
+	}
+	if err := db.Unscoped().Where(&File{UID: uid}).Delete(&File{}).Error; err != nil {
+		return err
+	}
+	for _, f := range files {
+		if !f.InBlobStore {
+			continue
+		}
+		count := int64(0)
+		if err := db.Model(&File{}).Where(&File{Hash: f.Hash, InBlobStore: true}).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			if err := blobstore.Delete(context.Background(), f.Hash); err != nil {
+				logrus.WithError(err).Warnf("delete blob %s error", f.Hash)
+			}
+		}
+	}
+	return nil
+}
+
+// MigrateFilesToBlobStore 将保存在数据库或 OSS files/ 目录下的旧文件迁移到 blob 存储
+// 迁移完成的文件清空 Data，旧的 OSS 对象保留不删除
+func MigrateFilesToBlobStore() {
+	db := database.GetDB()
+	ctx := context.Background()
+	migrated, failed := 0, 0
+
+	files := []File{}
+	db.Where("in_blob_store = ? OR in_blob_store IS NULL", false).FindInBatches(&files, 20, func(tx *gorm.DB, batch int) error {
+		for _, f := range files {
+			rc, err := f.Open(ctx)
+			if err != nil {
+				logrus.WithError(err).Errorf("open file %s error", f.UID)
+				failed++
+				continue
+			}
+			hash, size, err := blobstore.Put(ctx, rc)
+			rc.Close()
+			if err != nil {
+				logrus.WithError(err).Errorf("migrate file %s error", f.UID)
+				failed++
+				continue
+			}
+			if err := db.Model(&File{}).Where("id = ?", f.ID).Updates(map[string]interface{}{
+				"hash":          hash,
+				"size":          size,
+				"in_blob_store": true,
+				"data":          nil,
+			}).Error; err != nil {
+				logrus.WithError(err).Errorf("update file %s error", f.UID)
+				failed++
+				continue
+			}
+			migrated++
+		}
+		return nil
+	})
+	logrus.Infof("migrate files to blob storage done, migrated: %d, failed: %d", migrated, failed)
+}
@@ -16,5 +16,8 @@ func NodeWorkersInit() {
 		if err := worker.Flush(); err != nil {
 			logrus.WithError(err).Errorf("init failed to flush worker, worker is: [%+v]", worker.UID)
 		}
+		if err := worker.FlushCanary(); err != nil {
+			logrus.WithError(err).Errorf("init failed to flush worker canary, worker is: [%+v]", worker.UID)
+		}
 	}
 }
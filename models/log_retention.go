@@ -0,0 +1,97 @@
+package models
+
+import (
+	"time"
+	"vvorker/conf"
+	"vvorker/exec"
+	"vvorker/utils/database"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// 每次删除的最大条数，分批删除避免长时间锁表
+const logRetentionBatchSize = 5000
+
+// RunLogRetention 在主节点定期按保留策略清理 worker_logs 和 task_logs
+func RunLogRetention() {
+	if !conf.IsMaster() {
+		return
+	}
+	interval := time.Duration(conf.AppConfigInstance.LogRetentionInterval) * time.Minute
+	if interval <= 0 {
+		logrus.Info("log retention is disabled")
+		return
+	}
+	for {
+		time.Sleep(interval)
+		CleanupLogs()
+	}
+}
+
+// CleanupLogs 执行一次日志清理
+func CleanupLogs() {
+	db := database.GetDB()
+
+	if days := conf.AppConfigInstance.WorkerLogRetentionDays; days > 0 {
+		before := time.Now().AddDate(0, 0, -days)
+		n, err := deleteInBatches(db, &exec.WorkerLog{}, db.Model(&exec.WorkerLog{}).Where("time < ?", before))
+		if err != nil {
+			logrus.WithError(err).Error("failed to clean up expired worker logs")
+		} else if n > 0 {
+			logrus.Infof("cleaned up %d worker logs before %s", n, before.Format(time.RFC3339))
+		}
+	}
+
+	if limit := conf.AppConfigInstance.WorkerLogRetentionCount; limit > 0 {
+		var uids []string
+		if err := db.Model(&exec.WorkerLog{}).Group("uid").Having("COUNT(*) > ?", limit).Pluck("uid", &uids).Error; err != nil {
+			logrus.WithError(err).Error("failed to count worker logs")
+		}
+		for _, uid := range uids {
+			// 找到第 limit+1 新的日志，删除它及更早的日志
+			var cutoff exec.WorkerLog
+			if err := db.Where("uid = ?", uid).Order("id desc").Offset(limit).Limit(1).Find(&cutoff).Error; err != nil || cutoff.ID == 0 {
+				continue
+			}
+			n, err := deleteInBatches(db, &exec.WorkerLog{}, db.Model(&exec.WorkerLog{}).Where("uid = ? AND id <= ?", uid, cutoff.ID))
+			if err != nil {
+				logrus.WithError(err).Errorf("failed to clean up logs of worker %s", uid)
+			} else if n > 0 {
+				logrus.Infof("cleaned up %d logs of worker %s", n, uid)
+			}
+		}
+	}
+
+	if days := conf.AppConfigInstance.TaskLogRetentionDays; days > 0 {
+		before := time.Now().AddDate(0, 0, -days)
+		n, err := deleteInBatches(db, &TaskLog{}, db.Model(&TaskLog{}).Where("time < ?", before))
+		if err != nil {
+			logrus.WithError(err).Error("failed to clean up expired task logs")
+		} else if n > 0 {
+			logrus.Infof("cleaned up %d task logs before %s", n, before.Format(time.RFC3339))
+		}
+	}
+}
+
+// deleteInBatches 按 id 分批物理删除 query 匹配的记录，兼容 sqlite / mysql / pgsql
+func deleteInBatches(db *gorm.DB, model interface{}, query *gorm.DB) (int64, error) {
+	var total int64
+	for {
+		var ids []uint
+		if err := query.Session(&gorm.Session{}).Unscoped().Limit(logRetentionBatchSize).Pluck("id", &ids).Error; err != nil {
+			return total, err
+		}
+		if len(ids) == 0 {
+			return total, nil
+		}
+		tx := db.Unscoped().Where("id IN ?", ids).Delete(model)
+		if tx.Error != nil {
+			return total, tx.Error
+		}
+		total += tx.RowsAffected
+		if len(ids) < logRetentionBatchSize {
+			return total, nil
+		}
+	}
+}
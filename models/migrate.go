@@ -7,6 +7,7 @@ import (
 	"vvorker/models/secrets"
 	workercopy "vvorker/models/worker_copy"
 	"vvorker/utils"
+	"vvorker/utils/blobstore"
 	"vvorker/utils/database"
 
 	"github.com/sirupsen/logrus"
@@ -18,6 +19,7 @@ func MigrateNormalModel() {
 		&WorkerInformation{}, &exec.WorkerLog{}, &ResponseLog{}, &Assets{}, &Task{}, &TaskLog{},
 		&InternalServerWhiteList{}, &ExternalServerAKSK{}, &ExternalServerToken{}, &AccessRule{},
 		&PostgreSQLMigration{}, &MySQL{}, &MySQLMigration{}, &workercopy.WorkerCopy{}, &MigrationHistory{}, &secrets.Secret{},
+		&WorkerCanary{}, &AssetsRelease{}, &AssetsReleaseFile{}, &blobstore.Blob{},
 	}
 	if conf.AppConfigInstance.LitefsEnabled {
 		if !conf.IsMaster() {
@@ -42,4 +44,12 @@ func MigrateNormalModel() {
 	if err := MarkRunningTasksAsInterrupt(); err != nil {
 		logrus.WithError(err).Errorf("failed to mark running tasks as interrupt")
 	}
+
+	if conf.IsMaster() {
+		MigrateExternalServerTokens()
+	}
+
+	if conf.AppConfigInstance.BlobMigrateOnStart && conf.IsMaster() {
+		go MigrateFilesToBlobStore()
+	}
 }
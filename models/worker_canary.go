@@ -0,0 +1,288 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"vvorker/common"
+	"vvorker/conf"
+	"vvorker/defs"
+	"vvorker/entities"
+	"vvorker/exec"
+	"vvorker/ext/kv/src/sys_cache"
+	workercopy "vvorker/models/worker_copy"
+	"vvorker/rpc"
+	"vvorker/tunnel"
+	"vvorker/utils"
+	"vvorker/utils/database"
+	"vvorker/utils/generate"
+
+	"github.com/codeclysm/extract/v3"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/proto"
+	"gorm.io/gorm"
+)
+
+// WorkerCanary worker 的灰度发布配置，命中的请求转发到灰度版本的副本
+type WorkerCanary struct {
+	gorm.Model
+	WorkerUID   string `gorm:"uniqueIndex" json:"worker_uid"`
+	VersionID   string `json:"version_id"`
+	Percent     int    `json:"percent"`      // 按访问者分流的比例，0 ~ 100
+	Header      string `json:"header"`       // 请求头匹配时转发到灰度版本，为空时不按请求头分流
+	HeaderValue string `json:"header_value"` // 为空时只要带有该请求头即命中
+	Cookie      string `json:"cookie"`       // cookie 匹配时转发到灰度版本，为空时不按 cookie 分流
+	CookieValue string `json:"cookie_value"` // 为空时只要带有该 cookie 即命中
+	MaxCount    int32  `json:"max_count"`    // 灰度版本的副本数
+}
+
+func (w *WorkerCanary) TableName() string {
+	return "worker_canaries"
+}
+
+func workerCanaryCacheKey(workerUID string) string {
+	return "worker_canary:" + workerUID
+}
+
+func GetWorkerCanary(workerUID string) (*WorkerCanary, error) {
+	var canary WorkerCanary
+	db := database.GetDB()
+	if err := db.Where(&WorkerCanary{WorkerUID: workerUID}).First(&canary).Error; err != nil {
+		return nil, err
+	}
+	return &canary, nil
+}
+
+// GetWorkerCanaryCached 供代理在每个请求上使用，没有灰度配置时返回 nil
+func GetWorkerCanaryCached(workerUID string) (*WorkerCanary, error) {
+	bytes, err := sys_cache.GlobalCache(workerCanaryCacheKey(workerUID), func() ([]byte, error) {
+		canary, err := GetWorkerCanary(workerUID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			canary = &WorkerCanary{}
+		} else if err != nil {
+			return nil, err
+		}
+		return json.Marshal(canary)
+	}, 10)
+	if err != nil {
+		return nil, err
+	}
+	var canary WorkerCanary
+	if err := json.Unmarshal(bytes, &canary); err != nil {
+		return nil, err
+	}
+	if len(canary.VersionID) == 0 {
+		return nil, nil
+	}
+	return &canary, nil
+}
+
+// SaveWorkerCanary 创建或更新 worker 的灰度配置，立即对代理生效
+func SaveWorkerCanary(canary *WorkerCanary) error {
+	db := database.GetDB()
+	var old WorkerCanary
+	err := db.Where(&WorkerCanary{WorkerUID: canary.WorkerUID}).First(&old).Error
+	switch {
+	case err == nil:
+		canary.ID = old.ID
+		canary.CreatedAt = old.CreatedAt
+		err = db.Save(canary).Error
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		err = db.Create(canary).Error
+	}
+	sys_cache.DeleteGlobalCache(workerCanaryCacheKey(canary.WorkerUID))
+	return err
+}
+
+// DeleteWorkerCanary 删除灰度配置，请求立即全部回到当前版本
+func DeleteWorkerCanary(workerUID string) error {
+	db := database.GetDB()
+	err := db.Unscoped().Where(&WorkerCanary{WorkerUID: workerUID}).Delete(&WorkerCanary{}).Error
+	sys_cache.DeleteGlobalCache(workerCanaryCacheKey(workerUID))
+	return err
+}
+
+// WorkerCanarySpec 节点启动灰度副本所需的信息
+type WorkerCanarySpec struct {
+	VersionID string `json:"version_id"`
+	MaxCount  int32  `json:"max_count"`
+	Entry     string `json:"entry"`
+	Template  string `json:"template"`
+	FileID    string `json:"file_id"`
+	Code      []byte `json:"code"`
+}
+
+type AgentWorkerCanaryReq struct {
+	UID string `json:"uid"`
+}
+
+// LoadWorkerCanarySpec 从数据库读取灰度版本，没有灰度配置时返回 nil
+func LoadWorkerCanarySpec(workerUID string) (*WorkerCanarySpec, error) {
+	canary, err := GetWorkerCanary(workerUID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	version, err := GetWorkerVersion(workerUID, canary.VersionID)
+	if err != nil {
+		return nil, err
+	}
+	code, err := version.GetCode()
+	if err != nil {
+		return nil, err
+	}
+	return &WorkerCanarySpec{
+		VersionID: version.UID,
+		MaxCount:  canary.MaxCount,
+		Entry:     version.Entry,
+		Template:  version.Template,
+		FileID:    version.FileID,
+		Code:      code,
+	}, nil
+}
+
+// GetWorkerCanarySpec 节点从 master 获取灰度版本
+func GetWorkerCanarySpec(workerUID string) (*WorkerCanarySpec, error) {
+	if conf.IsMaster() {
+		return LoadWorkerCanarySpec(workerUID)
+	}
+	url := conf.AppConfigInstance.MasterEndpoint + "/api/agent/worker-canary"
+	rtype := struct {
+		Code int               `json:"code"`
+		Msg  string            `json:"msg"`
+		Data *WorkerCanarySpec `json:"data"`
+	}{}
+
+	reqResp, err := rpc.RPCWrapper().
+		SetBody(&AgentWorkerCanaryReq{UID: workerUID}).
+		SetSuccessResult(&rtype).
+		Post(url)
+
+	if err != nil || reqResp.StatusCode >= 299 || rtype.Code != common.RespCodeOK {
+		return nil, errors.New("get worker canary error")
+	}
+	return rtype.Data, nil
+}
+
+// FlushCanary 按 master 上的灰度配置重建本节点的灰度副本，没有灰度配置时只做清理
+func (w *Worker) FlushCanary() error {
+	if w.NodeName != conf.AppConfigInstance.NodeName {
+		n, err := GetNodeByNodeName(w.NodeName)
+		if err != nil {
+			return err
+		}
+		wp, err := proto.Marshal(w)
+		if err != nil {
+			return err
+		}
+		return rpc.EventNotify(n.Node, defs.EventFlushCanary, map[string][]byte{
+			defs.KeyWorkerProto: wp,
+		})
+	}
+
+	spec, err := GetWorkerCanarySpec(w.UID)
+	if err != nil {
+		return err
+	}
+	deleteCanaryCopies(w.UID)
+	if spec == nil {
+		return nil
+	}
+	logrus.Infof("flush worker %s canary, version: %s", w.Name, spec.VersionID)
+	return w.createCanaryCopies(spec)
+}
+
+func (w *Worker) createCanaryCopies(spec *WorkerCanarySpec) error {
+	c := context.Background()
+	db := database.GetDB()
+
+	if err := writeCanaryCode(w.UID, spec); err != nil {
+		return err
+	}
+
+	canaryWorker := proto.Clone(w.Worker).(*entities.Worker)
+	canaryWorker.Entry = spec.Entry
+	canaryWorker.Template = spec.Template
+	canaryWorker.ActiveVersionID = spec.VersionID
+	canaryWorker.Code = spec.Code
+
+	maxCount := int(spec.MaxCount)
+	if maxCount <= 0 {
+		maxCount = 1
+	}
+	copies := []*workercopy.WorkerCopy{}
+	for i := 0; i < maxCount; i++ {
+		w.LocalID = int32(defs.CanaryLocalIDBase + i)
+		logrus.Infof("create worker canary copy %v", w.LocalID)
+
+		// 灰度副本注册在单独的子域名下，由代理决定哪些请求转发过来
+		port := tunnel.GetPortManager().ClaimWorkerPort(c, w.GetWorkerClientID())
+		tunnel.GetClient().AddCanaryWorker(w.GetWorkerClientID(), utils.WorkerCanaryHostPrefix(w.GetName()),
+			w.GetName()+conf.AppConfigInstance.WorkerURLSuffix, int(port))
+
+		controlPort := tunnel.GetPortManager().ClaimWorkerPort(c, w.GetWorkerClientID()+"-control")
+		tunnel.GetClient().AddWorker(w.GetWorkerClientID()+"-control", w.GetUID()+"-canary-control", int(controlPort))
+
+		wCopy := &workercopy.WorkerCopy{
+			WorkerUID:   w.UID,
+			LocalID:     uint(w.LocalID),
+			Port:        uint(port),
+			ControlPort: uint(controlPort),
+			VersionID:   spec.VersionID,
+		}
+		if err := db.Create(wCopy).Error; err != nil {
+			logrus.WithError(err).Errorf("create worker canary copy error: %v", wCopy)
+			return err
+		}
+		if err := generate.GenWorkerCopyConfig(canaryWorker, wCopy, w); err != nil {
+			return err
+		}
+		copies = append(copies, wCopy)
+	}
+
+	for _, copy := range copies {
+		exec.ExecManager.RunWorker(copy)
+	}
+	return nil
+}
+
+func writeCanaryCode(workerUID string, spec *WorkerCanarySpec) error {
+	codeDir := filepath.Join(workercopy.CanaryDir(workerUID), defs.WorkerCodePath)
+	if len(spec.FileID) != 0 {
+		c := context.Background()
+		file, err := GetFileByVersionUID(c, spec.VersionID)
+		if err != nil {
+			return err
+		}
+		rc, err := file.Open(c)
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		return extract.Tar(c, rc, codeDir, nil)
+	}
+	return utils.WriteFile(filepath.Join(codeDir, spec.Entry), string(spec.Code))
+}
+
+// deleteCanaryCopies 停止并清理本节点上 worker 的全部灰度副本
+func deleteCanaryCopies(workerUID string) {
+	db := database.GetDB()
+	copies := []workercopy.WorkerCopy{}
+	db.Scopes(workercopy.Canary).Where(&workercopy.WorkerCopy{WorkerUID: workerUID}).Find(&copies)
+	for _, copy := range copies {
+		exec.ExecManager.ExitWorker(&copy)
+
+		w := &Worker{Worker: &entities.Worker{UID: workerUID, LocalID: int32(copy.LocalID)}}
+		tunnel.GetClient().Delete(w.GetWorkerClientID())
+		tunnel.GetClient().Delete(w.GetWorkerClientID() + "-control")
+	}
+	db.Model(&workercopy.WorkerCopy{}).Unscoped().Scopes(workercopy.Canary).Where(&workercopy.WorkerCopy{WorkerUID: workerUID}).Delete(&workercopy.WorkerCopy{})
+
+	if err := os.RemoveAll(workercopy.CanaryDir(workerUID)); err != nil {
+		logrus.WithError(err).Warnf("remove worker %s canary dir error", workerUID)
+	}
+}
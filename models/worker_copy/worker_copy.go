@@ -1,6 +1,13 @@
 package workercopy
 
-import "gorm.io/gorm"
+import (
+	"path/filepath"
+	"strconv"
+	"vvorker/conf"
+	"vvorker/defs"
+
+	"gorm.io/gorm"
+)
 
 type WorkerCopy struct {
 	gorm.Model
@@ -8,4 +15,42 @@ type WorkerCopy struct {
 	LocalID     uint
 	Port        uint
 	ControlPort uint
+	VersionID   string // 灰度副本运行的版本，为空时运行 worker 的当前版本
+}
+
+// IsCanary 灰度副本与当前版本的副本分开管理，更新 worker 时不会被停止
+func (c *WorkerCopy) IsCanary() bool {
+	return len(c.VersionID) != 0
+}
+
+// Dir 副本的工作目录，capfile 与代码都在此目录下
+func (c *WorkerCopy) Dir() string {
+	if c.IsCanary() {
+		return CanaryDir(c.WorkerUID)
+	}
+	return SlotDir(c.WorkerUID, c.LocalID)
+}
+
+// SlotDir 当前版本副本所在区间的工作目录，第一个区间沿用 worker 目录
+func SlotDir(workerUID string, localID uint) string {
+	dir := filepath.Join(conf.AppConfigInstance.WorkerdDir, defs.WorkerInfoPath, workerUID)
+	if slot := localID / defs.WorkerSlotSize; slot > 0 {
+		dir += defs.WorkerSlotDirSuffix + strconv.Itoa(int(slot))
+	}
+	return dir
+}
+
+// CanaryDir 灰度版本的工作目录，与 worker 目录同级，清理 worker 目录时不受影响
+func CanaryDir(workerUID string) string {
+	return filepath.Join(conf.AppConfigInstance.WorkerdDir, defs.WorkerInfoPath, workerUID+defs.CanaryDirSuffix)
+}
+
+// Primary 只查询运行当前版本的副本，兼容新增字段前的 NULL 值
+func Primary(db *gorm.DB) *gorm.DB {
+	return db.Where("version_id IS NULL OR version_id = ''")
+}
+
+// Canary 只查询灰度副本
+func Canary(db *gorm.DB) *gorm.DB {
+	return db.Where("version_id <> ''")
 }
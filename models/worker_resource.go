@@ -0,0 +1,258 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"vvorker/common"
+	"vvorker/conf"
+	"vvorker/entities"
+	"vvorker/ext/kv/src/sys_cache"
+	"vvorker/rpc"
+	"vvorker/utils/database"
+	"vvorker/utils/secret"
+
+	"gorm.io/gorm"
+)
+
+const (
+	ResourceTypeKV    = "kv"
+	ResourceTypePgSQL = "pgsql"
+	ResourceTypeMySQL = "mysql"
+	ResourceTypeOSS   = "oss"
+	// assets 与 task 没有资源 ID，凭证只限定 worker 与绑定
+	ResourceTypeAssets = "assets"
+	ResourceTypeTask   = "task"
+)
+
+var ErrResourceNotAllowed = errors.New("resource not allowed")
+
+// WorkerResource 资源凭证对应的 worker 配置，资源的账号密码已经按 master 上的记录补全
+// Type 为空表示没有权限
+type WorkerResource struct {
+	Type       string            `json:"type"`
+	Binding    string            `json:"binding"`
+	WorkerUID  string            `json:"worker_uid"`
+	ResourceID string            `json:"resource_id"`
+	SQL        *conf.SQLDBConfig `json:"sql,omitempty"`
+	OSS        *conf.OSSConfig   `json:"oss,omitempty"`
+}
+
+type AgentWorkerResourceReq struct {
+	Token string `json:"token"`
+}
+
+func bindingOrDefault(binding string, resourceType string) string {
+	if len(binding) == 0 {
+		return resourceType
+	}
+	return binding
+}
+
+// LoadWorkerResource 在 master 上按 worker 版本的配置查找凭证对应的资源，资源必须属于 worker 的拥有者
+func LoadWorkerResource(scope *secret.ResourceScope) (*WorkerResource, error) {
+	db := database.GetDB()
+	worker := &Worker{}
+	if err := db.Where(&Worker{Worker: &entities.Worker{UID: scope.WorkerUID}}).First(worker).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrResourceNotAllowed
+		}
+		return nil, err
+	}
+	template := worker.Template
+	if len(scope.VersionID) != 0 && scope.VersionID != worker.ActiveVersionID {
+		version, err := GetWorkerVersion(worker.UID, scope.VersionID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrResourceNotAllowed
+		}
+		if err != nil {
+			return nil, err
+		}
+		template = version.Template
+	}
+	workerconfig, err := conf.ParseWorkerConfig(template)
+	if err != nil {
+		return nil, ErrResourceNotAllowed
+	}
+
+	userID := uint64(worker.UserID)
+	res := &WorkerResource{Type: scope.Type, Binding: scope.Binding, WorkerUID: worker.UID}
+	switch scope.Type {
+	case ResourceTypeKV:
+		for _, ext := range workerconfig.KV {
+			if bindingOrDefault(ext.Binding, scope.Type) != scope.Binding || len(ext.ResourceID) == 0 {
+				continue
+			}
+			var kv KV
+			if err := db.Where(&KV{UID: ext.ResourceID, UserID: userID}).First(&kv).Error; err != nil {
+				break
+			}
+			res.ResourceID = ext.ResourceID
+			return res, nil
+		}
+	case ResourceTypePgSQL, ResourceTypeMySQL:
+		exts := workerconfig.PgSql
+		if scope.Type == ResourceTypeMySQL {
+			exts = workerconfig.Mysql
+		}
+		for _, ext := range exts {
+			if bindingOrDefault(ext.Binding, scope.Type) != scope.Binding {
+				continue
+			}
+			if len(ext.ResourceID) != 0 {
+				if !fillSQLResource(&ext, scope.Type, userID) {
+					break
+				}
+				res.ResourceID = ext.ResourceID
+			}
+			res.SQL = &ext
+			return res, nil
+		}
+	case ResourceTypeOSS:
+		for _, ext := range workerconfig.OSS {
+			if bindingOrDefault(ext.Binding, scope.Type) != scope.Binding {
+				continue
+			}
+			if len(ext.ResourceID) != 0 {
+				var oss OSS
+				if err := db.Where(&OSS{UID: ext.ResourceID, UserID: userID}).First(&oss).Error; err != nil {
+					break
+				}
+				ext.Bucket = oss.Bucket
+				if !conf.AppConfigInstance.MinioSingleBucketMode {
+					ext.Region = oss.Region
+					ext.AccessKeyId = oss.AccessKey
+					ext.AccessKeySecret = oss.SecretKey
+				} else {
+					ext.Region = conf.AppConfigInstance.ServerMinioRegion
+					ext.AccessKeyId = conf.AppConfigInstance.ServerMinioAccess
+					ext.AccessKeySecret = conf.AppConfigInstance.ServerMinioSecret
+				}
+				res.ResourceID = ext.ResourceID
+			}
+			res.OSS = &ext
+			return res, nil
+		}
+	case ResourceTypeAssets:
+		for _, ext := range workerconfig.Assets {
+			if bindingOrDefault(ext.Binding, scope.Type) == scope.Binding {
+				return res, nil
+			}
+		}
+	case ResourceTypeTask:
+		for _, ext := range workerconfig.Task {
+			if bindingOrDefault(ext.Binding, scope.Type) == scope.Binding {
+				return res, nil
+			}
+		}
+	}
+	return nil, ErrResourceNotAllowed
+}
+
+// fillSQLResource 与生成绑定时一致，使用 master 上记录的数据库账号
+func fillSQLResource(ext *conf.SQLDBConfig, resourceType string, userID uint64) bool {
+	db := database.GetDB()
+	if resourceType == ResourceTypePgSQL {
+		var pg PostgreSQL
+		if err := db.Where(&PostgreSQL{UID: ext.ResourceID, UserID: userID}).First(&pg).Error; err != nil {
+			return false
+		}
+		ext.Database = pg.Database
+		ext.User = pg.Username
+		ext.Password = pg.Password
+		return true
+	}
+	var mysql MySQL
+	if err := db.Where(&MySQL{UID: ext.ResourceID, UserID: userID}).First(&mysql).Error; err != nil {
+		return false
+	}
+	if conf.AppConfigInstance.ServerMySQLOneDBName != "" {
+		ext.Database = conf.AppConfigInstance.ServerMySQLOneDBName
+		ext.User = conf.AppConfigInstance.ServerMySQLUser
+		ext.Password = conf.AppConfigInstance.ServerMySQLPassword
+	} else {
+		ext.Database = mysql.Database
+		ext.User = mysql.Username
+		ext.Password = mysql.Password
+	}
+	return true
+}
+
+func workerResourceCacheKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "worker_resource:" + hex.EncodeToString(sum[:])
+}
+
+// workerResourceFallbackTTL master 不可用时节点沿用上次授权结果的最长时间（秒）
+const workerResourceFallbackTTL = 5 * 60
+
+// GetWorkerResource 校验资源凭证并返回对应的资源，结果缓存 10 秒，修改配置或删除资源后很快失效
+// 节点从 master 获取，没有权限时返回 ErrResourceNotAllowed；
+// master 不可用时沿用最近 5 分钟内的授权结果，master 明确拒绝后不再沿用
+func GetWorkerResource(token string) (*WorkerResource, error) {
+	scope, err := secret.ParseResourceToken(conf.AppConfigInstance.AgentSecret, token)
+	if err != nil {
+		return nil, ErrResourceNotAllowed
+	}
+	cacheKey := workerResourceCacheKey(token)
+	fallbackKey := cacheKey + ":last"
+	bytes, err := sys_cache.GlobalCache(cacheKey, func() ([]byte, error) {
+		res, err := fetchWorkerResource(scope, token)
+		if errors.Is(err, ErrResourceNotAllowed) {
+			if !conf.IsMaster() {
+				sys_cache.Del(fallbackKey)
+			}
+			return json.Marshal(&WorkerResource{})
+		}
+		if err != nil {
+			return nil, err
+		}
+		bytes, err := json.Marshal(res)
+		if err == nil && !conf.IsMaster() {
+			sys_cache.Put(fallbackKey, bytes, workerResourceFallbackTTL)
+		}
+		return bytes, err
+	}, 10)
+	if err != nil && !conf.IsMaster() {
+		if last, lerr := sys_cache.Get(fallbackKey); lerr == nil && len(last) != 0 {
+			bytes, err = last, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	var res WorkerResource
+	if err := json.Unmarshal(bytes, &res); err != nil {
+		return nil, err
+	}
+	if len(res.Type) == 0 {
+		return nil, ErrResourceNotAllowed
+	}
+	return &res, nil
+}
+
+func fetchWorkerResource(scope *secret.ResourceScope, token string) (*WorkerResource, error) {
+	if conf.IsMaster() {
+		return LoadWorkerResource(scope)
+	}
+	url := conf.AppConfigInstance.MasterEndpoint + "/api/agent/worker-resource"
+	rtype := struct {
+		Code int             `json:"code"`
+		Msg  string          `json:"msg"`
+		Data *WorkerResource `json:"data"`
+	}{}
+
+	reqResp, err := rpc.RPCWrapper().
+		SetBody(&AgentWorkerResourceReq{Token: token}).
+		SetSuccessResult(&rtype).
+		Post(url)
+
+	if err != nil || reqResp.StatusCode >= 299 || rtype.Code != common.RespCodeOK {
+		return nil, errors.New("get worker resource error")
+	}
+	if rtype.Data == nil {
+		return nil, ErrResourceNotAllowed
+	}
+	return rtype.Data, nil
+}
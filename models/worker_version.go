@@ -1,24 +1,97 @@
 package models
 
 import (
+	"bytes"
 	"context"
+	"fmt"
+	"io"
+	"vvorker/conf"
+	"vvorker/entities"
+	"vvorker/funcs"
+	"vvorker/utils"
 	"vvorker/utils/database"
 
 	"gorm.io/gorm"
 )
 
+// WorkerVersion 是 worker 某次更新的不可变快照
 type WorkerVersion struct {
 	gorm.Model
-	UID      string
-	WorkerID string
+	UID      string `gorm:"index"`
+	WorkerID string `gorm:"index"`
 	Name     string
-	FileID   string
+	FileID   string // 以 tar 包发布的版本，不为空时从文件解压代码
+
+	UserID      uint64
+	Entry       string
+	Template    string
+	SemVersion  string
+	Description string
+	Code        []byte // 使用 oss 存储文件时为空，代码保存在 code/versions/<UID>
 }
 
 func (w *WorkerVersion) TableName() string {
 	return "worker_versions"
 }
 
+func workerVersionCodePath(versionUID string) string {
+	return fmt.Sprintf("code/versions/%s", versionUID)
+}
+
+// CreateWorkerVersion 为 worker 当前的代码、模板和入口创建快照，需要与 worker 的保存放在同一个事务 tx 中
+func CreateWorkerVersion(tx *gorm.DB, worker *entities.Worker, code []byte, desc string) (*WorkerVersion, error) {
+	version := &WorkerVersion{
+		UID:         utils.GenerateUID(),
+		WorkerID:    worker.GetUID(),
+		Name:        utils.NewCodeName(0),
+		UserID:      worker.GetUserID(),
+		Entry:       worker.GetEntry(),
+		Template:    worker.GetTemplate(),
+		SemVersion:  worker.GetSemVersion(),
+		Description: desc,
+		Code:        code,
+	}
+	if conf.AppConfigInstance.FileStorageUseOSS {
+		if err := funcs.UploadFileToSysBucket(workerVersionCodePath(version.UID), bytes.NewReader(code)); err != nil {
+			return nil, err
+		}
+		version.Code = nil
+	}
+
+	if err := tx.Create(version).Error; err != nil {
+		return nil, err
+	}
+	return version, nil
+}
+
+// GetCode 读取快照中的代码
+func (w *WorkerVersion) GetCode() ([]byte, error) {
+	if !conf.AppConfigInstance.FileStorageUseOSS || len(w.Code) > 0 {
+		return w.Code, nil
+	}
+	code, err := funcs.DownloadFileFromSysBucket(workerVersionCodePath(w.UID))
+	if err != nil {
+		return nil, err
+	}
+	defer code.Close()
+	return io.ReadAll(code)
+}
+
+func GetWorkerVersion(workerUID string, versionUID string) (*WorkerVersion, error) {
+	var version WorkerVersion
+	db := database.GetDB()
+	if err := db.Where(&WorkerVersion{UID: versionUID, WorkerID: workerUID}).First(&version).Error; err != nil {
+		return nil, err
+	}
+	return &version, nil
+}
+
+// DeleteWorkerVersions 删除 worker 的所有版本，仅在删除 worker 时调用
+func DeleteWorkerVersions(workerUID string) error {
+	db := database.GetDB()
+	return db.Unscoped().Where(&WorkerVersion{WorkerID: workerUID}).Delete(&WorkerVersion{}).Error
+}
+
 func GetFileByVersionUID(c context.Context, versionID string) (*File, error) {
 	var version WorkerVersion
 	var file File
@@ -1,7 +1,6 @@
 package models
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -10,6 +9,7 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"sync"
 
 	"time"
 	"vvorker/conf"
@@ -338,14 +338,24 @@ func (w *Worker) GetWorkerClientID() string {
 	return w.UID + "-worker-" + strconv.Itoa(int(w.LocalID))
 }
 
+// ErrInvalidMaxCount 副本数超过一个区间时新旧副本的 LocalID 会重叠
+var ErrInvalidMaxCount = fmt.Errorf("max count should be between 1 and %d", defs.WorkerSlotSize)
+
 func (w *Worker) Create() error {
+	return w.CreateTx(database.GetDB())
+}
+
+// CreateTx 与 Create 相同，worker 与副本的记录写入给定的事务
+func (w *Worker) CreateTx(db *gorm.DB) error {
 	c := context.Background()
 	if w.MaxCount == 0 {
 		w.MaxCount = 1
 	}
-	db := database.GetDB()
+	if w.MaxCount < 0 || w.MaxCount > defs.WorkerSlotSize {
+		return ErrInvalidMaxCount
+	}
 	if w.NodeName == conf.AppConfigInstance.NodeName {
-		db.Model(&workercopy.WorkerCopy{}).Unscoped().Where(&workercopy.WorkerCopy{WorkerUID: w.UID}).Delete(&workercopy.WorkerCopy{})
+		db.Model(&workercopy.WorkerCopy{}).Unscoped().Scopes(workercopy.Primary).Where(&workercopy.WorkerCopy{WorkerUID: w.UID}).Delete(&workercopy.WorkerCopy{})
 
 		for i := 0; i < int(w.MaxCount); i++ {
 			w.LocalID = int32(i)
@@ -395,72 +405,173 @@ func (w *Worker) Create() error {
 	return db.Create(w).Error
 }
 
+// Update 保存 worker 并以蓝绿方式替换本节点上的副本
 func (w *Worker) Update() error {
-	c := context.Background()
-	// if w.ID == 0 {
-	// 	return errors.New("worker has no id")
-	// }
 	db := database.GetDB()
 	if w.MaxCount == 0 {
 		w.MaxCount = 1
 	}
-	if w.NodeName == conf.AppConfigInstance.NodeName {
-		workercopies := &[]workercopy.WorkerCopy{}
-		db.Model(&workercopy.WorkerCopy{}).Where(&workercopy.WorkerCopy{WorkerUID: w.UID}).Find(workercopies)
-		for i := range *workercopies {
-			w.LocalID = int32(i)
-			tunnel.GetClient().Delete(w.GetWorkerClientID())
-			tunnel.GetClient().Delete(w.GetWorkerClientID() + "-control")
+	if w.MaxCount < 0 || w.MaxCount > defs.WorkerSlotSize {
+		return ErrInvalidMaxCount
+	}
+	// litefs 从节点只读，worker 已由主节点保存
+	if conf.IsMaster() || !conf.AppConfigInstance.LitefsEnabled {
+		// 通过事件下发的 worker 不带数据库 ID，按 UID 找回已有记录，避免重复插入
+		if w.ID == 0 {
+			var old Worker
+			if err := db.Where(&Worker{Worker: &entities.Worker{UID: w.UID}}).First(&old).Error; err == nil {
+				w.Model = old.Model
+			}
+		}
+		if err := db.Save(w).Error; err != nil {
+			return err
 		}
-		db.Model(&workercopy.WorkerCopy{}).Unscoped().Where(&workercopy.WorkerCopy{WorkerUID: w.UID}).Delete(&workercopy.WorkerCopy{})
+	}
 
-		for i := 0; i < int(w.MaxCount); i++ {
-			w.LocalID = int32(i)
-			logrus.Infof("update worker copy %v", i)
-			port := tunnel.GetPortManager().ClaimWorkerPort(c, w.GetWorkerClientID())
-			w.Port = port
-			tunnel.GetClient().AddWorker(w.GetWorkerClientID(), utils.WorkerHostPrefix(w.GetName()), int(port))
+	if w.NodeName == conf.AppConfigInstance.NodeName {
+		return w.deploy()
+	}
+	return nil
+}
 
-			controlPort := tunnel.GetPortManager().ClaimWorkerPort(c, w.GetWorkerClientID()+"-control")
-			w.ControlPort = controlPort
+// deploy 先在新端口上启动新副本并通过控制端口做健康检查，
+// 全部就绪后才把 frp 代理切换到新副本，旧副本等待已有请求处理完后下线。
+// 新代码写入新区间的目录，新副本启动失败时保留旧副本和旧代码继续提供服务
+func (w *Worker) deploy() error {
+	c := context.Background()
+	db := database.GetDB()
 
-			tunnel.GetClient().AddWorker(w.GetWorkerClientID()+"-control", w.GetUID()+"-control", int(controlPort))
+	// 同一个 worker 的部署串行执行，直到上一次部署的旧副本下线
+	lock, _ := deployLocks.LoadOrStore(w.UID, &sync.Mutex{})
+	lock.Lock()
+	unlock := lock.Unlock
+
+	oldCopies := []workercopy.WorkerCopy{}
+	db.Scopes(workercopy.Primary).Where(&workercopy.WorkerCopy{WorkerUID: w.UID}).Find(&oldCopies)
+	serving := lo.SomeBy(oldCopies, func(copy workercopy.WorkerCopy) bool {
+		return exec.ExecManager.IsRunning(&copy)
+	})
+
+	base, oldBase := 0, defs.WorkerSlotSize
+	if lo.SomeBy(oldCopies, func(copy workercopy.WorkerCopy) bool { return copy.LocalID < defs.WorkerSlotSize }) {
+		base, oldBase = defs.WorkerSlotSize, 0
+	}
+
+	newCopies := []*workercopy.WorkerCopy{}
+	newDir := workercopy.SlotDir(w.UID, uint(base))
+	// abort 撤销新副本与新区间的代码，旧副本不受影响
+	abort := func(err error) error {
+		removeWorkerCopies(newCopies)
+		os.RemoveAll(newDir)
+		unlock()
+		return err
+	}
 
-			wCopy := &workercopy.WorkerCopy{
-				WorkerUID:   w.UID,
-				LocalID:     uint(i),
-				Port:        uint(port),
-				ControlPort: uint(controlPort),
-			}
-			if err := db.Create(wCopy).Error; err != nil {
-				logrus.WithError(err).Errorf("create worker copy error: %v", wCopy)
-				return err
-			}
+	// 新区间的目录只会留有上次部署失败或已下线副本的文件，清空后再写入
+	if err := os.RemoveAll(newDir); err != nil {
+		unlock()
+		return err
+	}
+	if err := w.writeFile(newDir); err != nil {
+		return abort(err)
+	}
+
+	for i := 0; i < int(w.MaxCount); i++ {
+		w.LocalID = int32(base + i)
+		logrus.Infof("update worker copy %v", w.LocalID)
+		port := tunnel.GetPortManager().ClaimWorkerPort(c, w.GetWorkerClientID())
+		w.Port = port
+		controlPort := tunnel.GetPortManager().ClaimWorkerPort(c, w.GetWorkerClientID()+"-control")
+		w.ControlPort = controlPort
+
+		wCopy := &workercopy.WorkerCopy{
+			WorkerUID:   w.UID,
+			LocalID:     uint(w.LocalID),
+			Port:        uint(port),
+			ControlPort: uint(controlPort),
 		}
+		if err := db.Create(wCopy).Error; err != nil {
+			logrus.WithError(err).Errorf("create worker copy error: %v", wCopy)
+			return abort(err)
+		}
+		newCopies = append(newCopies, wCopy)
+	}
 
-		if err := w.UpdateFile(); err != nil {
-			return err
+	for _, copy := range newCopies {
+		if err := generate.GenWorkerCopyConfig(w.Worker, copy, w); err != nil {
+			return abort(err)
 		}
+	}
+	for _, copy := range newCopies {
+		exec.ExecManager.RunWorker(copy)
+	}
 
+	// 没有旧副本在提供服务时（如节点刚启动）无需等待，直接切换
+	if serving {
+		ctx, cancel := context.WithTimeout(c, time.Duration(conf.AppConfigInstance.WorkerHealthCheckTimeout)*time.Second)
+		defer cancel()
+		for _, copy := range newCopies {
+			if err := exec.ExecManager.WaitHealthy(ctx, copy); err != nil {
+				logrus.WithError(err).Errorf("worker %s new copies are unhealthy, keep old copies", w.Name)
+				return abort(err)
+			}
+		}
 	}
-	if !conf.IsMaster() && conf.AppConfigInstance.LitefsEnabled {
-		return nil
+
+	// 先加入新副本的代理，再移除旧副本的代理，同一负载均衡组内始终有可用的副本
+	for _, copy := range newCopies {
+		w.LocalID = int32(copy.LocalID)
+		tunnel.GetClient().AddWorker(w.GetWorkerClientID(), utils.WorkerHostPrefix(w.GetName()), int(copy.Port))
+		tunnel.GetClient().AddWorker(w.GetWorkerClientID()+"-control", w.GetUID()+"-control", int(copy.ControlPort))
+	}
+	for _, copy := range oldCopies {
+		w.LocalID = int32(copy.LocalID)
+		tunnel.GetClient().Delete(w.GetWorkerClientID())
+		tunnel.GetClient().Delete(w.GetWorkerClientID() + "-control")
+	}
+	if err := exec.ExecManager.ScheduleWorker(w.UID); err != nil {
+		logrus.WithError(err).Warnf("schedule worker %s error", w.Name)
 	}
 
-	return db.Save(w).Error
+	drain := time.Duration(conf.AppConfigInstance.WorkerDrainTimeout) * time.Second
+	if !serving {
+		drain = 0
+	}
+	go func() {
+		defer unlock()
+		time.Sleep(drain)
+		removeWorkerCopies(lo.ToSlicePtr(oldCopies))
+		if err := os.RemoveAll(workercopy.SlotDir(w.UID, uint(oldBase))); err != nil {
+			logrus.WithError(err).Warnf("remove worker %s old copies dir error", w.Name)
+		}
+		logrus.Infof("worker %s old copies drained", w.Name)
+	}()
+	return nil
+}
+
+// 每个 worker 的部署锁
+var deployLocks = &utils.SyncMap[string, *sync.Mutex]{}
+
+// removeWorkerCopies 停止副本进程并删除副本记录，副本的 frp 代理需要调用方自行处理
+func removeWorkerCopies(copies []*workercopy.WorkerCopy) {
+	db := database.GetDB()
+	for _, copy := range copies {
+		exec.ExecManager.ExitWorker(copy)
+		db.Unscoped().Delete(copy)
+	}
 }
 
 func (w *Worker) Delete() error {
 	if w.NodeName == conf.AppConfigInstance.NodeName {
 		db := database.GetDB()
 		workercopies := &[]workercopy.WorkerCopy{}
-		db.Model(&workercopy.WorkerCopy{}).Where(&workercopy.WorkerCopy{WorkerUID: w.UID}).Find(workercopies)
-		for i := range *workercopies {
-			w.LocalID = int32(i)
+		db.Model(&workercopy.WorkerCopy{}).Scopes(workercopy.Primary).Where(&workercopy.WorkerCopy{WorkerUID: w.UID}).Find(workercopies)
+		for _, copy := range *workercopies {
+			w.LocalID = int32(copy.LocalID)
 			tunnel.GetClient().Delete(w.GetWorkerClientID())
 			tunnel.GetClient().Delete(w.GetWorkerClientID() + "-control")
 		}
-		db.Model(&workercopy.WorkerCopy{}).Unscoped().Where(&workercopy.WorkerCopy{WorkerUID: w.UID}).Delete(&workercopy.WorkerCopy{})
+		db.Model(&workercopy.WorkerCopy{}).Unscoped().Scopes(workercopy.Primary).Where(&workercopy.WorkerCopy{WorkerUID: w.UID}).Delete(&workercopy.WorkerCopy{})
 		db.Model(&WorkerMember{}).Unscoped().Where(&WorkerMember{WorkerUID: w.UID}).Delete(&WorkerMember{})
 		db.Model(&WorkerInformation{}).Unscoped().Where(&WorkerInformation{WorkerInformationBase: &WorkerInformationBase{UID: w.UID}}).Delete(&WorkerInformation{})
 	} else {
@@ -510,23 +621,8 @@ func (w *Worker) Flush() error {
 		w.TunnelID = uuid.New().String()
 	}
 
-	exec.ExecManager.ExitCmd(w.UID)
-
-	if err := w.DeleteFile(); err != nil {
-		return err
-	}
 	logrus.Infof("flush worker %s", w.Name)
-	if err := w.Update(); err != nil {
-		return err
-	}
-
-	if err := generate.GenWorkerConfig(w.ToEntity(), w); err != nil {
-		return err
-	}
-
-	exec.ExecManager.RunCmd(w.UID)
-
-	return nil
+	return w.Update()
 }
 
 func (w *Worker) ToEntity() *entities.Worker {
@@ -536,18 +632,44 @@ func (w *Worker) ToEntity() *entities.Worker {
 	return ans
 }
 
+// DeleteFile 删除两个区间的工作目录
 func (w *Worker) DeleteFile() error {
-	return os.RemoveAll(
-		filepath.Join(
-			conf.AppConfigInstance.WorkerdDir,
-			defs.WorkerInfoPath,
-			w.UID,
-		),
-	)
+	for _, base := range []uint{0, defs.WorkerSlotSize} {
+		if err := os.RemoveAll(workercopy.SlotDir(w.UID, base)); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
+// UpdateFile 将代码写入第一个区间的目录，新建 worker 的副本从第一个区间开始
 func (w *Worker) UpdateFile() error {
-	if conf.AppConfigInstance.FileStorageUseOSS && len(w.ActiveVersionID) == 0 {
+	return w.writeFile(workercopy.SlotDir(w.UID, 0))
+}
+
+func (w *Worker) writeFile(dir string) error {
+	// 以 tar 包发布的版本直接解压，其余版本的代码已经随 worker 一起保存
+	if len(w.ActiveVersionID) != 0 {
+		var version WorkerVersion
+		db := database.GetDB()
+		if err := db.Where(&WorkerVersion{UID: w.ActiveVersionID}).First(&version).Error; err == nil && len(version.FileID) != 0 {
+			c := context.Background()
+
+			file, err := GetFileByVersionUID(c, w.ActiveVersionID)
+			if err != nil {
+				return err
+			}
+			rc, err := file.Open(c)
+			if err != nil {
+				return err
+			}
+			defer rc.Close()
+
+			return extract.Tar(c, rc, filepath.Join(dir, defs.WorkerCodePath), nil)
+		}
+	}
+
+	if conf.AppConfigInstance.FileStorageUseOSS {
 		code, err := funcs.DownloadFileFromSysBucket(fmt.Sprintf("code/%s", w.GetUID()))
 		if err != nil {
 			return err
@@ -558,27 +680,12 @@ func (w *Worker) UpdateFile() error {
 		}
 		w.Code = codeBytes
 	}
-	if len(w.ActiveVersionID) == 0 {
-		return utils.WriteFile(
-			filepath.Join(
-				conf.AppConfigInstance.WorkerdDir,
-				defs.WorkerInfoPath,
-				w.UID,
-				defs.WorkerCodePath,
-				w.Entry),
-			string(w.Code))
-	}
-
-	c := context.Background()
-
-	file, err := GetFileByVersionUID(c, w.ActiveVersionID)
-	if err != nil {
-		return err
-	}
-
-	return extract.Tar(c, bytes.NewReader(file.Data), filepath.Join(
-		conf.AppConfigInstance.WorkerdDir, defs.WorkerInfoPath,
-		w.UID, defs.WorkerCodePath), nil)
+	return utils.WriteFile(
+		filepath.Join(
+			dir,
+			defs.WorkerCodePath,
+			w.Entry),
+		string(w.Code))
 }
 
 func (w *Worker) Run() ([]byte, error) {
@@ -636,6 +743,7 @@ func DiffWorkers(newWorkerList []entities.WorkerUIDVersion) ([]entities.WorkerUI
 			if ww.Error != nil {
 				continue
 			}
+			deleteCanaryCopies(worker.UID)
 			if err := ow.Delete(); err != nil {
 				logrus.WithError(err).Errorf("sync workers delete worker error, worker is: %+v", worker)
 				continue
@@ -3,6 +3,7 @@ package rpc
 import (
 	"errors"
 	"fmt"
+	"vvorker/common"
 	"vvorker/conf"
 	"vvorker/defs"
 	"vvorker/entities"
@@ -13,17 +14,23 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// EventNotify 同步等待节点处理事件，节点返回错误码（如部署失败）时返回错误
 func EventNotify(n *entities.Node, eventName string, extra map[string][]byte) error {
 	logrus.Infof("event notify, eventName: %s, requestExtraKeys: %+v", eventName, lo.Keys(extra))
+	rtype := struct {
+		Code int    `json:"code"`
+		Msg  string `json:"msg"`
+	}{}
 	reqResp, err := RPCWrapper().
 		SetHeader(defs.HeaderHost, utils.NodeHost(n.Name, n.UID)).
 		SetBody(&entities.NotifyEventRequest{EventName: eventName, Extra: extra}).
+		SetSuccessResult(&rtype).
 		Post(
 			fmt.Sprintf("http://%s:%d/api/agent/notify",
 				conf.AppConfigInstance.TunnelHost,
 				conf.AppConfigInstance.TunnelEntryPort))
 
-	if err != nil || reqResp.StatusCode >= 299 {
+	if err != nil || reqResp.StatusCode >= 299 || rtype.Code != common.RespCodeOK {
 		logrus.Errorf("event notify error, err: %+v, resp: %+v, eventName: %s, requestExtraKeys: %+v", err, reqResp, eventName, lo.Keys(extra))
 		return errors.New("error")
 	}
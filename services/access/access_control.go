@@ -1,10 +1,12 @@
 package access
 
 import (
+	"fmt"
 	"vvorker/common"
 	"vvorker/entities"
 	"vvorker/ext/kv/src/sys_cache"
 	"vvorker/models"
+	"vvorker/services/proxy"
 	"vvorker/utils"
 	"vvorker/utils/database"
 	permissions "vvorker/utils/permissions"
@@ -94,6 +96,10 @@ func AddAccessRuleEndpoint(c *gin.Context) {
 	if err := c.BindJSON(&request); err != nil {
 		return
 	}
+	if err := validateAccessRule(&request); err != nil {
+		common.RespErr(c, common.RespCodeInvalidRequest, err.Error(), nil)
+		return
+	}
 	request.Length = len(request.Path)
 	request.RuleUID = utils.GenerateUID()
 
@@ -121,6 +127,10 @@ func UpdateAccessRuleEndpoint(c *gin.Context) {
 	if err := c.BindJSON(&request); err != nil {
 		return
 	}
+	if err := validateAccessRule(&request); err != nil {
+		common.RespErr(c, common.RespCodeInvalidRequest, err.Error(), nil)
+		return
+	}
 	request.Length = len(request.Path)
 
 	// 检查用户是否有写权限（拥有者或协作者）
@@ -257,3 +267,18 @@ func SwitchAccessRuleEndpoint(c *gin.Context) {
 
 	common.RespOK(c, common.RespMsgOK, nil)
 }
+
+// validateAccessRule 检查规则的 Data，避免保存之后才在代理中出错
+func validateAccessRule(rule *models.AccessRule) error {
+	switch rule.RuleType {
+	case "ip":
+		if _, err := models.ParseIPAccessRule(rule.Data); err != nil {
+			return fmt.Errorf("invalid ip rule: %w", err)
+		}
+	case "jwt", "oidc":
+		if err := proxy.ValidateJWTRule(rule.RuleType, rule.Data); err != nil {
+			return fmt.Errorf("invalid %s rule: %w", rule.RuleType, err)
+		}
+	}
+	return nil
+}
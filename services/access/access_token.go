@@ -1,9 +1,9 @@
 package access
 
 import (
+	"time"
 	"vvorker/common"
 	"vvorker/models"
-	"vvorker/utils"
 	"vvorker/utils/database"
 	"vvorker/utils/permissions"
 
@@ -34,20 +34,26 @@ func CreateAccessTokenEndpoint(c *gin.Context) {
 		return
 	}
 
+	// 没有给出过期时间的 token 不过期
+	var expiresAt *time.Time
+	if !request.Forever && request.ExpirationTime != "" {
+		t, err := models.ParseExpirationTime(request.ExpirationTime)
+		if err != nil {
+			common.RespErr(c, common.RespCodeInvalidRequest, "invalid expiration_time", nil)
+			return
+		}
+		expiresAt = &t
+	}
+
 	db := database.GetDB()
-	accessToken := models.ExternalServerToken{
-		WorkerUID:      request.WorkerUID,
-		Description:    request.Description,
-		Forever:        request.Forever,
-		ExpirationTime: request.ExpirationTime,
-		Token:          utils.GenerateUID(),
-	}
-	if err := db.Create(&accessToken).Error; err != nil {
+	accessToken, token := models.NewExternalServerToken(request.WorkerUID, request.Description, request.Forever, expiresAt)
+	if err := db.Create(accessToken).Error; err != nil {
 		common.RespErr(c, common.RespCodeInternalError, err.Error(), nil)
 		return
 	}
 	common.RespOK(c, common.RespMsgOK, gin.H{
-		"access_token": accessToken.Token,
+		"access_token": token,
+		"expires_at":   accessToken.ExpiresAt,
 	})
 }
 
@@ -84,9 +90,9 @@ func ListAccessTokenEndpoint(c *gin.Context) {
 		common.RespErr(c, common.RespCodeInternalError, err.Error(), nil)
 		return
 	}
-	// 遍历每个key，只保留前三位，其他用*替代
+	// 只保存了哈希，用明文的前三位区分，其他用*替代
 	for i := range accessTokens {
-		accessTokens[i].Token = accessTokens[i].Token[:3] + "************" + accessTokens[i].Token[len(accessTokens[i].Token)-3:]
+		accessTokens[i].Token = accessTokens[i].TokenPrefix + "************"
 	}
 	common.RespOK(c, common.RespMsgOK, gin.H{
 		"access_tokens": accessTokens,
@@ -123,3 +129,62 @@ func DeleteAccessTokenEndpoint(c *gin.Context) {
 	}
 	common.RespOK(c, common.RespMsgOK, nil)
 }
+
+// 轮换时旧 token 默认的可用时间
+const defaultTokenRotateGrace = time.Hour
+
+type AccessTokenRotateRequest struct {
+	WorkerUID string `json:"worker_uid" binding:"required"`
+	ID        uint   `json:"id" binding:"required,gt=0"`
+	// 旧 token 继续可用的秒数，为 0 时使用默认的 1 小时，为负数时立即失效
+	GracePeriod int `json:"grace_period"`
+}
+
+// RotateAccessTokenEndpoint 生成新的 token，旧 token 在宽限时间内仍然可用，方便调用方切换
+func RotateAccessTokenEndpoint(c *gin.Context) {
+
+	uid, ok := common.RequireUID(c)
+	if !ok {
+		return
+	}
+	request := AccessTokenRotateRequest{}
+	if err := c.BindJSON(&request); err != nil {
+		return
+	}
+
+	_, err := permissions.CanWriteWorker(c, uid, request.WorkerUID)
+	if err != nil {
+		return
+	}
+
+	db := database.GetDB()
+	var old models.ExternalServerToken
+	if err := db.Where(&models.ExternalServerToken{WorkerUID: request.WorkerUID, Model: gorm.Model{
+		ID: request.ID,
+	}}).First(&old).Error; err != nil {
+		common.RespErr(c, common.RespCodeInvalidRequest, "token not found", nil)
+		return
+	}
+	if old.Expired(time.Now()) {
+		common.RespErr(c, common.RespCodeInvalidRequest, "token expired", nil)
+		return
+	}
+
+	grace := defaultTokenRotateGrace
+	if request.GracePeriod > 0 {
+		grace = time.Duration(request.GracePeriod) * time.Second
+	} else if request.GracePeriod < 0 {
+		grace = 0
+	}
+	accessToken, token, err := models.RotateExternalServerToken(&old, grace)
+	if err != nil {
+		common.RespErr(c, common.RespCodeInternalError, err.Error(), nil)
+		return
+	}
+	common.RespOK(c, common.RespMsgOK, gin.H{
+		"id":             accessToken.ID,
+		"access_token":   token,
+		"expires_at":     accessToken.ExpiresAt,
+		"old_expires_at": old.ExpiresAt,
+	})
+}
@@ -0,0 +1,199 @@
+package access
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"vvorker/common"
+	"vvorker/models"
+	"vvorker/utils"
+	"vvorker/utils/database"
+	"vvorker/utils/permissions"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type AKSKCreateRequest struct {
+	WorkerUID      string `json:"worker_uid" binding:"required"`
+	Description    string `json:"description"`
+	Forever        bool   `json:"forever"`
+	ExpirationTime string `json:"expiration_time"`
+}
+
+// checkAKSKExpiration 非永久有效的密钥必须给出合法的过期时间
+func checkAKSKExpiration(c *gin.Context, forever bool, expirationTime string) bool {
+	if forever {
+		return true
+	}
+	if _, err := models.ParseExpirationTime(expirationTime); err != nil {
+		common.RespErr(c, common.RespCodeInvalidRequest, "invalid expiration_time", nil)
+		return false
+	}
+	return true
+}
+
+// CreateAKSKEndpoint 创建 AK/SK，SK 只在创建时返回一次
+func CreateAKSKEndpoint(c *gin.Context) {
+
+	uid, ok := common.RequireUID(c)
+	if !ok {
+		return
+	}
+	request := AKSKCreateRequest{}
+	if err := c.BindJSON(&request); err != nil {
+		return
+	}
+	if !checkAKSKExpiration(c, request.Forever, request.ExpirationTime) {
+		return
+	}
+
+	_, err := permissions.CanWriteWorker(c, uid, request.WorkerUID)
+	if err != nil {
+		return
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		common.RespErr(c, common.RespCodeInternalError, err.Error(), nil)
+		return
+	}
+
+	db := database.GetDB()
+	aksk := models.ExternalServerAKSK{
+		WorkerUID:      request.WorkerUID,
+		AccessKey:      utils.GenerateUID(),
+		SecretKey:      hex.EncodeToString(secret),
+		Description:    request.Description,
+		Forever:        request.Forever,
+		ExpirationTime: request.ExpirationTime,
+	}
+	if err := db.Create(&aksk).Error; err != nil {
+		common.RespErr(c, common.RespCodeInternalError, err.Error(), nil)
+		return
+	}
+	common.RespOK(c, common.RespMsgOK, gin.H{
+		"access_key": aksk.AccessKey,
+		"secret_key": aksk.SecretKey,
+	})
+}
+
+type AKSKListRequest struct {
+	WorkerUID string `json:"worker_uid" binding:"required"`
+	Page      int    `json:"page" binding:"gte=1"`
+	PageSize  int    `json:"page_size" binding:"gte=1"`
+}
+
+func ListAKSKEndpoint(c *gin.Context) {
+
+	uid, ok := common.RequireUID(c)
+	if !ok {
+		return
+	}
+	request := AKSKListRequest{}
+	if err := c.BindJSON(&request); err != nil {
+		return
+	}
+
+	_, err := permissions.CanReadWorker(c, uid, request.WorkerUID)
+	if err != nil {
+		return
+	}
+
+	db := database.GetDB()
+	var total int64
+	if err := db.Model(&models.ExternalServerAKSK{}).Where(&models.ExternalServerAKSK{WorkerUID: request.WorkerUID}).Count(&total).Error; err != nil {
+		common.RespErr(c, common.RespCodeInternalError, err.Error(), nil)
+		return
+	}
+	var aksks []models.ExternalServerAKSK
+	if err := db.Where(&models.ExternalServerAKSK{WorkerUID: request.WorkerUID}).Offset((request.Page - 1) * request.PageSize).Limit(request.PageSize).Find(&aksks).Error; err != nil {
+		common.RespErr(c, common.RespCodeInternalError, err.Error(), nil)
+		return
+	}
+	// SK 不再返回
+	for i := range aksks {
+		aksks[i].SecretKey = ""
+	}
+	common.RespOK(c, common.RespMsgOK, gin.H{
+		"aksks": aksks,
+		"total": total,
+	})
+}
+
+type AKSKUpdateRequest struct {
+	WorkerUID      string `json:"worker_uid" binding:"required"`
+	ID             uint   `json:"id" binding:"required,gt=0"`
+	Description    string `json:"description"`
+	Forever        bool   `json:"forever"`
+	ExpirationTime string `json:"expiration_time"`
+}
+
+// UpdateAKSKEndpoint 更新描述与有效期，AK/SK 本身不变
+func UpdateAKSKEndpoint(c *gin.Context) {
+
+	uid, ok := common.RequireUID(c)
+	if !ok {
+		return
+	}
+	request := AKSKUpdateRequest{}
+	if err := c.BindJSON(&request); err != nil {
+		return
+	}
+	if !checkAKSKExpiration(c, request.Forever, request.ExpirationTime) {
+		return
+	}
+
+	_, err := permissions.CanWriteWorker(c, uid, request.WorkerUID)
+	if err != nil {
+		return
+	}
+
+	db := database.GetDB()
+	d := db.Model(&models.ExternalServerAKSK{}).Where(&models.ExternalServerAKSK{WorkerUID: request.WorkerUID, Model: gorm.Model{
+		ID: request.ID,
+	}}).Updates(map[string]interface{}{
+		"description":     request.Description,
+		"forever":         request.Forever,
+		"expiration_time": request.ExpirationTime,
+	})
+	if d.Error != nil {
+		common.RespErr(c, common.RespCodeInternalError, d.Error.Error(), nil)
+		return
+	}
+	if d.RowsAffected == 0 {
+		common.RespErr(c, common.RespCodeInvalidRequest, "aksk not found", nil)
+		return
+	}
+	common.RespOK(c, common.RespMsgOK, nil)
+}
+
+type AKSKDeleteRequest struct {
+	WorkerUID string `json:"worker_uid" binding:"required"`
+	ID        uint   `json:"id" binding:"required,gt=0"`
+}
+
+func DeleteAKSKEndpoint(c *gin.Context) {
+
+	uid, ok := common.RequireUID(c)
+	if !ok {
+		return
+	}
+	request := AKSKDeleteRequest{}
+	if err := c.BindJSON(&request); err != nil {
+		return
+	}
+
+	_, err := permissions.CanWriteWorker(c, uid, request.WorkerUID)
+	if err != nil {
+		return
+	}
+
+	db := database.GetDB()
+	if err := db.Where(&models.ExternalServerAKSK{WorkerUID: request.WorkerUID, Model: gorm.Model{
+		ID: request.ID,
+	}}).Delete(&models.ExternalServerAKSK{}).Error; err != nil {
+		common.RespErr(c, common.RespCodeInternalError, err.Error(), nil)
+		return
+	}
+	common.RespOK(c, common.RespMsgOK, nil)
+}
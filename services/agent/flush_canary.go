@@ -0,0 +1,30 @@
+package agent
+
+import (
+	"vvorker/common"
+	"vvorker/defs"
+	"vvorker/entities"
+	"vvorker/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+func FlushCanaryEventHandler(c *gin.Context, req *entities.NotifyEventRequest) {
+	worker, err := entities.ToWorkerEntity(req.Extra[defs.KeyWorkerProto])
+	if err != nil {
+		logrus.WithError(err).Error("flush canary event handler error")
+		common.RespErr(c, common.RespCodeInvalidRequest, common.RespMsgInvalidRequest, nil)
+		return
+	}
+
+	logrus.Infoln("flush canary event handler", worker.GetUID())
+
+	if err := (&models.Worker{Worker: worker}).FlushCanary(); err != nil {
+		logrus.WithError(err).Error("flush canary event handler error")
+		common.RespErr(c, common.RespCodeInternalError, common.RespMsgInternalError, nil)
+		return
+	}
+
+	common.RespOK(c, common.RespMsgOK, nil)
+}
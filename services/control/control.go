@@ -67,3 +67,28 @@ func SendSchedulerEvent(workerUID string, cron string, name string) {
 
 	RequestControlEndpoint(workerUID, bbody)
 }
+
+// CheckHealth 直接请求本机副本的控制端口，确认 workerd 已经启动并加载了 worker
+func CheckHealth(controlPort int) error {
+	client := &http.Client{Timeout: 2 * time.Second}
+	resp, err := client.Post(fmt.Sprintf("http://localhost:%d", controlPort),
+		"application/json", bytes.NewBufferString(`{"type":"health"}`))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("control endpoint returned status %d", resp.StatusCode)
+	}
+	var result struct {
+		Code int `json:"code"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+	if result.Code != 0 {
+		return fmt.Errorf("control endpoint returned code %d", result.Code)
+	}
+	return nil
+}
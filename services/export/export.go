@@ -1,6 +1,7 @@
 package export
 
 import (
+	"io"
 	"vvorker/common"
 	"vvorker/conf"
 	kv "vvorker/ext/kv/src"
@@ -171,9 +172,20 @@ func ExportResourcesConfigEndpoint(c *gin.Context) {
 					common.RespErr(c, common.RespCodeInternalError, common.RespMsgInternalError, nil)
 					return
 				}
+				rc, err := file.Open(c)
+				if err != nil {
+					common.RespErr(c, common.RespCodeInternalError, common.RespMsgInternalError, nil)
+					return
+				}
+				content, err := io.ReadAll(rc)
+				rc.Close()
+				if err != nil {
+					common.RespErr(c, common.RespCodeInternalError, common.RespMsgInternalError, nil)
+					return
+				}
 				assetFile := &AssetFile{
 					Assets:  asset,
-					Content: file.Data,
+					Content: content,
 				}
 				res.Assets = append(res.Assets, assetFile)
 			}
@@ -240,16 +252,16 @@ func ImportResourcesConfigEndpoint(g *gin.Context) {
 			common.RespErr(g, common.RespCodeInternalError, common.RespMsgInternalError, nil)
 			return
 		}
+		blobFile, err := models.NewBlobFile(g, userID, asset.MIME, asset.Content)
+		if err != nil {
+			common.RespErr(g, common.RespCodeInternalError, common.RespMsgInternalError, nil)
+			return
+		}
+		blobFile.UID = asset.UID
 		nfile := models.File{}
 		if err := db.Where(&models.File{
 			UID: asset.UID,
-		}).Assign(&models.File{
-			Data:      asset.Content,
-			UID:       asset.UID,
-			Hash:      asset.Hash,
-			Mimetype:  asset.MIME,
-			CreatedBy: userID,
-		}).FirstOrCreate(&nfile).Error; err != nil {
+		}).Assign(blobFile).FirstOrCreate(&nfile).Error; err != nil {
 			common.RespErr(g, common.RespCodeInternalError, common.RespMsgInternalError, nil)
 			return
 		}
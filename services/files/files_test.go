@@ -0,0 +1,191 @@
+package files
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"vvorker/common"
+	"vvorker/conf"
+	"vvorker/defs"
+	"vvorker/models"
+	"vvorker/utils/blobstore"
+	"vvorker/utils/database"
+
+	"github.com/gin-gonic/gin"
+)
+
+const testUserID = 7
+
+// setupFilesTest 使用临时的 sqlite 与本地 blob 存储
+func setupFilesTest(t *testing.T) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	dir := t.TempDir()
+	conf.AppConfigInstance.DBType = defs.DBTypeSqlite
+	conf.AppConfigInstance.DBPath = filepath.Join(dir, "db.sqlite")
+	conf.AppConfigInstance.BlobStorageType = blobstore.TypeLocal
+	conf.AppConfigInstance.BlobStorageDir = filepath.Join(dir, "blobs")
+	conf.AppConfigInstance.MAN_ASSET_FILE_REPLACE = false
+	database.InitDB()
+	if err := database.GetDB().AutoMigrate(&models.File{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newFilesRouter() *gin.Engine {
+	r := gin.New()
+	r.Use(func(c *gin.Context) { c.Set(common.UIDKey, uint(testUserID)) })
+	r.POST("/upload", UploadFileEndpoint)
+	r.GET("/get/:fileId", GetFileEndpoint)
+	return r
+}
+
+type uploadResult struct {
+	Code int            `json:"code"`
+	Data UploadFileResp `json:"data"`
+}
+
+func doUpload(t *testing.T, r *gin.Engine, req *http.Request) uploadResult {
+	t.Helper()
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	var res uploadResult
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("invalid response %q: %v", w.Body.String(), err)
+	}
+	return res
+}
+
+func zipBytes(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	for name, content := range files {
+		f, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestUploadAndGetFile(t *testing.T) {
+	setupFilesTest(t)
+	r := newFilesRouter()
+	binary := []byte{0x00, 0xff, 0xfe, 'a', '\n', 0x80}
+
+	rawUpload := func(path string, body []byte) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/upload?path="+path, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/octet-stream")
+		return req
+	}
+	jsonUpload := func(path string, body []byte) *http.Request {
+		raw, _ := json.Marshal(UploadFileReq{File: base64.StdEncoding.EncodeToString(body), Path: path})
+		req := httptest.NewRequest(http.MethodPost, "/upload", bytes.NewReader(raw))
+		req.Header.Set("Content-Type", "application/json")
+		return req
+	}
+
+	tests := []struct {
+		name     string
+		req      *http.Request
+		wantCode int
+		want     []byte
+	}{
+		{name: "raw body", req: rawUpload("a.bin", binary), want: binary},
+		{name: "json base64", req: jsonUpload("b.bin", []byte("json content")), want: []byte("json content")},
+		{name: "raw without path", req: rawUpload("", binary), wantCode: common.RespCodeInvalidRequest},
+		{name: "json invalid base64", req: func() *http.Request {
+			req := httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader(`{"file":"!!!","path":"c.bin"}`))
+			req.Header.Set("Content-Type", "application/json")
+			return req
+		}(), wantCode: common.RespCodeInternalError},
+		{name: "invalid zip", req: rawUpload("d.zip", []byte("not a zip")), wantCode: common.RespCodeInternalError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := doUpload(t, r, tt.req)
+			if res.Code != tt.wantCode {
+				t.Fatalf("code = %d, want %d", res.Code, tt.wantCode)
+			}
+			if tt.wantCode != common.RespCodeOK {
+				return
+			}
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/get/"+res.Data.FileID+"?raw=true", nil))
+			if !bytes.Equal(w.Body.Bytes(), tt.want) {
+				t.Errorf("raw content = %q, want %q", w.Body.Bytes(), tt.want)
+			}
+
+			w = httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/get/"+res.Data.FileID, nil))
+			var got struct {
+				Code int `json:"code"`
+				Data struct {
+					UID  string `json:"uid"`
+					Hash string `json:"hash"`
+					Data []byte `json:"data"`
+				} `json:"data"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatalf("invalid json response %q: %v", w.Body.String(), err)
+			}
+			if got.Code != common.RespCodeOK || got.Data.UID != res.Data.FileID || got.Data.Hash != res.Data.FileHash {
+				t.Errorf("unexpected file record %+v", got)
+			}
+			if !bytes.Equal(got.Data.Data, tt.want) {
+				t.Errorf("json content = %q, want %q", got.Data.Data, tt.want)
+			}
+		})
+	}
+}
+
+func TestUploadZipAndDedup(t *testing.T) {
+	setupFilesTest(t)
+	r := newFilesRouter()
+
+	upload := func(body []byte) uploadResult {
+		req := httptest.NewRequest(http.MethodPost, "/upload?path=dist.zip", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/octet-stream")
+		return doUpload(t, r, req)
+	}
+	archive := zipBytes(t, map[string]string{"index.js": "export default {}"})
+	first := upload(archive)
+	if first.Code != common.RespCodeOK {
+		t.Fatalf("upload zip code = %d", first.Code)
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/get/"+first.Data.FileID+"?raw=true", nil))
+	tr := tar.NewReader(w.Body)
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != "index.js" {
+		t.Fatalf("zip not converted to tar: %v %v", hdr, err)
+	}
+	if content, _ := io.ReadAll(tr); string(content) != "export default {}" {
+		t.Errorf("tar content = %q", content)
+	}
+	var file models.File
+	database.GetDB().Where(&models.File{UID: first.Data.FileID}).First(&file)
+	if file.Mimetype != tarMimeType || file.Size == 0 || !file.InBlobStore {
+		t.Errorf("file record = %+v", file)
+	}
+
+	second := upload(archive)
+	if second.Data.FileID != first.Data.FileID || second.Data.FileHash != first.Data.FileHash {
+		t.Errorf("same content uploaded twice: %+v, %+v", first.Data, second.Data)
+	}
+}
@@ -1,12 +1,21 @@
 package files
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
 	"vvorker/common"
 	"vvorker/dao"
+	"vvorker/models"
 
 	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
 )
 
+// GetFileEndpoint 读取文件，raw=true 时直接返回文件内容，否则返回带 base64 内容的文件记录
+// 两种方式都边读取边写入响应，不在内存中保存整个文件
 func GetFileEndpoint(c *gin.Context) {
 	fileId := c.Param("fileId")
 	if len(fileId) == 0 {
@@ -20,5 +29,47 @@ func GetFileEndpoint(c *gin.Context) {
 		return
 	}
 
-	common.RespOK(c, "get file success", file)
+	rc, err := file.Open(c)
+	if err != nil {
+		common.RespErr(c, common.RespCodeInternalError, "read file error", nil)
+		return
+	}
+	defer rc.Close()
+
+	if raw, _ := strconv.ParseBool(c.Query("raw")); raw {
+		size := file.Size
+		if size == 0 && len(file.Data) != 0 {
+			size = int64(len(file.Data))
+		}
+		if size == 0 {
+			size = -1
+		}
+		c.DataFromReader(http.StatusOK, size, "application/octet-stream", rc, nil)
+		return
+	}
+
+	// 与 common.RespOK 的结构相同，data.data 为 base64 编码的文件内容
+	meta, err := json.Marshal(struct {
+		*models.File
+		Data *struct{} `json:"data,omitempty"`
+	}{File: file})
+	if err != nil {
+		common.RespErr(c, common.RespCodeInternalError, "read file error", nil)
+		return
+	}
+	c.Status(http.StatusOK)
+	c.Header("Content-Type", "application/json; charset=utf-8")
+	w := c.Writer
+	w.WriteString(`{"code":` + strconv.Itoa(common.RespCodeOK) + `,"msg":"get file success","data":`)
+	w.Write(meta[:len(meta)-1])
+	w.WriteString(`,"data":"`)
+	enc := base64.NewEncoder(base64.StdEncoding, w)
+	if _, err := io.Copy(enc, rc); err != nil {
+		// 响应头已经发出，只能中断响应
+		logrus.WithError(err).Errorf("read file %s error", file.UID)
+		c.Abort()
+		return
+	}
+	enc.Close()
+	w.WriteString(`"}}`)
 }
@@ -2,22 +2,21 @@ package files
 
 import (
 	"archive/zip"
-	"bytes"
-	"crypto/sha256"
 	"encoding/base64"
-	"encoding/hex"
 	"errors"
-	"fmt"
+	"io"
+	"os"
 	"path/filepath"
+	"strings"
 	"vvorker/common"
 	"vvorker/conf"
 	"vvorker/dao"
-	oss "vvorker/ext/oss/src"
 	"vvorker/models"
 	"vvorker/utils"
 	"vvorker/utils/database"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	"github.com/sirupsen/logrus"
 	"gorm.io/gorm"
 )
@@ -37,77 +36,78 @@ type UploadFileResp struct {
 	FileHash string `json:"fileHash"`
 }
 
+// UploadFileEndpoint 上传文件，请求体即文件内容，路径通过 path 参数给出，边读取边写入 blob 存储
+// 兼容旧的 JSON 请求，文件内容以 base64 放在 file 字段中
 func UploadFileEndpoint(c *gin.Context) {
-	var req UploadFileReq
-	if err := c.BindJSON(&req); err != nil {
+	uid, ok := common.RequireUID32(c)
+	if !ok {
 		return
 	}
 
-	data, err := base64.StdEncoding.DecodeString(req.File)
-	if err != nil {
-		logrus.WithError(err).Error("decode base64 error")
-		common.RespErr(c, common.RespCodeInternalError, "Internal error processing file.", nil)
-		return
+	var body io.Reader
+	var path string
+	if c.ContentType() == binding.MIMEJSON {
+		var req UploadFileReq
+		if err := c.BindJSON(&req); err != nil {
+			return
+		}
+		body = base64.NewDecoder(base64.StdEncoding, strings.NewReader(req.File))
+		path = req.Path
+	} else {
+		path = c.Query("path")
+		if len(path) == 0 {
+			common.RespErr(c, common.RespCodeInvalidRequest, "path is empty", nil)
+			return
+		}
+		body = c.Request.Body
 	}
-	contentType := filepath.Ext(req.Path)
+
+	contentType := filepath.Ext(path)
 	if contentType == zipMimeType {
-		zipReader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		rc, err := zipToTar(body)
 		if err != nil {
 			logrus.WithError(err).Error("create zip reader error")
 			common.RespErr(c, common.RespCodeInternalError, "Incomplete .zip archive file.", nil)
 			return
 		}
-
-		data, err = utils.CreateTarFromZip(zipReader)
-		if err != nil {
-			logrus.WithError(err).Error("create tar from zip error")
-			common.RespErr(c, common.RespCodeInternalError, "Internal error processing .zip archive.", nil)
-			return
-		}
+		defer rc.Close()
+		body = rc
 		contentType = tarMimeType
 	}
 
-	hashBytes := sha256.Sum256(data)
-	hash := hex.EncodeToString(hashBytes[:])
-	uid, ok := common.RequireUID32(c)
-	if !ok {
+	fileRecord, err := models.NewBlobFileFromReader(c, uid, contentType, body)
+	if err != nil {
+		var corrupt base64.CorruptInputError
+		if errors.As(err, &corrupt) {
+			logrus.WithError(err).Error("decode base64 error")
+			common.RespErr(c, common.RespCodeInternalError, "Internal error processing file.", nil)
+			return
+		}
+		logrus.WithError(err).Error("save blob error")
+		common.RespErr(c, common.RespCodeInternalError, "Internal error saving file.", nil)
 		return
 	}
-	fileRecord, err := dao.GetFileByHashAndCreator(c, hash, uid)
+
+	// 内容相同的 blob 只保存一份，已有的文件记录直接返回
+	existing, err := dao.GetFileByHashAndCreator(c, fileRecord.Hash, uid)
 	if err == nil {
 		if conf.AppConfigInstance.MAN_ASSET_FILE_REPLACE {
-			database.GetDB().Unscoped().Model(&models.File{}).Delete(fileRecord)
+			database.GetDB().Unscoped().Model(&models.File{}).Delete(existing)
 		} else {
-			logrus.Infof("file already exists: %s", fileRecord.UID)
+			logrus.Infof("file already exists: %s", existing.UID)
 			common.RespOK(c, "File already exists.", UploadFileResp{
-				FileID:   fileRecord.UID,
-				FileHash: hash,
+				FileID:   existing.UID,
+				FileHash: existing.Hash,
 			})
 			return
 		}
-
 	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
 		logrus.WithError(err).Error("get file error")
 		common.RespErr(c, common.RespCodeInternalError, "Internal error getting file.", nil)
 		return
 	}
 
-	if conf.AppConfigInstance.FileStorageUseOSS {
-		err = oss.UploadFileToSysBucket(fmt.Sprintf("files/%d/%s", uid, hash), bytes.NewReader(data))
-		if err != nil {
-			logrus.WithError(err).Error("upload file to oss error")
-			common.RespErr(c, common.RespCodeInternalError, "Internal error uploading file to OSS.", nil)
-			return
-		}
-		data = nil
-	}
-
-	fileRecord, err = dao.SaveFile(c, &models.File{
-		CreatedBy: uid,
-		Hash:      hash,
-		Mimetype:  contentType,
-		Data:      data,
-	})
+	fileRecord, err = dao.SaveFile(c, fileRecord)
 	if err != nil {
 		logrus.WithError(err).Error("insert file error")
 		common.RespErr(c, common.RespCodeInternalError, "Internal error saving file.", nil)
@@ -116,6 +116,35 @@ func UploadFileEndpoint(c *gin.Context) {
 
 	common.RespOK(c, "File uploaded successfully.", UploadFileResp{
 		FileID:   fileRecord.UID,
-		FileHash: hash,
+		FileHash: fileRecord.Hash,
 	})
 }
+
+// zipToTar 将 zip 转换为 tar 流，zip 需要随机访问，先写入临时文件
+func zipToTar(r io.Reader) (io.ReadCloser, error) {
+	tmp, err := os.CreateTemp("", "vvorker-upload-*.zip")
+	if err != nil {
+		return nil, err
+	}
+	cleanup := func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}
+	size, err := io.Copy(tmp, r)
+	if err != nil {
+		cleanup()
+		return nil, err
+	}
+	zipReader, err := zip.NewReader(tmp, size)
+	if err != nil {
+		cleanup()
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		defer cleanup()
+		pw.CloseWithError(utils.WriteTarArchive(pw, zipReader))
+	}()
+	return pr, nil
+}
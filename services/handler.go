@@ -41,6 +41,7 @@ import (
 	"vvorker/services/workerd"
 	"vvorker/tunnel"
 	"vvorker/utils"
+	"vvorker/utils/blobstore"
 	"vvorker/utils/database"
 	"vvorker/utils/middleware"
 
@@ -57,7 +58,7 @@ var (
 
 func init() {
 	router = gin.Default()
-	router.MaxMultipartMemory = 8 << 20 // 8 MiB
+	router.MaxMultipartMemory = conf.AppConfigInstance.MultipartMaxMemory
 	if conf.AppConfigInstance.DEBUGPProf {
 		pprof.Register(router)
 	}
@@ -72,6 +73,13 @@ func init() {
 	if !conf.IsMaster() {
 		router.GET("/", func(c *gin.Context) { common.RespOK(c, "ok", nil) })
 	}
+	if conf.AppConfigInstance.EnableMetrics {
+		if conf.AppConfigInstance.MetricsToken == "" {
+			logrus.Warn("ENABLE_METRICS is set but METRICS_TOKEN is empty, /metrics is disabled")
+		} else {
+			router.GET("/metrics", middleware.MetricsEndpoint())
+		}
+	}
 	econfig := middleware.DefaultEncryptionConfig()
 	api := router.Group("/api", middleware.EncryptionMiddleware(econfig))
 
@@ -84,12 +92,21 @@ func init() {
 				workerApi.GET("/run/:uid", workerd.RunWorkerEndpoint)
 				workerApi.POST("/create", workerd.CreateEndpoint)
 				// workerApi.POST("/version/:workerId/:fileId", workerd.NewVersionEndpoint)
+				workerApi.POST("/version/list", workerd.ListWorkerVersionsEndpoint)
+				workerApi.POST("/version/diff", workerd.DiffWorkerVersionsEndpoint)
+				workerApi.POST("/version/rollback", vvotp.OTPMiddleware(), workerd.RollbackWorkerVersionEndpoint)
+				workerApi.POST("/canary/get", workerd.GetWorkerCanaryEndpoint)
+				workerApi.POST("/canary/start", vvotp.OTPMiddleware(), workerd.StartWorkerCanaryEndpoint)
+				workerApi.POST("/canary/promote", vvotp.OTPMiddleware(), workerd.PromoteWorkerCanaryEndpoint)
+				workerApi.POST("/canary/abort", vvotp.OTPMiddleware(), workerd.AbortWorkerCanaryEndpoint)
 				workerApi.DELETE("/:uid", workerd.DeleteEndpoint)
 
 				workerApi.GET("/information/:id", workerd.GetWorkerInformationByIDEndpoint)
 				workerApi.POST("/information/:id", workerd.UpdateWorkerInformationEndpoint)
 
 				workerApi.POST("/logs/:uid", workerd.GetWorkerLogsEndpoint)
+				workerApi.GET("/logs/:uid/tail", workerd.TailWorkerLogsEndpoint)
+				workerApi.GET("/logs/:uid/export", workerd.ExportWorkerLogsEndpoint)
 				workerApi.POST("/status", workerd.GetWorkersStatusByUIDEndpoint)
 
 				workerApi.GET("/analyse/group-by-time", proxyService.GetWorkerRequestStatsByTime)
@@ -105,6 +122,16 @@ func init() {
 						tokenApi.POST("/create", access.CreateAccessTokenEndpoint)
 						tokenApi.POST("/list", access.ListAccessTokenEndpoint)
 						tokenApi.POST("/delete", access.DeleteAccessTokenEndpoint)
+						tokenApi.POST("/rotate", access.RotateAccessTokenEndpoint)
+					}
+
+					// AK/SK 签名请求子路由
+					akskApi := accessApi.Group("/aksk")
+					{
+						akskApi.POST("/create", access.CreateAKSKEndpoint)
+						akskApi.POST("/list", access.ListAKSKEndpoint)
+						akskApi.POST("/update", access.UpdateAKSKEndpoint)
+						akskApi.POST("/delete", access.DeleteAKSKEndpoint)
 					}
 
 					// 内部白名单子路由
@@ -200,7 +227,13 @@ func init() {
 				agentAPI.GET("/nodeinfo", authz.AgentAuthz(), node.GetNodeInfoEndpoint)
 				agentAPI.POST("/fill-worker-config", authz.AgentAuthz(), workerd.FillWorkerConfig)
 				agentAPI.POST("/logs", authz.AgentAuthz(), exec.HandleAgentWorkerLogs)
+				agentAPI.POST("/response-logs", authz.AgentAuthz(), proxyService.HandleAgentResponseLogs)
 				agentAPI.POST("/get-worker", authz.AgentAuthz(), workerd.GetWorkerEndpointAgent)
+				agentAPI.POST("/worker-canary", authz.AgentAuthz(), workerd.AgentGetWorkerCanaryEndpoint)
+				agentAPI.POST("/worker-resource", authz.AgentAuthz(), workerd.AgentGetWorkerResourceEndpoint)
+				agentAPI.POST("/ratelimit", authz.AgentAuthz(), kv.AgentTakeTokenEndpoint)
+				agentAPI.POST("/nonce", authz.AgentAuthz(), kv.AgentUseNonceEndpoint)
+				agentAPI.POST("/kv/invoke", authz.AgentAuthz(), authz.ResourceAuthz(models.ResourceTypeKV), kv.AgentInvokeKVEndpoint)
 			} else {
 				agentAPI.POST("/notify", authz.AgentAuthz(), agent.NotifyEndpoint)
 			}
@@ -217,30 +250,32 @@ func init() {
 		{
 			featuresAPI.GET("/list", features.ListFeaturesEndpoint)
 		}
-		extAPI := api.Group("/ext")
+		extAPI := api.Group("/ext", middleware.ExtMetricsMiddleware())
 		{
 			ossAPI := extAPI.Group("/oss")
 			{
+				// worker 访问 oss 的接口使用资源凭证，资源相关的请求头按 worker 配置覆盖
+				ossResource := ossAPI.Group("", authz.ResourceAuthz(models.ResourceTypeOSS), oss.ResourceHeaders())
 				switch conf.AppConfigInstance.ServerOSSType {
 				case "aliyun":
-					ossAPI.POST("/upload", authz.AgentAuthz(), alioss.UploadFile)
-					ossAPI.POST("/download", authz.AgentAuthz(), alioss.DownloadFile)
+					ossResource.POST("/upload", alioss.UploadFile)
+					ossResource.POST("/download", alioss.DownloadFile)
 				case "aliyun1":
-					ossAPI.POST("/upload", authz.AgentAuthz(), alioss1.UploadFileEndpoint)
-					ossAPI.POST("/download", authz.AgentAuthz(), alioss1.DownloadFileEndpoint)
+					ossResource.POST("/upload", alioss1.UploadFileEndpoint)
+					ossResource.POST("/download", alioss1.DownloadFileEndpoint)
 				default:
-					ossAPI.POST("/upload", authz.AgentAuthz(), oss.UploadFileEndpoint)
-					ossAPI.POST("/download", authz.AgentAuthz(), oss.DownloadFileEndpoint)
+					ossResource.POST("/upload", oss.UploadFileEndpoint)
+					ossResource.POST("/download", oss.DownloadFileEndpoint)
 				}
 
-				ossAPI.POST("/list-buckets", authz.AgentAuthz(), oss.ListBuckets)
-				ossAPI.POST("/delete", authz.AgentAuthz(), oss.DeleteFile)
-				ossAPI.POST("/list-objects", authz.AgentAuthz(), oss.ListObjects)
+				ossResource.POST("/list-buckets", oss.ListBuckets)
+				ossResource.POST("/delete", oss.DeleteFile)
+				ossResource.POST("/list-objects", oss.ListObjects)
 
-				ossAPI.POST("/initiate-multipart-upload", authz.AgentAuthz(), oss.InitiateMultipartUpload)
-				ossAPI.POST("/upload-part", authz.AgentAuthz(), oss.UploadPart)
-				ossAPI.POST("/complete-multipart-upload", authz.AgentAuthz(), oss.CompleteMultipartUpload)
-				ossAPI.POST("/abort-multipart-upload", authz.AgentAuthz(), oss.AbortMultipartUpload)
+				ossResource.POST("/initiate-multipart-upload", oss.InitiateMultipartUpload)
+				ossResource.POST("/upload-part", oss.UploadPart)
+				ossResource.POST("/complete-multipart-upload", oss.CompleteMultipartUpload)
+				ossResource.POST("/abort-multipart-upload", oss.AbortMultipartUpload)
 
 				if conf.IsMaster() {
 					ossAPI.POST("/create-resource", authz.AccessKeyMiddleware(), authz.JWTMiddleware(), oss.CreateNewOSSResourcesEndpoint)
@@ -253,9 +288,9 @@ func init() {
 					pgsqlAPI.POST("/create-resource", authz.AccessKeyMiddleware(), authz.JWTMiddleware(), pgsql.CreateNewPostgreSQLResourcesEndpoint)
 					pgsqlAPI.POST("/delete-resource", authz.AccessKeyMiddleware(), authz.JWTMiddleware(), pgsql.DeletePostgreSQLResourcesEndpoint)
 					pgsqlAPI.POST("/migrate", authz.AccessKeyMiddleware(), authz.JWTMiddleware(), pgsql.UpdateMigrate)
-					pgsqlAPI.POST("/query", authz.AgentAuthz(), pgsql.ExecuteSQLPgSQLEndpoint)
+					pgsqlAPI.POST("/query", authz.ResourceAuthz(models.ResourceTypePgSQL), pgsql.ExecuteSQLPgSQLEndpoint)
 				} else {
-					pgsqlAPI.POST("/query", authz.AgentAuthz(), pgsql.ExecuteSQLPgSQLEndpoint)
+					pgsqlAPI.POST("/query", authz.ResourceAuthz(models.ResourceTypePgSQL), pgsql.ExecuteSQLPgSQLEndpoint)
 				}
 			}
 			mysqlAPI := extAPI.Group("/mysql")
@@ -264,9 +299,9 @@ func init() {
 					mysqlAPI.POST("/create-resource", authz.AccessKeyMiddleware(), authz.JWTMiddleware(), extmysql.CreateNewMySQLResourcesEndpoint)
 					mysqlAPI.POST("/delete-resource", authz.AccessKeyMiddleware(), authz.JWTMiddleware(), extmysql.DeleteMySQLResourcesEndpoint)
 					mysqlAPI.POST("/migrate", authz.AccessKeyMiddleware(), authz.JWTMiddleware(), extmysql.UpdateMigrate)
-					mysqlAPI.POST("/query", authz.AgentAuthz(), extmysql.ExecuteSQLMysqlEndpoint)
+					mysqlAPI.POST("/query", authz.ResourceAuthz(models.ResourceTypeMySQL), extmysql.ExecuteSQLMysqlEndpoint)
 				} else {
-					mysqlAPI.POST("/query", authz.AgentAuthz(), extmysql.ExecuteSQLMysqlEndpoint)
+					mysqlAPI.POST("/query", authz.ResourceAuthz(models.ResourceTypeMySQL), extmysql.ExecuteSQLMysqlEndpoint)
 				}
 			}
 			kvAPI := extAPI.Group("/kv")
@@ -274,28 +309,32 @@ func init() {
 				if conf.IsMaster() {
 					kvAPI.POST("/create-resource", authz.AccessKeyMiddleware(), authz.JWTMiddleware(), kv.CreateKVResourcesEndpoint)
 					kvAPI.POST("/delete-resource", authz.AccessKeyMiddleware(), authz.JWTMiddleware(), kv.DeleteKVResourcesEndpoint)
-					kvAPI.POST("/invoke", authz.AgentAuthz(), kv.InvokeKVEndpoint)
 				}
+				kvAPI.POST("/invoke", authz.ResourceAuthz(models.ResourceTypeKV), kv.InvokeKVEndpoint)
 			}
 			assetsAPI := extAPI.Group("/assets")
 			{
 				if conf.IsMaster() {
 					assetsAPI.POST("/create-assets", authz.AccessKeyMiddleware(), authz.JWTMiddleware(), vvotp.OTPMiddleware(), assets.UploadAssetsEndpoint)
-					assetsAPI.GET("/get-assets", authz.AgentAuthz(), assets.GetAssetsEndpoint)
+					assetsAPI.GET("/get-assets", authz.ResourceAuthz(models.ResourceTypeAssets), assets.GetAssetsEndpoint)
+					assetsAPI.HEAD("/get-assets", authz.ResourceAuthz(models.ResourceTypeAssets), assets.GetAssetsEndpoint)
 					assetsAPI.POST("/clear-assets", authz.AccessKeyMiddleware(), authz.JWTMiddleware(), vvotp.OTPMiddleware(), assets.ClearAssetsEndpoint)
 					assetsAPI.POST("/check-assets", authz.AccessKeyMiddleware(), authz.JWTMiddleware(), vvotp.OTPMiddleware(), assets.CheckAssetsEndpoint)
 					assetsAPI.POST("/delete-assets", authz.AccessKeyMiddleware(), authz.JWTMiddleware(), vvotp.OTPMiddleware(), assets.DeleteAssetsEndpoint)
+					assetsAPI.POST("/deploy-assets", authz.AccessKeyMiddleware(), authz.JWTMiddleware(), vvotp.OTPMiddleware(), assets.DeployAssetsEndpoint)
+					assetsAPI.POST("/rollback-assets", authz.AccessKeyMiddleware(), authz.JWTMiddleware(), vvotp.OTPMiddleware(), assets.RollbackAssetsEndpoint)
+					assetsAPI.POST("/list-assets-releases", authz.AccessKeyMiddleware(), authz.JWTMiddleware(), assets.ListAssetsReleasesEndpoint)
 				}
 			}
 			taskAPI := extAPI.Group("/task")
 			{
 				if conf.IsMaster() {
-					taskAPI.POST("/create", authz.AgentAuthz(), task.CreateTaskEndpoint)
+					taskAPI.POST("/create", authz.ResourceAuthz(models.ResourceTypeTask), task.CreateTaskEndpoint)
 					taskAPI.POST("/cancel", authz.AccessKeyMiddleware(), authz.JWTMiddleware(), task.CancelTaskEndpoint)
-					taskAPI.POST("/check", authz.AgentAuthz(), task.CheckInterruptTaskEndpoint)
-					taskAPI.POST("/log", authz.AgentAuthz(), task.LogTaskEndpoint)
+					taskAPI.POST("/check", authz.ResourceAuthz(models.ResourceTypeTask), task.CheckInterruptTaskEndpoint)
+					taskAPI.POST("/log", authz.ResourceAuthz(models.ResourceTypeTask), task.LogTaskEndpoint)
 					taskAPI.POST("/logs", authz.AccessKeyMiddleware(), authz.JWTMiddleware(), task.GetLogsEndpoint)
-					taskAPI.POST("/complete", authz.AgentAuthz(), task.CompleteTaskEndpoint)
+					taskAPI.POST("/complete", authz.ResourceAuthz(models.ResourceTypeTask), task.CompleteTaskEndpoint)
 					taskAPI.POST("/list", authz.AccessKeyMiddleware(), authz.JWTMiddleware(), task.ListTaskEndpoint)
 					taskAPI.POST("/check-interrupt-task", authz.AccessKeyMiddleware(), authz.JWTMiddleware(), task.CheckInterruptTaskEndpoint)
 				}
@@ -384,6 +423,8 @@ func Run(f embed.FS) {
 	})
 	wg.Go(database.InitDB)
 	wg.Go(models.MigrateNormalModel)
+	wg.Go(models.RunLogRetention)
+	wg.Go(blobstore.RunDiskCachePrune)
 	if conf.IsMaster() {
 		HandleStaticFile(f)
 	}
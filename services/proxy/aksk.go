@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"vvorker/conf"
+	kv "vvorker/ext/kv/src"
+	"vvorker/models"
+	"vvorker/utils/database"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// AK/SK 签名请求使用的请求头
+const (
+	AKSKHeaderAccessKey = "vvorker-access-key"
+	AKSKHeaderTimestamp = "vvorker-timestamp"
+	AKSKHeaderNonce     = "vvorker-nonce"
+	AKSKHeaderSignature = "vvorker-signature"
+)
+
+// AKSKStringToSign 待签名的字符串，path 为 worker 内的路径，有查询参数时包含查询参数
+// 签名为以 SK 为密钥对该字符串做 HMAC-SHA256 后的十六进制
+func AKSKStringToSign(method, path, timestamp, nonce string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+	return strings.Join([]string{
+		strings.ToUpper(method),
+		path,
+		timestamp,
+		nonce,
+		hex.EncodeToString(bodyHash[:]),
+	}, "\n")
+}
+
+func AKSKSign(secretKey, stringToSign string) string {
+	mac := hmac.New(sha256.New, []byte(secretKey))
+	mac.Write([]byte(stringToSign))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// useNonce 记录 nonce，测试中替换为内存实现
+var useNonce = kv.UseNonce
+
+// checkAKSK 校验签名请求，请求没有携带 AK 时返回 false 且不写入响应，交给后续规则处理
+// 签名不正确、时间戳超出范围、nonce 重复或密钥过期时返回 403，请求体超过 AKSK_MAX_BODY_SIZE 时返回 413，aborted 为 true
+func checkAKSK(c *gin.Context, workerUID string) (authed bool, aborted bool) {
+	accessKey := c.Request.Header.Get(AKSKHeaderAccessKey)
+	if accessKey == "" {
+		return false, false
+	}
+	timestamp := c.Request.Header.Get(AKSKHeaderTimestamp)
+	nonce := c.Request.Header.Get(AKSKHeaderNonce)
+	signature := c.Request.Header.Get(AKSKHeaderSignature)
+	if timestamp == "" || nonce == "" || signature == "" {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return false, true
+	}
+
+	window := int64(conf.AppConfigInstance.AKSKSignatureWindow)
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || ts < time.Now().Unix()-window || ts > time.Now().Unix()+window {
+		c.AbortWithStatus(http.StatusForbidden)
+		return false, true
+	}
+
+	var aksk models.ExternalServerAKSK
+	if err := database.GetDB().Where(&models.ExternalServerAKSK{
+		WorkerUID: workerUID,
+		AccessKey: accessKey,
+	}).First(&aksk).Error; err != nil {
+		c.AbortWithStatus(http.StatusForbidden)
+		return false, true
+	}
+	if aksk.Expired(time.Now()) {
+		c.AbortWithStatus(http.StatusForbidden)
+		return false, true
+	}
+
+	// AK 不是秘密，校验签名前读取请求体需要限制大小
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, conf.AppConfigInstance.AKSKMaxBodySize))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			c.AbortWithStatus(http.StatusRequestEntityTooLarge)
+		} else {
+			c.AbortWithStatus(http.StatusBadRequest)
+		}
+		return false, true
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	path := c.Request.URL.Path
+	if c.Request.URL.RawQuery != "" {
+		path += "?" + c.Request.URL.RawQuery
+	}
+	expected := AKSKSign(aksk.SecretKey, AKSKStringToSign(c.Request.Method, path, timestamp, nonce, body))
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(signature))) {
+		c.AbortWithStatus(http.StatusForbidden)
+		return false, true
+	}
+
+	// 签名校验通过后再记录 nonce，避免无效请求占用 nonce；时间戳超出范围的请求已被拒绝，nonce 只需保留两倍的时间窗口
+	fresh, err := useNonce(workerUID+":"+accessKey+":"+nonce, int(window*2)+1)
+	if err != nil {
+		logrus.WithError(err).Errorf("aksk %s use nonce error", accessKey)
+		c.AbortWithStatus(http.StatusServiceUnavailable)
+		return false, true
+	}
+	if !fresh {
+		c.AbortWithStatus(http.StatusForbidden)
+		return false, true
+	}
+
+	c.Request.Header.Del(AKSKHeaderSignature)
+	c.Set(ctxAccessKey, accessKey)
+	return true, false
+}
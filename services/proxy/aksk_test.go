@@ -0,0 +1,129 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"vvorker/conf"
+	"vvorker/defs"
+	"vvorker/models"
+	"vvorker/utils/database"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	testAKSKWorkerUID = "aksk-test-worker"
+	testAccessKey     = "ak-test"
+	testSecretKey     = "sk-test"
+	testExpiredKey    = "ak-expired"
+)
+
+// setupAKSKTest 使用临时的 sqlite 与内存中的 nonce 记录
+func setupAKSKTest(t *testing.T) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	conf.AppConfigInstance.DBType = defs.DBTypeSqlite
+	conf.AppConfigInstance.DBPath = filepath.Join(t.TempDir(), "db.sqlite")
+	conf.AppConfigInstance.AKSKSignatureWindow = 300
+	conf.AppConfigInstance.AKSKMaxBodySize = 1024
+	database.InitDB()
+	db := database.GetDB()
+	if err := db.AutoMigrate(&models.ExternalServerAKSK{}); err != nil {
+		t.Fatal(err)
+	}
+	keys := []models.ExternalServerAKSK{
+		{WorkerUID: testAKSKWorkerUID, AccessKey: testAccessKey, SecretKey: testSecretKey, Forever: true},
+		{WorkerUID: testAKSKWorkerUID, AccessKey: testExpiredKey, SecretKey: testSecretKey, ExpirationTime: "2000-01-01 00:00:00"},
+	}
+	if err := db.Create(&keys).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	var nonces sync.Map
+	origin := useNonce
+	useNonce = func(key string, ttl int) (bool, error) {
+		_, used := nonces.LoadOrStore(key, true)
+		return !used, nil
+	}
+	t.Cleanup(func() { useNonce = origin })
+}
+
+type akskRequest struct {
+	accessKey string
+	secretKey string
+	offset    time.Duration
+	nonce     string
+	signPath  string
+	body      string
+}
+
+func (r akskRequest) context(t *testing.T) (*gin.Context, *httptest.ResponseRecorder) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/data?x=1", strings.NewReader(r.body))
+	signPath := r.signPath
+	if signPath == "" {
+		signPath = "/api/data?x=1"
+	}
+	timestamp := strconv.FormatInt(time.Now().Add(r.offset).Unix(), 10)
+	c.Request.Header.Set(AKSKHeaderAccessKey, r.accessKey)
+	c.Request.Header.Set(AKSKHeaderTimestamp, timestamp)
+	c.Request.Header.Set(AKSKHeaderNonce, r.nonce)
+	c.Request.Header.Set(AKSKHeaderSignature,
+		AKSKSign(r.secretKey, AKSKStringToSign(http.MethodPost, signPath, timestamp, r.nonce, []byte(r.body))))
+	return c, w
+}
+
+func TestCheckAKSK(t *testing.T) {
+	setupAKSKTest(t)
+
+	tests := []struct {
+		name    string
+		req     akskRequest
+		replay  bool
+		authed  bool
+		aborted bool
+		status  int
+	}{
+		{name: "valid", req: akskRequest{accessKey: testAccessKey, secretKey: testSecretKey, nonce: "n1", body: "{}"}, authed: true},
+		{name: "no access key", req: akskRequest{secretKey: testSecretKey, nonce: "n2"}},
+		{name: "wrong secret", req: akskRequest{accessKey: testAccessKey, secretKey: "other", nonce: "n3"}, aborted: true, status: http.StatusForbidden},
+		{name: "signed other path", req: akskRequest{accessKey: testAccessKey, secretKey: testSecretKey, nonce: "n4", signPath: "/api/data"}, aborted: true, status: http.StatusForbidden},
+		{name: "unknown access key", req: akskRequest{accessKey: "ak-unknown", secretKey: testSecretKey, nonce: "n5"}, aborted: true, status: http.StatusForbidden},
+		{name: "expired key", req: akskRequest{accessKey: testExpiredKey, secretKey: testSecretKey, nonce: "n6"}, aborted: true, status: http.StatusForbidden},
+		{name: "timestamp too old", req: akskRequest{accessKey: testAccessKey, secretKey: testSecretKey, nonce: "n7", offset: -10 * time.Minute}, aborted: true, status: http.StatusForbidden},
+		{name: "timestamp in future", req: akskRequest{accessKey: testAccessKey, secretKey: testSecretKey, nonce: "n8", offset: 10 * time.Minute}, aborted: true, status: http.StatusForbidden},
+		{name: "timestamp inside window", req: akskRequest{accessKey: testAccessKey, secretKey: testSecretKey, nonce: "n9", offset: -4 * time.Minute}, authed: true},
+		{name: "body at limit", req: akskRequest{accessKey: testAccessKey, secretKey: testSecretKey, nonce: "n11", body: strings.Repeat("a", 1024)}, authed: true},
+		{name: "body too large", req: akskRequest{accessKey: testAccessKey, secretKey: testSecretKey, nonce: "n12", body: strings.Repeat("a", 1025)}, aborted: true, status: http.StatusRequestEntityTooLarge},
+		{name: "nonce replay", req: akskRequest{accessKey: testAccessKey, secretKey: testSecretKey, nonce: "n10"}, replay: true, aborted: true, status: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.replay {
+				c, _ := tt.req.context(t)
+				if authed, _ := checkAKSK(c, testAKSKWorkerUID); !authed {
+					t.Fatal("first request rejected")
+				}
+			}
+			c, w := tt.req.context(t)
+			authed, aborted := checkAKSK(c, testAKSKWorkerUID)
+			if authed != tt.authed || aborted != tt.aborted {
+				t.Fatalf("checkAKSK = (%v, %v), want (%v, %v)", authed, aborted, tt.authed, tt.aborted)
+			}
+			if tt.aborted && w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+			if tt.authed && c.GetString(ctxAccessKey) != tt.req.accessKey {
+				t.Errorf("access key not set in context")
+			}
+		})
+	}
+}
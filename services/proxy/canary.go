@@ -0,0 +1,47 @@
+package proxy
+
+import (
+	"math/rand/v2"
+	"strconv"
+	"vvorker/defs"
+	"vvorker/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 分流桶号 cookie 的有效期（秒）
+const canaryCookieMaxAge = 7 * 24 * 3600
+
+// useCanary 判断请求是否转发到灰度版本：先匹配请求头和 cookie，再按访问者的分流桶号与比例比较
+func useCanary(c *gin.Context, canary *models.WorkerCanary) bool {
+	if len(canary.Header) != 0 {
+		if v := c.GetHeader(canary.Header); len(v) != 0 && (len(canary.HeaderValue) == 0 || v == canary.HeaderValue) {
+			return true
+		}
+	}
+	if len(canary.Cookie) != 0 {
+		if v, err := c.Cookie(canary.Cookie); err == nil && (len(canary.CookieValue) == 0 || v == canary.CookieValue) {
+			return true
+		}
+	}
+	if canary.Percent <= 0 {
+		return false
+	}
+	if canary.Percent >= 100 {
+		return true
+	}
+	return canaryBucket(c) < canary.Percent
+}
+
+// canaryBucket 返回访问者固定的分流桶号 0 ~ 99，首次访问时随机分配并写入 cookie
+// 调大比例时已命中灰度的访问者不会被切回当前版本
+func canaryBucket(c *gin.Context) int {
+	if v, err := c.Cookie(defs.CanaryCookieName); err == nil {
+		if bucket, err := strconv.Atoi(v); err == nil && bucket >= 0 && bucket < 100 {
+			return bucket
+		}
+	}
+	bucket := rand.IntN(100)
+	c.SetCookie(defs.CanaryCookieName, strconv.Itoa(bucket), canaryCookieMaxAge, "/", "", false, true)
+	return bucket
+}
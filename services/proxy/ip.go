@@ -0,0 +1,99 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"vvorker/conf"
+	"vvorker/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	trustedProxies     []netip.Prefix
+	trustedProxiesOnce sync.Once
+)
+
+// globalTrustedProxies 全局配置的可信代理，配置不合法时不信任任何代理
+func globalTrustedProxies() []netip.Prefix {
+	trustedProxiesOnce.Do(func() {
+		if conf.AppConfigInstance.TrustedProxies == "" {
+			return
+		}
+		prefixes, err := models.ParsePrefixes(strings.Split(conf.AppConfigInstance.TrustedProxies, ","))
+		if err != nil {
+			logrus.WithError(err).Error("invalid TRUSTED_PROXIES")
+			return
+		}
+		trustedProxies = prefixes
+	})
+	return trustedProxies
+}
+
+// clientAddr 请求的真实来源 IP
+// 从直连地址开始，只要当前地址是可信代理，就继续取 X-Forwarded-For 中前一跳的地址
+// 客户端自己伪造的 X-Forwarded-For 位于最左侧，不会越过不可信的一跳被采用
+func clientAddr(c *gin.Context, extraTrusted []netip.Prefix) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(strings.TrimSpace(c.Request.RemoteAddr))
+	if err != nil {
+		host = strings.TrimSpace(c.Request.RemoteAddr)
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	addr = addr.Unmap()
+
+	trusted := func(a netip.Addr) bool {
+		return models.PrefixesContain(globalTrustedProxies(), a) || models.PrefixesContain(extraTrusted, a)
+	}
+
+	hops := []string{}
+	for _, v := range c.Request.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0 && trusted(addr); i-- {
+		prev, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		addr = prev.Unmap()
+	}
+	return addr, true
+}
+
+// clientIP 按全局可信代理配置得到的来源 IP
+func clientIP(c *gin.Context) string {
+	addr, ok := clientAddr(c, nil)
+	if !ok {
+		return c.Request.RemoteAddr
+	}
+	return addr.String()
+}
+
+// checkIPRules 检查路径匹配的 ip 规则，来源 IP 不在允许范围内时返回 403 并中止请求
+// ip 规则只限制来源，不会让请求通过认证
+func checkIPRules(c *gin.Context, rules []models.AccessRule, requestPath string) bool {
+	for _, rule := range rules {
+		if rule.RuleType != "ip" || !strings.HasPrefix(requestPath, rule.Path) {
+			continue
+		}
+		m, err := models.ParseIPAccessRule(rule.Data)
+		if err != nil {
+			// 规则配置错误时拒绝访问，避免限制失效
+			logrus.WithError(err).Warnf("invalid ip rule %s", rule.RuleUID)
+			c.AbortWithStatus(http.StatusForbidden)
+			return false
+		}
+		addr, ok := clientAddr(c, m.TrustedProxies)
+		if !ok || !m.Allowed(addr) {
+			c.AbortWithStatus(http.StatusForbidden)
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,106 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"vvorker/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newIPTestContext(remoteAddr string, xff ...string) (*gin.Context, *httptest.ResponseRecorder) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/test", nil)
+	c.Request.RemoteAddr = remoteAddr
+	for _, v := range xff {
+		c.Request.Header.Add("X-Forwarded-For", v)
+	}
+	return c, w
+}
+
+func mustPrefixes(t *testing.T, list ...string) []netip.Prefix {
+	t.Helper()
+	prefixes, err := models.ParsePrefixes(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return prefixes
+}
+
+func TestClientAddr(t *testing.T) {
+	// 全局可信代理只在测试开始时设置一次
+	trustedProxiesOnce.Do(func() {})
+	trustedProxies = mustPrefixes(t, "10.0.0.0/8")
+	defer func() { trustedProxies = nil }()
+
+	tests := []struct {
+		name    string
+		remote  string
+		xff     []string
+		trusted []string
+		want    string
+	}{
+		{name: "no proxy", remote: "203.0.113.7:1234", want: "203.0.113.7"},
+		{name: "forged xff from untrusted client", remote: "203.0.113.7:1234", xff: []string{"1.2.3.4"}, want: "203.0.113.7"},
+		{name: "trusted proxy", remote: "10.0.0.1:1234", xff: []string{"203.0.113.7"}, want: "203.0.113.7"},
+		{name: "forged xff behind trusted proxy", remote: "10.0.0.1:1234", xff: []string{"1.2.3.4, 203.0.113.7"}, want: "203.0.113.7"},
+		{name: "forged trusted address behind trusted proxy", remote: "10.0.0.1:1234", xff: []string{"1.2.3.4, 10.0.0.9, 203.0.113.7"}, want: "203.0.113.7"},
+		{name: "chain of trusted proxies", remote: "10.0.0.1:1234", xff: []string{"203.0.113.7", "10.0.0.2"}, want: "203.0.113.7"},
+		{name: "untrusted proxy in chain", remote: "10.0.0.1:1234", xff: []string{"1.2.3.4, 198.51.100.1"}, want: "198.51.100.1"},
+		{name: "invalid hop stops", remote: "10.0.0.1:1234", xff: []string{"1.2.3.4, garbage"}, want: "10.0.0.1"},
+		{name: "rule trusted proxy", remote: "192.168.0.1:1234", xff: []string{"203.0.113.7"}, trusted: []string{"192.168.0.0/16"}, want: "203.0.113.7"},
+		{name: "rule trusted proxy not matching", remote: "172.16.0.1:1234", xff: []string{"203.0.113.7"}, trusted: []string{"192.168.0.0/16"}, want: "172.16.0.1"},
+		{name: "ipv4 mapped remote", remote: "[::ffff:10.0.0.1]:1234", xff: []string{"203.0.113.7"}, want: "203.0.113.7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := newIPTestContext(tt.remote, tt.xff...)
+			addr, ok := clientAddr(c, mustPrefixes(t, tt.trusted...))
+			if !ok {
+				t.Fatalf("clientAddr failed for %s", tt.remote)
+			}
+			if addr.String() != tt.want {
+				t.Errorf("clientAddr = %s, want %s", addr, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckIPRules(t *testing.T) {
+	trustedProxiesOnce.Do(func() {})
+	trustedProxies = nil
+
+	tests := []struct {
+		name   string
+		data   string
+		path   string
+		remote string
+		xff    []string
+		want   bool
+	}{
+		{name: "cidr allow", data: `{"allow":["203.0.113.0/24"]}`, path: "/api", remote: "203.0.113.7:1", want: true},
+		{name: "cidr allow miss", data: `{"allow":["203.0.113.0/24"]}`, path: "/api", remote: "198.51.100.1:1", want: false},
+		{name: "cidr deny", data: `{"deny":["203.0.113.0/24"]}`, path: "/api", remote: "203.0.113.7:1", want: false},
+		{name: "path not matched", data: `{"allow":["203.0.113.0/24"]}`, path: "/other", remote: "198.51.100.1:1", want: true},
+		{name: "forged xff from untrusted client", data: `{"allow":["203.0.113.0/24"]}`, path: "/api", remote: "198.51.100.1:1", xff: []string{"203.0.113.7"}, want: false},
+		{name: "forged xff behind rule trusted proxy", data: `{"allow":["203.0.113.0/24"],"trusted_proxies":["10.0.0.1"]}`, path: "/api", remote: "10.0.0.1:1", xff: []string{"203.0.113.7, 198.51.100.1"}, want: false},
+		{name: "real client behind rule trusted proxy", data: `{"allow":["203.0.113.0/24"],"trusted_proxies":["10.0.0.1"]}`, path: "/api", remote: "10.0.0.1:1", xff: []string{"198.51.100.1, 203.0.113.7"}, want: true},
+		{name: "invalid rule denies", data: `{"allow":["bad"]}`, path: "/api", remote: "203.0.113.7:1", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, w := newIPTestContext(tt.remote, tt.xff...)
+			rules := []models.AccessRule{{RuleUID: "r1", RuleType: "ip", Path: tt.path, Data: tt.data}}
+			got := checkIPRules(c, rules, "/api/test")
+			if got != tt.want {
+				t.Fatalf("checkIPRules = %v, want %v", got, tt.want)
+			}
+			if !got && w.Code != http.StatusForbidden {
+				t.Errorf("status = %d, want 403", w.Code)
+			}
+		})
+	}
+}
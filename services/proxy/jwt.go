@@ -0,0 +1,430 @@
+package proxy
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+	"vvorker/conf"
+	"vvorker/ext/kv/src/sys_cache"
+	"vvorker/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sirupsen/logrus"
+)
+
+// 默认允许的签名算法，只接受非对称算法
+var defaultJWTAlgorithms = []string{
+	"RS256", "RS384", "RS512",
+	"PS256", "PS384", "PS512",
+	"ES256", "ES384", "ES512",
+	"EdDSA",
+}
+
+// 找不到 kid 时强制刷新 JWKS 的最小间隔，避免伪造的 kid 导致频繁请求
+const jwksForceRefreshInterval = time.Minute
+
+var errUnknownKid = errors.New("unknown kid")
+
+// jwtRule jwt 与 oidc 规则的 Data
+// oidc 规则通过 issuer 的 /.well-known/openid-configuration 得到 jwks_uri，jwt 规则需要配置 jwks_uri 或 jwks
+type jwtRule struct {
+	Issuer        string                 `json:"issuer"`
+	Audience      []string               `json:"audience"`
+	JWKSURI       string                 `json:"jwks_uri"`
+	JWKS          json.RawMessage        `json:"jwks"`
+	Algorithms    []string               `json:"algorithms"`
+	Claims        map[string]interface{} `json:"claims"`          // claim 必须等于给定的值，给定数组时等于其中之一即可
+	UserIDClaim   string                 `json:"user_id_claim"`   // 默认 sub
+	RealNameClaim string                 `json:"real_name_claim"` // 默认 name
+	ForwardClaims map[string]string      `json:"forward_claims"`  // claim 名称到请求头后缀
+	Cookie        string                 `json:"cookie"`          // 没有 Authorization 时从该 cookie 读取 token
+	Leeway        int                    `json:"leeway"`          // 允许的时钟偏差（秒）
+}
+
+func parseJWTRule(ruleType, data string) (*jwtRule, error) {
+	r := &jwtRule{}
+	if err := json.Unmarshal([]byte(data), r); err != nil {
+		return nil, err
+	}
+	if ruleType == "oidc" && r.Issuer == "" {
+		return nil, errors.New("oidc rule requires issuer")
+	}
+	if ruleType == "jwt" && r.JWKSURI == "" && len(r.JWKS) == 0 {
+		return nil, errors.New("jwt rule requires jwks_uri or jwks")
+	}
+	if len(r.Algorithms) == 0 {
+		r.Algorithms = defaultJWTAlgorithms
+	}
+	if r.UserIDClaim == "" {
+		r.UserIDClaim = "sub"
+	}
+	if r.RealNameClaim == "" {
+		r.RealNameClaim = "name"
+	}
+	return r, nil
+}
+
+// ValidateJWTRule 检查 jwt 与 oidc 规则的 Data，静态 jwks 同时检查能否解析
+func ValidateJWTRule(ruleType, data string) error {
+	r, err := parseJWTRule(ruleType, data)
+	if err != nil {
+		return err
+	}
+	if len(r.JWKS) != 0 {
+		if _, err := parseJWKS(r.JWKS); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *jwtRule) bearer(c *gin.Context) string {
+	if auth := c.Request.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	if r.Cookie != "" {
+		if cookie, err := c.Cookie(r.Cookie); err == nil {
+			return cookie
+		}
+	}
+	return ""
+}
+
+// checkJWT 在本地校验 bearer token，请求没有携带 token 时返回 false 且不写入响应，交给后续规则处理
+// token 不合法时返回 401，aborted 为 true
+func checkJWT(c *gin.Context, rule models.AccessRule) (authed bool, aborted bool) {
+	r, err := parseJWTRule(rule.RuleType, rule.Data)
+	if err != nil {
+		logrus.WithError(err).Warnf("invalid %s rule %s", rule.RuleType, rule.RuleUID)
+		return false, false
+	}
+	tokenString := r.bearer(c)
+	if tokenString == "" {
+		return false, false
+	}
+
+	claims, err := r.verify(tokenString, false)
+	if errors.Is(err, errUnknownKid) && len(r.JWKS) == 0 {
+		// 签名密钥可能已经轮换，刷新 JWKS 后重试一次
+		claims, err = r.verify(tokenString, true)
+	}
+	if err != nil {
+		logrus.Debugf("%s rule %s verify token error: %v", rule.RuleType, rule.RuleUID, err)
+		c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return false, true
+	}
+	for name, want := range r.Claims {
+		if !matchClaim(claims[name], want) {
+			c.AbortWithStatus(http.StatusForbidden)
+			return false, true
+		}
+	}
+
+	prefix := conf.AppConfigInstance.SSOCookieName
+	userID := claimString(claims[r.UserIDClaim])
+	c.Request.Header.Set(prefix+"-user-id", userID)
+	c.Request.Header.Set(prefix+"-real-name", claimString(claims[r.RealNameClaim]))
+	for name, header := range r.ForwardClaims {
+		if v, ok := claims[name]; ok {
+			c.Request.Header.Set(prefix+"-"+header, claimString(v))
+		} else {
+			c.Request.Header.Del(prefix + "-" + header)
+		}
+	}
+	c.Set(ctxSSOUserID, userID)
+	return true, false
+}
+
+func (r *jwtRule) verify(tokenString string, refresh bool) (jwt.MapClaims, error) {
+	keys, err := r.keySet(refresh)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods(r.Algorithms),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Duration(r.Leeway) * time.Second),
+	}
+	if r.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(r.Issuer))
+	}
+	if len(r.Audience) != 0 {
+		opts = append(opts, jwt.WithAudience(r.Audience...))
+	}
+
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return keys.find(kid)
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// keySet 静态 jwks 直接解析，jwks_uri 与 oidc 发现的地址通过 sys_cache 缓存
+func (r *jwtRule) keySet(refresh bool) (*jwkSet, error) {
+	if len(r.JWKS) != 0 {
+		return cachedJWKS(r.JWKS)
+	}
+
+	uri := r.JWKSURI
+	if uri == "" {
+		discovery, err := sys_cache.GlobalCache("oidc:"+r.Issuer, func() ([]byte, error) {
+			return fetchJSON(strings.TrimSuffix(r.Issuer, "/") + "/.well-known/openid-configuration")
+		}, conf.AppConfigInstance.JWKSCacheTTL)
+		if err != nil {
+			return nil, err
+		}
+		doc := struct {
+			JWKSURI string `json:"jwks_uri"`
+		}{}
+		if err := json.Unmarshal(discovery, &doc); err != nil || doc.JWKSURI == "" {
+			return nil, fmt.Errorf("invalid openid configuration of %s", r.Issuer)
+		}
+		uri = doc.JWKSURI
+	}
+
+	if refresh {
+		if !allowJWKSRefresh(uri) {
+			return nil, errUnknownKid
+		}
+		sys_cache.DeleteGlobalCache("jwks:" + uri)
+	}
+	raw, err := sys_cache.GlobalCache("jwks:"+uri, func() ([]byte, error) {
+		return fetchJSON(uri)
+	}, conf.AppConfigInstance.JWKSCacheTTL)
+	if err != nil {
+		return nil, err
+	}
+	return cachedJWKS(raw)
+}
+
+var jwksRefreshTime sync.Map
+
+func allowJWKSRefresh(uri string) bool {
+	now := time.Now()
+	if last, ok := jwksRefreshTime.Load(uri); ok && now.Sub(last.(time.Time)) < jwksForceRefreshInterval {
+		return false
+	}
+	jwksRefreshTime.Store(uri, now)
+	return true
+}
+
+var jwksHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+func fetchJSON(url string) ([]byte, error) {
+	resp, err := jwksHTTPClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch %s status code %d", url, resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if !json.Valid(body) {
+		return nil, fmt.Errorf("fetch %s invalid json", url)
+	}
+	return body, nil
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type jwkSet struct {
+	keys map[string]interface{}
+	// 没有 kid 的 token 只在 jwks 中仅有一个密钥时使用该密钥
+	only interface{}
+}
+
+func (s *jwkSet) find(kid string) (interface{}, error) {
+	if kid == "" && s.only != nil {
+		return s.only, nil
+	}
+	if key, ok := s.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, errUnknownKid
+}
+
+// 解析后的 jwks 按内容的哈希缓存，内容不变时不重复解析
+var parsedJWKS sync.Map
+
+func cachedJWKS(raw []byte) (*jwkSet, error) {
+	sum := sha256.Sum256(raw)
+	key := hex.EncodeToString(sum[:])
+	if s, ok := parsedJWKS.Load(key); ok {
+		return s.(*jwkSet), nil
+	}
+	s, err := parseJWKS(raw)
+	if err != nil {
+		return nil, err
+	}
+	parsedJWKS.Store(key, s)
+	return s, nil
+}
+
+func parseJWKS(raw []byte) (*jwkSet, error) {
+	doc := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	s := &jwkSet{keys: map[string]interface{}{}}
+	for _, k := range doc.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			logrus.WithError(err).Warnf("skip jwk %s", k.Kid)
+			continue
+		}
+		s.keys[k.Kid] = key
+	}
+	if len(s.keys) == 0 {
+		return nil, errors.New("no usable key in jwks")
+	}
+	if len(s.keys) == 1 {
+		for _, key := range s.keys {
+			s.only = key
+		}
+	}
+	return s, nil
+}
+
+func (k *jwk) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeJWKInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeJWKInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid rsa exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		var ecdhCurve ecdh.Curve
+		switch k.Crv {
+		case "P-256":
+			curve, ecdhCurve = elliptic.P256(), ecdh.P256()
+		case "P-384":
+			curve, ecdhCurve = elliptic.P384(), ecdh.P384()
+		case "P-521":
+			curve, ecdhCurve = elliptic.P521(), ecdh.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		size := (curve.Params().BitSize + 7) / 8
+		if len(x) != size || len(y) != size {
+			return nil, errors.New("invalid ec point")
+		}
+		// 通过 ecdh 检查点是否在曲线上
+		if _, err := ecdhCurve.NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+}
+
+func decodeJWKInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, errors.New("empty jwk field")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// matchClaim claim 为数组时包含期望值即可，期望值为数组时匹配其中之一即可
+func matchClaim(got, want interface{}) bool {
+	if wants, ok := want.([]interface{}); ok {
+		for _, w := range wants {
+			if matchClaim(got, w) {
+				return true
+			}
+		}
+		return false
+	}
+	if gots, ok := got.([]interface{}); ok {
+		for _, g := range gots {
+			if reflect.DeepEqual(g, want) {
+				return true
+			}
+		}
+		return false
+	}
+	return got != nil && reflect.DeepEqual(got, want)
+}
+
+func claimString(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
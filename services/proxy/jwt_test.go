@@ -0,0 +1,239 @@
+package proxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+	"vvorker/conf"
+	"vvorker/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func rsaJWK(kid string, key *rsa.PublicKey) map[string]string {
+	return map[string]string{
+		"kty": "RSA",
+		"kid": kid,
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func ecJWK(kid string, key *ecdsa.PublicKey) map[string]string {
+	size := (key.Curve.Params().BitSize + 7) / 8
+	return map[string]string{
+		"kty": "EC",
+		"kid": kid,
+		"crv": key.Curve.Params().Name,
+		"x":   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, size))),
+		"y":   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, size))),
+	}
+}
+
+func jwksJSON(t *testing.T, keys ...map[string]string) []byte {
+	t.Helper()
+	raw, err := json.Marshal(map[string]interface{}{"keys": keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func signJWT(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	s, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func newJWTTestContext(token string) (*gin.Context, *httptest.ResponseRecorder) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/test", nil)
+	if token != "" {
+		c.Request.Header.Set("Authorization", "Bearer "+token)
+	}
+	return c, w
+}
+
+func TestCheckJWT(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks := jwksJSON(t, rsaJWK("rsa", &rsaKey.PublicKey), ecJWK("ec", &ecKey.PublicKey))
+
+	ruleData := func(extra map[string]interface{}) string {
+		data := map[string]interface{}{
+			"jwks":     json.RawMessage(jwks),
+			"issuer":   "https://issuer.example.com",
+			"audience": []string{"vvorker"},
+		}
+		for k, v := range extra {
+			data[k] = v
+		}
+		raw, _ := json.Marshal(data)
+		return string(raw)
+	}
+	claims := func(modify func(jwt.MapClaims)) jwt.MapClaims {
+		c := jwt.MapClaims{
+			"sub":  "user-1",
+			"name": "User One",
+			"iss":  "https://issuer.example.com",
+			"aud":  "vvorker",
+			"exp":  time.Now().Add(time.Hour).Unix(),
+			"role": "admin",
+		}
+		if modify != nil {
+			modify(c)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name    string
+		data    string
+		token   string
+		authed  bool
+		aborted bool
+		status  int
+	}{
+		{name: "valid rsa", data: ruleData(nil), token: signJWT(t, jwt.SigningMethodRS256, "rsa", rsaKey, claims(nil)), authed: true},
+		{name: "valid ec", data: ruleData(nil), token: signJWT(t, jwt.SigningMethodES256, "ec", ecKey, claims(nil)), authed: true},
+		{name: "no token", data: ruleData(nil)},
+		{name: "hs256 not allowed", data: ruleData(nil),
+			token:   signJWT(t, jwt.SigningMethodHS256, "rsa", []byte("secret"), claims(nil)),
+			aborted: true, status: http.StatusUnauthorized},
+		{name: "none not allowed", data: ruleData(nil),
+			token:   signJWT(t, jwt.SigningMethodNone, "rsa", jwt.UnsafeAllowNoneSignatureType, claims(nil)),
+			aborted: true, status: http.StatusUnauthorized},
+		{name: "alg outside rule algorithms", data: ruleData(map[string]interface{}{"algorithms": []string{"ES256"}}),
+			token:   signJWT(t, jwt.SigningMethodRS256, "rsa", rsaKey, claims(nil)),
+			aborted: true, status: http.StatusUnauthorized},
+		{name: "missing exp", data: ruleData(nil),
+			token:   signJWT(t, jwt.SigningMethodRS256, "rsa", rsaKey, claims(func(c jwt.MapClaims) { delete(c, "exp") })),
+			aborted: true, status: http.StatusUnauthorized},
+		{name: "expired", data: ruleData(nil),
+			token:   signJWT(t, jwt.SigningMethodRS256, "rsa", rsaKey, claims(func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() })),
+			aborted: true, status: http.StatusUnauthorized},
+		{name: "expired within leeway", data: ruleData(map[string]interface{}{"leeway": 120}),
+			token:  signJWT(t, jwt.SigningMethodRS256, "rsa", rsaKey, claims(func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() })),
+			authed: true},
+		{name: "bad issuer", data: ruleData(nil),
+			token:   signJWT(t, jwt.SigningMethodRS256, "rsa", rsaKey, claims(func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" })),
+			aborted: true, status: http.StatusUnauthorized},
+		{name: "bad audience", data: ruleData(nil),
+			token:   signJWT(t, jwt.SigningMethodRS256, "rsa", rsaKey, claims(func(c jwt.MapClaims) { c["aud"] = "other" })),
+			aborted: true, status: http.StatusUnauthorized},
+		{name: "unknown kid", data: ruleData(nil),
+			token:   signJWT(t, jwt.SigningMethodRS256, "rotated", rsaKey, claims(nil)),
+			aborted: true, status: http.StatusUnauthorized},
+		{name: "wrong key for kid", data: ruleData(nil),
+			token:   signJWT(t, jwt.SigningMethodES256, "rsa", ecKey, claims(nil)),
+			aborted: true, status: http.StatusUnauthorized},
+		{name: "claim mismatch", data: ruleData(map[string]interface{}{"claims": map[string]interface{}{"role": "owner"}}),
+			token:   signJWT(t, jwt.SigningMethodRS256, "rsa", rsaKey, claims(nil)),
+			aborted: true, status: http.StatusForbidden},
+		{name: "claim in list", data: ruleData(map[string]interface{}{"claims": map[string]interface{}{"role": []string{"owner", "admin"}}}),
+			token:  signJWT(t, jwt.SigningMethodRS256, "rsa", rsaKey, claims(nil)),
+			authed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, w := newJWTTestContext(tt.token)
+			rule := models.AccessRule{RuleUID: "r1", RuleType: "jwt", Path: "/", Data: tt.data}
+			authed, aborted := checkJWT(c, rule)
+			if authed != tt.authed || aborted != tt.aborted {
+				t.Fatalf("checkJWT = (%v, %v), want (%v, %v)", authed, aborted, tt.authed, tt.aborted)
+			}
+			if tt.aborted && w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+			if tt.authed {
+				if got := c.Request.Header.Get(conf.AppConfigInstance.SSOCookieName + "-user-id"); got != "user-1" {
+					t.Errorf("user id header = %q", got)
+				}
+			}
+		})
+	}
+}
+
+// jwks_uri 的密钥轮换后，未知 kid 触发一次刷新，刷新间隔内伪造的 kid 不会再次强制刷新
+// sys_cache 命中时会在后台更新缓存，这里只检查同步请求的结果
+func TestCheckJWTUnknownKidRefresh(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	oldKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rotated atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rotated.Load() {
+			w.Write(jwksJSON(t, rsaJWK("old", &oldKey.PublicKey), rsaJWK("new", &newKey.PublicKey)))
+			return
+		}
+		w.Write(jwksJSON(t, rsaJWK("old", &oldKey.PublicKey)))
+	}))
+	defer server.Close()
+
+	uri := server.URL + "/jwks"
+	data, _ := json.Marshal(map[string]interface{}{"jwks_uri": uri})
+	rule := models.AccessRule{RuleUID: "r2", RuleType: "jwt", Path: "/", Data: string(data)}
+	claims := jwt.MapClaims{"sub": "user-1", "exp": time.Now().Add(time.Hour).Unix()}
+
+	tests := []struct {
+		name   string
+		kid    string
+		key    *rsa.PrivateKey
+		rotate bool
+		authed bool
+	}{
+		{name: "known kid", kid: "old", key: oldKey, authed: true},
+		{name: "rotated kid refreshes", kid: "new", key: newKey, rotate: true, authed: true},
+		{name: "forged kid", kid: "forged", key: newKey, authed: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.rotate {
+				rotated.Store(true)
+			}
+			c, w := newJWTTestContext(signJWT(t, jwt.SigningMethodRS256, tt.kid, tt.key, claims))
+			authed, aborted := checkJWT(c, rule)
+			if authed != tt.authed {
+				t.Fatalf("checkJWT authed = %v, want %v (status %d)", authed, tt.authed, w.Code)
+			}
+			if !tt.authed && (!aborted || w.Code != http.StatusUnauthorized) {
+				t.Errorf("checkJWT aborted = %v, status = %d, want 401", aborted, w.Code)
+			}
+		})
+	}
+	if allowJWKSRefresh(uri) {
+		t.Error("forced refresh allowed again within the refresh interval")
+	}
+}
@@ -1,7 +1,7 @@
 package proxy
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httputil"
@@ -11,6 +11,7 @@ import (
 	"vvorker/common"
 	"vvorker/conf"
 	"vvorker/models"
+	"vvorker/utils"
 	"vvorker/utils/database"
 
 	"github.com/gin-gonic/gin"
@@ -65,6 +66,14 @@ func Endpoint(c *gin.Context) {
 
 		requestPath := c.Request.URL.Path
 
+		if !checkIPRules(c, rules, requestPath) {
+			return
+		}
+
+		if !checkRateLimit(c, worker.UID, rules, requestPath, false) {
+			return
+		}
+
 		for _, rule := range rules {
 			if strings.HasPrefix(requestPath, rule.Path) {
 				if rule.RuleType == "open" {
@@ -79,17 +88,41 @@ func Endpoint(c *gin.Context) {
 					}
 					accesstoken = strings.TrimPrefix(accesstoken, "Bearer ")
 					if accesstoken != "" {
-						db := database.GetDB()
-						var workerToken models.ExternalServerToken
-						d := db.Where(&models.ExternalServerToken{
-							WorkerUID: worker.UID,
-							Token:     accesstoken,
-						}).First(&workerToken)
-						if d.Error != nil {
+						workerToken, err := models.FindExternalServerToken(worker.UID, accesstoken)
+						if err != nil {
 							c.AbortWithStatus(http.StatusForbidden)
 							return
 						}
+						now := time.Now()
+						if workerToken.Expired(now) {
+							c.AbortWithStatus(http.StatusForbidden)
+							return
+						}
+						workerToken.TouchLastUsed(now)
 						c.Request.Header.Del("vvorker-access-token")
+						c.Set(ctxAccessToken, accesstoken)
+						authed = true
+						break
+					}
+				}
+
+				if rule.RuleType == "aksk" {
+					ok, aborted := checkAKSK(c, worker.UID)
+					if aborted {
+						return
+					}
+					if ok {
+						authed = true
+						break
+					}
+				}
+
+				if rule.RuleType == "jwt" || rule.RuleType == "oidc" {
+					ok, aborted := checkJWT(c, rule)
+					if aborted {
+						return
+					}
+					if ok {
 						authed = true
 						break
 					}
@@ -129,38 +162,21 @@ func Endpoint(c *gin.Context) {
 
 				if rule.RuleType == "sso" && conf.AppConfigInstance.SSOAuthURL != "" {
 					// 将c的cookie请求conf.AppConfigInstance.SSOAuthURL，获取用户是否登录的信息
-					client := &http.Client{}
-					req, err := http.NewRequest("POST", conf.AppConfigInstance.SSOAuthURL, nil)
-					if err != nil {
-						c.AbortWithStatus(http.StatusUnauthorized)
+					statusCode, authInfo, err := ssoAuthorize(c, rule.Data, worker.UID, workerName)
+					if errors.Is(err, errSSOCircuitOpen) {
+						c.AbortWithStatus(http.StatusServiceUnavailable)
 						return
 					}
-					cookies := c.Request.Cookies()
-					for _, cookie := range cookies {
-						if cookie.Name == conf.AppConfigInstance.SSOCookieName {
-							req.AddCookie(cookie)
-							break
-						}
+					if errors.Is(err, errSSODecode) {
+						c.AbortWithStatus(http.StatusForbidden)
+						return
 					}
-					req.Header.Set(conf.AppConfigInstance.SSOCookieName+"-data", rule.Data)
-					req.Header.Set(conf.AppConfigInstance.SSOCookieName+"-worker-uid", worker.UID)
-					req.Header.Set(conf.AppConfigInstance.SSOCookieName+"-request-url", c.Request.URL.RequestURI())
-					req.Header.Set(conf.AppConfigInstance.SSOCookieName+"-worker-name", workerName)
-					req.Header.Set(conf.AppConfigInstance.SSOCookieName+"-channel", c.Request.Header.Get(conf.AppConfigInstance.SSOCookieName+"-channel"))
-					resp, err := client.Do(req)
 					if err != nil {
 						c.AbortWithStatus(http.StatusUnauthorized)
 						return
 					}
-					defer resp.Body.Close()
-					var authInfo SSOAuthInfo
-					if err := json.NewDecoder(resp.Body).Decode(&authInfo); err != nil {
-						logrus.Errorf("decode error %v", err)
-						c.AbortWithStatus(http.StatusForbidden)
-						return
-					}
 
-					if resp.StatusCode == 401 {
+					if statusCode == 401 {
 						ssoRedirect := conf.AppConfigInstance.SSORedirectURL
 
 						if !strings.Contains(c.Request.Header.Get("Accept"), "text/html") {
@@ -200,9 +216,9 @@ func Endpoint(c *gin.Context) {
 
 					}
 
-					if resp.StatusCode != http.StatusOK {
-						logrus.Infof("sso auth failed, status code: %d, url: %s", resp.StatusCode, requestPath)
-						c.AbortWithStatus(resp.StatusCode)
+					if statusCode != http.StatusOK {
+						logrus.Infof("sso auth failed, status code: %d, url: %s", statusCode, requestPath)
+						c.AbortWithStatus(statusCode)
 						return
 					}
 
@@ -220,6 +236,7 @@ func Endpoint(c *gin.Context) {
 					c.Request.Header.Set(conf.AppConfigInstance.SSOCookieName+"-token", authInfo.Token)
 					c.Request.Header.Set(conf.AppConfigInstance.SSOCookieName+"-real-name", authInfo.RealName)
 					c.Request.Header.Set(conf.AppConfigInstance.SSOCookieName+"-channel", c.Request.Header.Get(conf.AppConfigInstance.SSOCookieName+"-channel"))
+					c.Set(ctxSSOUserID, authInfo.UserID)
 
 					authed = true
 					break
@@ -231,10 +248,30 @@ func Endpoint(c *gin.Context) {
 			c.AbortWithStatus(http.StatusUnauthorized)
 			return
 		}
+
+		if !checkRateLimit(c, worker.UID, rules, requestPath, true) {
+			return
+		}
 	}
 
+	// 灰度发布中的请求改写为灰度副本的域名，frp 转发时再改回原域名
+	canary, err := models.GetWorkerCanaryCached(worker.UID)
+	if err != nil {
+		logrus.WithError(err).Warnf("get worker %s canary error", worker.UID)
+	}
+	if canary != nil && useCanary(c, canary) {
+		c.Request.Host = utils.WorkerCanaryHostPrefix(workerName) + conf.AppConfigInstance.WorkerURLSuffix
+	}
+
+	start := time.Now()
+	method := c.Request.Method
+	requestPath := c.Request.URL.Path
+	recorder := newResponseRecorder(c.Writer)
+
 	proxy := httputil.NewSingleHostReverseProxy(remote)
-	proxy.ServeHTTP(c.Writer, c.Request)
+	proxy.ServeHTTP(recorder, c.Request)
+
+	RecordResponseLog(worker.UID, method, requestPath, recorder.status, start)
 }
 
 type WorkerRequestStatsReq struct {
@@ -244,12 +281,16 @@ type WorkerRequestStatsReq struct {
 }
 
 type WorkerRequestStatsResp struct {
-	WorkerUID string    `json:"worker_uid"`
-	StartTime time.Time `json:"start_time"`
-	EndTime   time.Time `json:"end_time"`
-	Total     int       `json:"total"`
-	Success   int       `json:"success"`
-	Failed    int       `json:"failed"`
+	WorkerUID   string         `json:"worker_uid"`
+	StartTime   time.Time      `json:"start_time"`
+	EndTime     time.Time      `json:"end_time"`
+	Total       int            `json:"total"`
+	Success     int            `json:"success"`
+	Failed      int            `json:"failed"`
+	P50         int64          `json:"p50"`
+	P95         int64          `json:"p95"`
+	P99         int64          `json:"p99"`
+	StatusClass map[string]int `json:"status_class"`
 }
 
 func GetWorkerRequestStats(c *gin.Context) {
@@ -258,36 +299,27 @@ func GetWorkerRequestStats(c *gin.Context) {
 	if err := c.BindJSON(&req); err != nil {
 		return
 	}
-	var resp WorkerRequestStatsResp
-	db := database.GetDB()
-	// 使用一条 SQL 语句统计总请求数、成功请求数和失败请求数
-	var result struct {
-		Total   int64 `gorm:"column:total"`
-		Success int64 `gorm:"column:success"`
-		Failed  int64 `gorm:"column:failed"`
+
+	stats := newRequestStats()
+	if err := scanResponseLogs(req.WorkerUID, req.StartTime, req.EndTime, func(log *models.ResponseLog) {
+		stats.add(log.Status, log.DurationMS)
+	}); err != nil {
+		common.RespErr(c, common.RespCodeInternalError, err.Error(), nil)
+		return
 	}
-	query := `
-	SELECT 
-		COUNT(*) AS total,
-		SUM(CASE WHEN status >= 200 AND status < 300 THEN 1 ELSE 0 END) AS success,
-		SUM(CASE WHEN status >= 400 THEN 1 ELSE 0 END) AS failed
-	FROM 
-		response_logs
-	WHERE 
-		worker_uid = ? AND time BETWEEN ? AND ?
-	`
-	db.Raw(query, req.WorkerUID, req.StartTime, req.EndTime).Scan(&result)
-	total := result.Total
-	success := result.Success
-	failed := result.Failed
-
-	resp = WorkerRequestStatsResp{
-		WorkerUID: req.WorkerUID,
-		StartTime: req.StartTime,
-		EndTime:   req.EndTime,
-		Total:     int(total),
-		Success:   int(success),
-		Failed:    int(failed),
+	p50, p95, p99 := stats.latency()
+
+	resp := WorkerRequestStatsResp{
+		WorkerUID:   req.WorkerUID,
+		StartTime:   req.StartTime,
+		EndTime:     req.EndTime,
+		Total:       stats.total,
+		Success:     stats.success,
+		Failed:      stats.failed,
+		P50:         p50,
+		P95:         p95,
+		P99:         p99,
+		StatusClass: stats.statusClass,
 	}
 	common.RespOK(c, "ok", resp)
 }
@@ -300,10 +332,14 @@ type WorkerRequestStatsByTimeReq struct {
 }
 
 type WorkerRequestStatsByTimeItem struct {
-	Time    time.Time `json:"time"`
-	Total   int       `json:"total"`
-	Success int       `json:"success"`
-	Failed  int       `json:"failed"`
+	Time        time.Time      `json:"time"`
+	Total       int            `json:"total"`
+	Success     int            `json:"success"`
+	Failed      int            `json:"failed"`
+	P50         int64          `json:"p50"`
+	P95         int64          `json:"p95"`
+	P99         int64          `json:"p99"`
+	StatusClass map[string]int `json:"status_class"`
 }
 
 type WorkerRequestStatsByTimeResp struct {
@@ -320,52 +356,56 @@ func GetWorkerRequestStatsByTime(c *gin.Context) {
 	if err := c.BindJSON(&req); err != nil {
 		return
 	}
-	var resp WorkerRequestStatsByTimeResp
-	db := database.GetDB()
 
 	// 解析时间间隔
-	_, err := time.ParseDuration(req.Interval)
-	if err != nil {
+	interval, err := time.ParseDuration(req.Interval)
+	if err != nil || interval <= 0 {
 		common.RespErr(c, common.RespCodeInvalidRequest, "Invalid interval format", nil)
 		return
 	}
+	if !req.EndTime.After(req.StartTime) {
+		common.RespErr(c, common.RespCodeInvalidRequest, "end_time must be after start_time", nil)
+		return
+	}
+	bucketCount := int(req.EndTime.Sub(req.StartTime)/interval) + 1
+	if bucketCount > maxRequestStatsBuckets {
+		common.RespErr(c, common.RespCodeInvalidRequest, "Interval is too small for the time range", nil)
+		return
+	}
 
-	// 按时间间隔分组查询
-	var stats []struct {
-		Time    time.Time `gorm:"column:time_bucket"`
-		Total   int64     `gorm:"column:total"`
-		Success int64     `gorm:"column:success"`
-		Failed  int64     `gorm:"column:failed"`
+	// 以 start_time 为起点按 interval 分组，空的时间段也会返回，方便前端绘图
+	buckets := make([]*requestStats, bucketCount)
+	for i := range buckets {
+		buckets[i] = newRequestStats()
+	}
+	if err := scanResponseLogs(req.WorkerUID, req.StartTime, req.EndTime, func(log *models.ResponseLog) {
+		idx := int(log.Time.Sub(req.StartTime) / interval)
+		if idx < 0 || idx >= bucketCount {
+			return
+		}
+		buckets[idx].add(log.Status, log.DurationMS)
+	}); err != nil {
+		common.RespErr(c, common.RespCodeInternalError, err.Error(), nil)
+		return
 	}
-	query := `
-	SELECT 
-		time_bucket(?, time) AS time_bucket,
-		COUNT(*) AS total,
-		SUM(CASE WHEN status >= 200 AND status < 300 THEN 1 ELSE 0 END) AS success,
-		SUM(CASE WHEN status >= 400 THEN 1 ELSE 0 END) AS failed
-	FROM 
-		response_logs
-	WHERE 
-		worker_uid = ? AND time BETWEEN ? AND ?
-	GROUP BY 
-		time_bucket
-	ORDER BY 
-		time_bucket
-	`
-	db.Raw(query, req.Interval, req.WorkerUID, req.StartTime, req.EndTime).Scan(&stats)
 
 	// 转换结果
-	var data []WorkerRequestStatsByTimeItem
-	for _, stat := range stats {
+	data := make([]WorkerRequestStatsByTimeItem, 0, bucketCount)
+	for i, stats := range buckets {
+		p50, p95, p99 := stats.latency()
 		data = append(data, WorkerRequestStatsByTimeItem{
-			Time:    stat.Time,
-			Total:   int(stat.Total),
-			Success: int(stat.Success),
-			Failed:  int(stat.Failed),
+			Time:        req.StartTime.Add(time.Duration(i) * interval),
+			Total:       stats.total,
+			Success:     stats.success,
+			Failed:      stats.failed,
+			P50:         p50,
+			P95:         p95,
+			P99:         p99,
+			StatusClass: stats.statusClass,
 		})
 	}
 
-	resp = WorkerRequestStatsByTimeResp{
+	resp := WorkerRequestStatsByTimeResp{
 		WorkerUID: req.WorkerUID,
 		StartTime: req.StartTime,
 		EndTime:   req.EndTime,
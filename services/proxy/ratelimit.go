@@ -0,0 +1,106 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	kv "vvorker/ext/kv/src"
+	"vvorker/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	RateLimitKeyIP    = "ip"
+	RateLimitKeyToken = "token"
+	RateLimitKeyAKSK  = "aksk"
+	RateLimitKeySSO   = "sso"
+)
+
+// 认证通过后记录在 gin.Context 中的身份，限流按这些身份计数
+const (
+	ctxAccessToken = "vvorker-access-token"
+	ctxAccessKey   = "vvorker-access-key"
+	ctxSSOUserID   = "vvorker-sso-user-id"
+)
+
+// rateLimitRule ratelimit 规则的 Data，每 Period 秒允许 Limit 次请求，Burst 为允许的突发请求数
+type rateLimitRule struct {
+	Key    string  `json:"key"`
+	Limit  float64 `json:"limit"`
+	Period float64 `json:"period"`
+	Burst  float64 `json:"burst"`
+}
+
+func parseRateLimitRule(data string) (*rateLimitRule, bool) {
+	r := &rateLimitRule{}
+	if err := json.Unmarshal([]byte(data), r); err != nil {
+		return nil, false
+	}
+	if r.Key == "" {
+		r.Key = RateLimitKeyIP
+	}
+	if r.Period <= 0 {
+		r.Period = 1
+	}
+	if r.Burst <= 0 {
+		r.Burst = r.Limit
+	}
+	return r, r.Limit > 0
+}
+
+// identity 计数使用的身份，token 与 sso 未认证时退化为按 IP 计数
+func (r *rateLimitRule) identity(c *gin.Context) string {
+	switch r.Key {
+	case RateLimitKeyToken:
+		if token := c.GetString(ctxAccessToken); token != "" {
+			sum := sha256.Sum256([]byte(token))
+			return "token:" + hex.EncodeToString(sum[:])
+		}
+	case RateLimitKeyAKSK:
+		if accessKey := c.GetString(ctxAccessKey); accessKey != "" {
+			return "aksk:" + accessKey
+		}
+	case RateLimitKeySSO:
+		if userID := c.GetString(ctxSSOUserID); userID != "" {
+			return "sso:" + userID
+		}
+	}
+	return "ip:" + clientIP(c)
+}
+
+// checkRateLimit 检查路径匹配的 ratelimit 规则，超出限制时返回 429 并中止请求
+// afterAuth 为 false 时只检查按 IP 计数的规则，认证之后再检查按 token 与 sso 计数的规则
+// KV 不可用时放行，避免存储故障导致 worker 不可访问
+func checkRateLimit(c *gin.Context, workerUID string, rules []models.AccessRule, requestPath string, afterAuth bool) bool {
+	for _, rule := range rules {
+		if rule.RuleType != "ratelimit" || !strings.HasPrefix(requestPath, rule.Path) {
+			continue
+		}
+		r, ok := parseRateLimitRule(rule.Data)
+		if !ok {
+			logrus.Warnf("invalid ratelimit rule %s: %s", rule.RuleUID, rule.Data)
+			continue
+		}
+		if (r.Key == RateLimitKeyIP) == afterAuth {
+			continue
+		}
+
+		key := workerUID + ":" + rule.RuleUID + ":" + r.identity(c)
+		allowed, retryAfter, err := kv.TakeToken(key, r.Burst, r.Limit/r.Period)
+		if err != nil {
+			logrus.WithError(err).Warnf("ratelimit rule %s take token error", rule.RuleUID)
+			continue
+		}
+		if !allowed {
+			c.Header("Retry-After", strconv.FormatInt(max((retryAfter+999)/1000, 1), 10))
+			c.AbortWithStatus(http.StatusTooManyRequests)
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,144 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+	"time"
+	"vvorker/common"
+	"vvorker/conf"
+	"vvorker/models"
+	"vvorker/rpc"
+	"vvorker/utils/database"
+	"vvorker/utils/metrics"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// 请求日志 channel，用于异步批量写入
+	responseLogChan = make(chan models.ResponseLog, 1000)
+	// 批量插入的大小
+	responseLogBatchSize = 100
+)
+
+func init() {
+	metrics.RegisterLogQueueLength("response_log", func() float64 { return float64(len(responseLogChan)) })
+	go processResponseLogs()
+}
+
+// responseRecorder 包装 ReverseProxy 的 ResponseWriter，记录响应状态码
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func newResponseRecorder(w http.ResponseWriter) *responseRecorder {
+	return &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Flush 保证流式响应（如 SSE）在包装后仍能及时下发
+func (r *responseRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// RecordResponseLog 将一次请求的结果放入队列，队列满时直接丢弃，避免阻塞请求
+func RecordResponseLog(workerUID string, method string, path string, status int, start time.Time) {
+	duration := time.Since(start)
+	metrics.ProxyRequests.WithLabelValues(workerUID, statusClassOf(status)).Inc()
+	metrics.ProxyRequestDuration.WithLabelValues(workerUID).Observe(duration.Seconds())
+
+	log := models.ResponseLog{
+		WorkerUID:  workerUID,
+		Method:     method,
+		Path:       path,
+		Status:     status,
+		Time:       start,
+		DurationMS: duration.Milliseconds(),
+	}
+	select {
+	case responseLogChan <- log:
+	default:
+		metrics.LogQueueDropped.WithLabelValues("response_log").Inc()
+		logrus.Warnf("response log queue is full, drop log of worker %s", workerUID)
+	}
+}
+
+// 处理请求日志的函数
+func processResponseLogs() {
+	var logs []models.ResponseLog
+	for {
+		select {
+		case log := <-responseLogChan:
+			logs = append(logs, log)
+			if len(logs) >= responseLogBatchSize {
+				// 批量插入数据库
+				if err := dbCreateResponseLogs(logs); err != nil {
+					logrus.Errorf("Failed to batch insert response logs: %v", err)
+				}
+				logs = nil
+			}
+		case <-time.After(2 * time.Second):
+			if len(logs) > 0 {
+				// 定时批量插入
+				if err := dbCreateResponseLogs(logs); err != nil {
+					logrus.Errorf("Failed to batch insert response logs: %v", err)
+				}
+				logs = nil
+			}
+		}
+	}
+}
+
+type AgentResponseLogsReq struct {
+	Logs []models.ResponseLog `json:"logs"`
+}
+
+// 批量插入请求日志到数据库的函数，agent 节点转发给 master
+func dbCreateResponseLogs(logs []models.ResponseLog) error {
+	if conf.IsMaster() {
+		db := database.GetDB()
+		return db.CreateInBatches(logs, len(logs)).Error
+	}
+	url := conf.AppConfigInstance.MasterEndpoint + "/api/agent/response-logs"
+	rtype := struct {
+		Code int    `json:"code"`
+		Msg  string `json:"msg"`
+	}{}
+
+	reqResp, err := rpc.RPCWrapper().
+		SetBody(&AgentResponseLogsReq{Logs: logs}).
+		SetSuccessResult(&rtype).
+		Post(url)
+
+	if err != nil || reqResp.StatusCode >= 299 {
+		return errors.New("error")
+	}
+	return nil
+}
+
+func HandleAgentResponseLogs(c *gin.Context) {
+	var req AgentResponseLogsReq
+	if err := c.BindJSON(&req); err != nil {
+		return
+	}
+	for i := range req.Logs {
+		req.Logs[i].ID = 0
+	}
+	if err := dbCreateResponseLogs(req.Logs); err != nil {
+		common.RespErr(c, common.RespCodeInternalError, common.RespMsgInternalError, nil)
+		return
+	}
+	common.RespOK(c, common.RespMsgOK, nil)
+}
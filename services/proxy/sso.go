@@ -0,0 +1,147 @@
+package proxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+	"vvorker/conf"
+	"vvorker/ext/kv/src/sys_cache"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	errSSOCircuitOpen = errors.New("sso circuit open")
+	errSSODecode      = errors.New("sso response decode error")
+)
+
+// ssoClient 所有请求共用，带超时，避免 sso 服务无响应时请求一直挂起
+var (
+	ssoClient     *http.Client
+	ssoClientOnce sync.Once
+)
+
+func getSSOClient() *http.Client {
+	ssoClientOnce.Do(func() {
+		ssoClient = &http.Client{Timeout: time.Duration(conf.AppConfigInstance.SSOTimeout) * time.Second}
+	})
+	return ssoClient
+}
+
+// ssoBreaker 本节点的熔断状态，连续失败达到阈值后在冷却时间内直接拒绝，冷却结束后放行一次试探
+type ssoBreaker struct {
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+}
+
+var breaker = &ssoBreaker{}
+
+func (b *ssoBreaker) allow(now time.Time) bool {
+	if conf.AppConfigInstance.SSOBreakerThreshold <= 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if now.Before(b.openUntil) {
+		return false
+	}
+	if b.failures >= conf.AppConfigInstance.SSOBreakerThreshold {
+		// 冷却结束，放行当前请求试探，试探失败会重新熔断
+		b.openUntil = now.Add(time.Duration(conf.AppConfigInstance.SSOBreakerCooldown) * time.Second)
+	}
+	return true
+}
+
+func (b *ssoBreaker) done(ok bool, now time.Time) {
+	if conf.AppConfigInstance.SSOBreakerThreshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ok {
+		b.failures = 0
+		b.openUntil = time.Time{}
+		return
+	}
+	b.failures++
+	if b.failures >= conf.AppConfigInstance.SSOBreakerThreshold {
+		if b.failures == conf.AppConfigInstance.SSOBreakerThreshold {
+			logrus.Warnf("sso auth failed %d times, circuit open", b.failures)
+		}
+		b.openUntil = now.Add(time.Duration(conf.AppConfigInstance.SSOBreakerCooldown) * time.Second)
+	}
+}
+
+// ssoCacheKey 鉴权结果与 cookie、规则数据、worker、渠道以及请求 url 有关
+func ssoCacheKey(cookie, data, workerUID, channel, requestURL string) string {
+	sum := sha256.Sum256([]byte(cookie + "\n" + data + "\n" + workerUID + "\n" + channel + "\n" + requestURL))
+	return "sso:" + hex.EncodeToString(sum[:])
+}
+
+// ssoAuthorize 调用 sso 认证地址，返回状态码与认证信息
+// 只缓存带有 cookie 且通过的结果；需要重新设置 cookie 的结果不缓存，保证新的 token 能下发
+func ssoAuthorize(c *gin.Context, data, workerUID, workerName string) (int, *SSOAuthInfo, error) {
+	prefix := conf.AppConfigInstance.SSOCookieName
+	channel := c.Request.Header.Get(prefix + "-channel")
+	requestURL := c.Request.URL.RequestURI()
+	cookie, _ := c.Request.Cookie(prefix)
+
+	cacheKey := ""
+	if cookie != nil && cookie.Value != "" && conf.AppConfigInstance.SSOCacheTTL > 0 {
+		cacheKey = ssoCacheKey(cookie.Value, data, workerUID, channel, requestURL)
+		if v, err := sys_cache.Get(cacheKey); err == nil && len(v) != 0 {
+			var authInfo SSOAuthInfo
+			if err := json.Unmarshal(v, &authInfo); err == nil {
+				return http.StatusOK, &authInfo, nil
+			}
+		}
+	}
+
+	now := time.Now()
+	if !breaker.allow(now) {
+		return 0, nil, errSSOCircuitOpen
+	}
+
+	req, err := http.NewRequestWithContext(c.Request.Context(), "POST", conf.AppConfigInstance.SSOAuthURL, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	req.Header.Set(prefix+"-data", data)
+	req.Header.Set(prefix+"-worker-uid", workerUID)
+	req.Header.Set(prefix+"-request-url", requestURL)
+	req.Header.Set(prefix+"-worker-name", workerName)
+	req.Header.Set(prefix+"-channel", channel)
+	resp, err := getSSOClient().Do(req)
+	if err != nil {
+		// 客户端断开导致的取消与 sso 服务无关，不计入失败
+		if !errors.Is(err, context.Canceled) {
+			breaker.done(false, time.Now())
+		}
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	breaker.done(resp.StatusCode < http.StatusInternalServerError, time.Now())
+
+	var authInfo SSOAuthInfo
+	if err := json.NewDecoder(resp.Body).Decode(&authInfo); err != nil {
+		logrus.Errorf("decode error %v", err)
+		return resp.StatusCode, nil, errSSODecode
+	}
+
+	if cacheKey != "" && resp.StatusCode == http.StatusOK && !authInfo.SetCookie {
+		if v, err := json.Marshal(&authInfo); err == nil {
+			sys_cache.Put(cacheKey, v, conf.AppConfigInstance.SSOCacheTTL)
+		}
+	}
+	return resp.StatusCode, &authInfo, nil
+}
@@ -0,0 +1,181 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"vvorker/conf"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSSOBreaker(t *testing.T) {
+	type step struct {
+		at   time.Duration
+		op   string // allow / ok / fail
+		want bool   // allow 的期望结果
+	}
+	tests := []struct {
+		name      string
+		threshold int
+		steps     []step
+	}{
+		{
+			name:      "disabled",
+			threshold: 0,
+			steps: []step{
+				{op: "fail"}, {op: "fail"}, {op: "fail"},
+				{op: "allow", want: true},
+			},
+		},
+		{
+			name:      "below threshold",
+			threshold: 3,
+			steps: []step{
+				{op: "fail"}, {op: "fail"},
+				{op: "allow", want: true},
+			},
+		},
+		{
+			name:      "open after threshold",
+			threshold: 3,
+			steps: []step{
+				{op: "fail"}, {op: "fail"}, {op: "fail"},
+				{at: time.Second, op: "allow", want: false},
+				{at: 9 * time.Second, op: "allow", want: false},
+			},
+		},
+		{
+			name:      "success resets failures",
+			threshold: 3,
+			steps: []step{
+				{op: "fail"}, {op: "fail"}, {op: "ok"}, {op: "fail"}, {op: "fail"},
+				{op: "allow", want: true},
+			},
+		},
+		{
+			name:      "single probe after cooldown",
+			threshold: 3,
+			steps: []step{
+				{op: "fail"}, {op: "fail"}, {op: "fail"},
+				{at: 10 * time.Second, op: "allow", want: true},
+				{at: 11 * time.Second, op: "allow", want: false},
+			},
+		},
+		{
+			name:      "failed probe reopens",
+			threshold: 3,
+			steps: []step{
+				{op: "fail"}, {op: "fail"}, {op: "fail"},
+				{at: 10 * time.Second, op: "allow", want: true},
+				{at: 12 * time.Second, op: "fail"},
+				{at: 21 * time.Second, op: "allow", want: false},
+				{at: 22 * time.Second, op: "allow", want: true},
+			},
+		},
+		{
+			name:      "successful probe closes",
+			threshold: 3,
+			steps: []step{
+				{op: "fail"}, {op: "fail"}, {op: "fail"},
+				{at: 10 * time.Second, op: "allow", want: true},
+				{at: 11 * time.Second, op: "ok"},
+				{at: 11 * time.Second, op: "allow", want: true},
+				{at: 11 * time.Second, op: "fail"},
+				{at: 11 * time.Second, op: "allow", want: true},
+			},
+		},
+	}
+	threshold, cooldown := conf.AppConfigInstance.SSOBreakerThreshold, conf.AppConfigInstance.SSOBreakerCooldown
+	t.Cleanup(func() {
+		conf.AppConfigInstance.SSOBreakerThreshold, conf.AppConfigInstance.SSOBreakerCooldown = threshold, cooldown
+	})
+	conf.AppConfigInstance.SSOBreakerCooldown = 10
+	start := time.Now()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf.AppConfigInstance.SSOBreakerThreshold = tt.threshold
+			b := &ssoBreaker{}
+			for i, s := range tt.steps {
+				now := start.Add(s.at)
+				switch s.op {
+				case "allow":
+					if got := b.allow(now); got != s.want {
+						t.Fatalf("step %d: allow() at +%v = %v, want %v", i, s.at, got, s.want)
+					}
+				case "ok":
+					b.done(true, now)
+				case "fail":
+					b.done(false, now)
+				}
+			}
+		})
+	}
+}
+
+func TestSSOCacheKey(t *testing.T) {
+	base := ssoCacheKey("cookie", "data", "worker", "channel", "/a?x=1")
+	if base != ssoCacheKey("cookie", "data", "worker", "channel", "/a?x=1") {
+		t.Fatal("cache key is not stable")
+	}
+	for name, key := range map[string]string{
+		"path":    ssoCacheKey("cookie", "data", "worker", "channel", "/b?x=1"),
+		"query":   ssoCacheKey("cookie", "data", "worker", "channel", "/a?x=2"),
+		"channel": ssoCacheKey("cookie", "data", "worker", "other", "/a?x=1"),
+		"cookie":  ssoCacheKey("other", "data", "worker", "channel", "/a?x=1"),
+	} {
+		if key == base {
+			t.Errorf("cache key ignores %s", name)
+		}
+	}
+}
+
+func TestSSOAuthorizeBreakerFailures(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte(`{}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	origin := breaker
+	authURL, ttl, threshold, cooldown := conf.AppConfigInstance.SSOAuthURL, conf.AppConfigInstance.SSOCacheTTL,
+		conf.AppConfigInstance.SSOBreakerThreshold, conf.AppConfigInstance.SSOBreakerCooldown
+	t.Cleanup(func() {
+		breaker = origin
+		conf.AppConfigInstance.SSOAuthURL, conf.AppConfigInstance.SSOCacheTTL,
+			conf.AppConfigInstance.SSOBreakerThreshold, conf.AppConfigInstance.SSOBreakerCooldown = authURL, ttl, threshold, cooldown
+	})
+	conf.AppConfigInstance.SSOAuthURL = srv.URL
+	conf.AppConfigInstance.SSOCacheTTL = 0
+	conf.AppConfigInstance.SSOBreakerThreshold = 1
+	conf.AppConfigInstance.SSOBreakerCooldown = 60
+
+	tests := []struct {
+		name     string
+		canceled bool
+		wantOpen bool
+	}{
+		{name: "client canceled", canceled: true, wantOpen: false},
+		{name: "server error", wantOpen: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			breaker = &ssoBreaker{}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.canceled {
+				cancel()
+			}
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodGet, "/path", nil).WithContext(ctx)
+
+			ssoAuthorize(c, "", "worker", "worker")
+			if open := !breaker.allow(time.Now()); open != tt.wantOpen {
+				t.Errorf("breaker open = %v, want %v", open, tt.wantOpen)
+			}
+		})
+	}
+}
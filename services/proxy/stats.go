@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"sort"
+	"strconv"
+	"time"
+	"vvorker/models"
+	"vvorker/utils/database"
+)
+
+// 单次查询允许的最大分组数，防止 interval 过小导致内存暴涨
+const maxRequestStatsBuckets = 2000
+
+// requestStats 聚合一段时间内的请求，统计在 Go 中完成，以兼容 sqlite / mysql / pgsql
+type requestStats struct {
+	total       int
+	success     int
+	failed      int
+	statusClass map[string]int
+	durations   []int64
+}
+
+func newRequestStats() *requestStats {
+	return &requestStats{statusClass: map[string]int{}}
+}
+
+func (s *requestStats) add(status int, durationMS int64) {
+	s.total++
+	if status >= 200 && status < 300 {
+		s.success++
+	}
+	if status >= 400 {
+		s.failed++
+	}
+	s.statusClass[statusClassOf(status)]++
+	s.durations = append(s.durations, durationMS)
+}
+
+// latency 返回 p50 / p95 / p99 延迟（毫秒）
+func (s *requestStats) latency() (int64, int64, int64) {
+	if len(s.durations) == 0 {
+		return 0, 0, 0
+	}
+	sort.Slice(s.durations, func(i, j int) bool { return s.durations[i] < s.durations[j] })
+	return percentile(s.durations, 50), percentile(s.durations, 95), percentile(s.durations, 99)
+}
+
+// statusClassOf 将状态码归类为 1xx ~ 5xx
+func statusClassOf(status int) string {
+	if status < 100 || status > 599 {
+		return "other"
+	}
+	return strconv.Itoa(status/100) + "xx"
+}
+
+// percentile 使用最近秩法计算百分位，sorted 需已升序排列
+func percentile(sorted []int64, p int) int64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// scanResponseLogs 逐行读取时间窗口内的请求日志，不依赖任何数据库特有的时间函数
+func scanResponseLogs(workerUID string, startTime, endTime time.Time, fn func(log *models.ResponseLog)) error {
+	db := database.GetDB()
+	rows, err := db.Model(&models.ResponseLog{}).
+		Select("time", "status", "duration_ms").
+		Where("worker_uid = ? AND time BETWEEN ? AND ?", workerUID, startTime, endTime).
+		Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var log models.ResponseLog
+		if err := db.ScanRows(rows, &log); err != nil {
+			return err
+		}
+		fn(&log)
+	}
+	return rows.Err()
+}
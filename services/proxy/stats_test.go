@@ -0,0 +1,99 @@
+package proxy
+
+import "testing"
+
+func TestStatusClassOf(t *testing.T) {
+	tests := []struct {
+		status int
+		want   string
+	}{
+		{status: 0, want: "other"},
+		{status: 99, want: "other"},
+		{status: 100, want: "1xx"},
+		{status: 200, want: "2xx"},
+		{status: 204, want: "2xx"},
+		{status: 301, want: "3xx"},
+		{status: 404, want: "4xx"},
+		{status: 499, want: "4xx"},
+		{status: 500, want: "5xx"},
+		{status: 599, want: "5xx"},
+		{status: 600, want: "other"},
+		{status: -1, want: "other"},
+	}
+	for _, tt := range tests {
+		if got := statusClassOf(tt.status); got != tt.want {
+			t.Errorf("statusClassOf(%d) = %q, want %q", tt.status, got, tt.want)
+		}
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	seq := func(n int) []int64 {
+		s := make([]int64, n)
+		for i := range s {
+			s[i] = int64(i + 1)
+		}
+		return s
+	}
+	tests := []struct {
+		name   string
+		sorted []int64
+		p      int
+		want   int64
+	}{
+		{name: "empty", sorted: nil, p: 50, want: 0},
+		{name: "empty p100", sorted: []int64{}, p: 100, want: 0},
+		{name: "single p0", sorted: []int64{7}, p: 0, want: 7},
+		{name: "single p50", sorted: []int64{7}, p: 50, want: 7},
+		{name: "single p100", sorted: []int64{7}, p: 100, want: 7},
+		{name: "p0 is min", sorted: seq(10), p: 0, want: 1},
+		{name: "p100 is max", sorted: seq(10), p: 100, want: 10},
+		// 最近秩法向上取整：ceil(p * n / 100)
+		{name: "two p50", sorted: []int64{10, 20}, p: 50, want: 10},
+		{name: "two p51", sorted: []int64{10, 20}, p: 51, want: 20},
+		{name: "two p95", sorted: []int64{10, 20}, p: 95, want: 20},
+		{name: "three p50", sorted: []int64{10, 20, 30}, p: 50, want: 20},
+		{name: "three p34", sorted: []int64{10, 20, 30}, p: 34, want: 20},
+		{name: "three p33", sorted: []int64{10, 20, 30}, p: 33, want: 10},
+		{name: "ten p95", sorted: seq(10), p: 95, want: 10},
+		{name: "ten p90", sorted: seq(10), p: 90, want: 9},
+		{name: "twenty p95", sorted: seq(20), p: 95, want: 19},
+		{name: "hundred p99", sorted: seq(100), p: 99, want: 99},
+		{name: "hundred one p99", sorted: seq(101), p: 99, want: 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := percentile(tt.sorted, tt.p); got != tt.want {
+				t.Errorf("percentile(%v, %d) = %d, want %d", tt.sorted, tt.p, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestStats(t *testing.T) {
+	s := newRequestStats()
+	if p50, p95, p99 := s.latency(); p50 != 0 || p95 != 0 || p99 != 0 {
+		t.Errorf("empty latency = %d, %d, %d", p50, p95, p99)
+	}
+
+	for _, r := range []struct {
+		status   int
+		duration int64
+	}{
+		{200, 30}, {201, 10}, {304, 20}, {404, 50}, {502, 40},
+	} {
+		s.add(r.status, r.duration)
+	}
+	if s.total != 5 || s.success != 2 || s.failed != 2 {
+		t.Errorf("total %d, success %d, failed %d", s.total, s.success, s.failed)
+	}
+	for class, want := range map[string]int{"2xx": 2, "3xx": 1, "4xx": 1, "5xx": 1} {
+		if s.statusClass[class] != want {
+			t.Errorf("status class %s = %d, want %d", class, s.statusClass[class], want)
+		}
+	}
+	// 添加的顺序不影响结果
+	if p50, p95, p99 := s.latency(); p50 != 30 || p95 != 50 || p99 != 50 {
+		t.Errorf("latency = %d, %d, %d, want 30, 50, 50", p50, p95, p99)
+	}
+}
@@ -3,6 +3,7 @@ package task
 import (
 	"time"
 	"vvorker/common"
+	"vvorker/defs"
 	"vvorker/models"
 	"vvorker/utils/database"
 
@@ -13,6 +14,19 @@ func init() {
 
 }
 
+// checkBindingWorker task 绑定只能访问资源凭证对应 worker 的任务，其他方式调用时不检查
+func checkBindingWorker(c *gin.Context, workerUID string) bool {
+	v, exists := c.Get(defs.KeyWorkerResource)
+	if !exists {
+		return true
+	}
+	if res, ok := v.(*models.WorkerResource); !ok || res.WorkerUID != workerUID {
+		common.RespErr(c, 403, "error", gin.H{"error": "No permission"})
+		return false
+	}
+	return true
+}
+
 type CreateTaskReq struct {
 	WorkerUID string `json:"worker_uid" binding:"required"`
 	TraceID   string `json:"trace_id" binding:"required"`
@@ -25,6 +39,9 @@ func CreateTaskEndpoint(c *gin.Context) {
 	if err := c.BindJSON(&req); err != nil {
 		return
 	}
+	if !checkBindingWorker(c, req.WorkerUID) {
+		return
+	}
 
 	db := database.GetDB()
 	// 检查traceid是否存在
@@ -62,10 +79,14 @@ func CheckInterruptTaskEndpoint(c *gin.Context) {
 	if err := c.BindJSON(&req); err != nil {
 		return
 	}
+	if !checkBindingWorker(c, req.WorkerUID) {
+		return
+	}
 	db := database.GetDB()
 	var tt models.Task
 	if err := db.Where(&models.Task{
-		TraceID: req.TraceID,
+		TraceID:   req.TraceID,
+		WorkerUID: req.WorkerUID,
 	}).First(&tt).Error; err != nil {
 		common.RespErr(c, 500, "error", gin.H{"error": "Internal server error"})
 		return
@@ -86,16 +107,24 @@ func LogTaskEndpoint(c *gin.Context) {
 	if err := c.BindJSON(&req); err != nil {
 		return
 	}
+	if !checkBindingWorker(c, req.WorkerUID) {
+		return
+	}
 
 	db := database.GetDB()
-	// 检查traceid是否存在
+	// 检查任务是否属于该 worker
 	var count int64
-	if err := db.Model(&models.TaskLog{}).Where(&models.TaskLog{
-		TraceID: req.TraceID,
+	if err := db.Model(&models.Task{}).Where(&models.Task{
+		TraceID:   req.TraceID,
+		WorkerUID: req.WorkerUID,
 	}).Limit(1).Count(&count).Error; err != nil {
 		common.RespErr(c, 500, "error", gin.H{"error": "Internal server error"})
 		return
 	}
+	if count == 0 {
+		common.RespErr(c, 404, "error", gin.H{"error": "Task not found"})
+		return
+	}
 
 	// 插入
 	if err := db.Create(&models.TaskLog{
@@ -154,12 +183,16 @@ func CompleteTaskEndpoint(c *gin.Context) {
 	if err := c.BindJSON(&req); err != nil {
 		return
 	}
+	if !checkBindingWorker(c, req.WorkerUID) {
+		return
+	}
 
 	db := database.GetDB()
 
 	var tt models.Task
 	if err := db.Where(&models.Task{
-		TraceID: req.TraceID,
+		TraceID:   req.TraceID,
+		WorkerUID: req.WorkerUID,
 	}).First(&tt).Error; err != nil {
 		common.RespErr(c, 500, "error", gin.H{"error": "Internal server error"})
 		return
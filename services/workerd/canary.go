@@ -0,0 +1,222 @@
+package workerd
+
+import (
+	"vvorker/common"
+	"vvorker/entities"
+	"vvorker/models"
+	permissions "vvorker/utils/permissions"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+var flushCanary = (*models.Worker).FlushCanary
+
+type GetWorkerCanaryReq struct {
+	WorkerUID string `json:"worker_uid" binding:"required"`
+}
+
+func GetWorkerCanaryEndpoint(c *gin.Context) {
+	var req GetWorkerCanaryReq
+	if err := c.BindJSON(&req); err != nil {
+		return
+	}
+
+	userID, ok := common.RequireUID(c)
+	if !ok {
+		return
+	}
+	if _, err := permissions.CanReadWorker(c, userID, req.WorkerUID); err != nil {
+		// CanReadWorker 内部已经调用了 RespErr
+		return
+	}
+
+	canary, err := models.GetWorkerCanary(req.WorkerUID)
+	if err != nil {
+		common.RespOK(c, "no canary", nil)
+		return
+	}
+	common.RespOK(c, "get worker canary success", canary)
+}
+
+type StartWorkerCanaryReq struct {
+	WorkerUID   string `json:"worker_uid" binding:"required"`
+	VersionUID  string `json:"version_uid" binding:"required"`
+	Percent     int    `json:"percent"`
+	Header      string `json:"header"`
+	HeaderValue string `json:"header_value"`
+	Cookie      string `json:"cookie"`
+	CookieValue string `json:"cookie_value"`
+	MaxCount    int32  `json:"max_count"`
+}
+
+// StartWorkerCanaryEndpoint 创建或调整灰度发布，只改分流规则时不会重启灰度副本
+func StartWorkerCanaryEndpoint(c *gin.Context) {
+	var req StartWorkerCanaryReq
+	if err := c.BindJSON(&req); err != nil {
+		return
+	}
+	if req.Percent < 0 || req.Percent > 100 {
+		common.RespErr(c, common.RespCodeInvalidParams, "percent must be between 0 and 100", nil)
+		return
+	}
+	if req.MaxCount <= 0 {
+		req.MaxCount = 1
+	}
+
+	userID, ok := common.RequireUID(c)
+	if !ok {
+		return
+	}
+	worker, err := permissions.CanWriteWorker(c, userID, req.WorkerUID)
+	if err != nil {
+		return
+	}
+	if worker.ActiveVersionID == req.VersionUID {
+		common.RespErr(c, common.RespCodeInvalidRequest, "version is already active", nil)
+		return
+	}
+	if _, err := models.GetWorkerVersion(req.WorkerUID, req.VersionUID); err != nil {
+		common.RespErr(c, common.RespCodeNotFound, "version not found", nil)
+		return
+	}
+
+	old, err := models.GetWorkerCanary(req.WorkerUID)
+	needFlush := err != nil || old.VersionID != req.VersionUID || old.MaxCount != req.MaxCount
+
+	canary := &models.WorkerCanary{
+		WorkerUID:   req.WorkerUID,
+		VersionID:   req.VersionUID,
+		Percent:     req.Percent,
+		Header:      req.Header,
+		HeaderValue: req.HeaderValue,
+		Cookie:      req.Cookie,
+		CookieValue: req.CookieValue,
+		MaxCount:    req.MaxCount,
+	}
+	if err := models.SaveWorkerCanary(canary); err != nil {
+		common.RespErr(c, common.RespCodeInternalError, err.Error(), nil)
+		return
+	}
+	if needFlush {
+		if err := worker.FlushCanary(); err != nil {
+			common.RespErr(c, common.RespCodeInternalError, err.Error(), nil)
+			return
+		}
+	}
+	common.RespOK(c, "start worker canary success", canary)
+}
+
+type WorkerCanaryActionReq struct {
+	WorkerUID string `json:"worker_uid" binding:"required"`
+}
+
+// PromoteWorkerCanaryEndpoint 将灰度版本切换为当前版本
+func PromoteWorkerCanaryEndpoint(c *gin.Context) {
+	var req WorkerCanaryActionReq
+	if err := c.BindJSON(&req); err != nil {
+		return
+	}
+
+	userID, ok := common.RequireUID(c)
+	if !ok {
+		return
+	}
+	oldworker, err := permissions.CanWriteWorker(c, userID, req.WorkerUID)
+	if err != nil {
+		return
+	}
+	canary, err := models.GetWorkerCanary(req.WorkerUID)
+	if err != nil {
+		common.RespErr(c, common.RespCodeNotFound, "canary not found", nil)
+		return
+	}
+	version, err := models.GetWorkerVersion(req.WorkerUID, canary.VersionID)
+	if err != nil {
+		common.RespErr(c, common.RespCodeNotFound, "version not found", nil)
+		return
+	}
+
+	worker, traceID, err := promoteWorkerCanary(oldworker, canary, version)
+	if err != nil {
+		common.RespErr(c, common.RespCodeInternalError, err.Error(), nil)
+		return
+	}
+
+	common.RespOK(c, "promote worker canary success", gin.H{
+		"version":    worker.Version,
+		"version_id": version.UID,
+		"task_id":    traceID,
+	})
+}
+
+// promoteWorkerCanary 先把全部流量切到灰度副本，当前版本部署成功后再删除灰度配置并下线灰度副本
+// 部署失败时恢复原来的分流规则，灰度副本继续运行
+func promoteWorkerCanary(oldworker *models.Worker, canary *models.WorkerCanary, version *models.WorkerVersion) (*entities.Worker, string, error) {
+	origin := *canary
+	promoted := *canary
+	promoted.Percent = 100
+	promoted.Header = ""
+	promoted.Cookie = ""
+	if err := models.SaveWorkerCanary(&promoted); err != nil {
+		return nil, "", err
+	}
+
+	worker, traceID, err := switchWorkerVersion(oldworker, version)
+	if err != nil {
+		if err := models.SaveWorkerCanary(&origin); err != nil {
+			logrus.WithError(err).Errorf("restore worker %s canary error", oldworker.UID)
+		}
+		return nil, traceID, err
+	}
+
+	if err := models.DeleteWorkerCanary(worker.UID); err != nil {
+		return nil, traceID, err
+	}
+	if err := flushCanary(&models.Worker{Worker: worker}); err != nil {
+		return nil, traceID, err
+	}
+	return worker, traceID, nil
+}
+
+// AbortWorkerCanaryEndpoint 放弃灰度发布，流量立即回到当前版本，随后下线灰度副本
+func AbortWorkerCanaryEndpoint(c *gin.Context) {
+	var req WorkerCanaryActionReq
+	if err := c.BindJSON(&req); err != nil {
+		return
+	}
+
+	userID, ok := common.RequireUID(c)
+	if !ok {
+		return
+	}
+	worker, err := permissions.CanWriteWorker(c, userID, req.WorkerUID)
+	if err != nil {
+		return
+	}
+
+	if err := models.DeleteWorkerCanary(req.WorkerUID); err != nil {
+		common.RespErr(c, common.RespCodeInternalError, err.Error(), nil)
+		return
+	}
+	if err := worker.FlushCanary(); err != nil {
+		common.RespErr(c, common.RespCodeInternalError, err.Error(), nil)
+		return
+	}
+	common.RespOK(c, "abort worker canary success", nil)
+}
+
+// AgentGetWorkerCanaryEndpoint 节点获取灰度版本，没有灰度配置时 data 为空
+func AgentGetWorkerCanaryEndpoint(c *gin.Context) {
+	var req models.AgentWorkerCanaryReq
+	if err := c.BindJSON(&req); err != nil {
+		common.RespErr(c, common.RespCodeInvalidRequest, err.Error(), nil)
+		return
+	}
+	spec, err := models.LoadWorkerCanarySpec(req.UID)
+	if err != nil {
+		common.RespErr(c, common.RespCodeInternalError, err.Error(), nil)
+		return
+	}
+	common.RespOK(c, common.RespMsgOK, spec)
+}
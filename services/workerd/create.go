@@ -14,6 +14,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
 )
 
 func CreateEndpoint(c *gin.Context) {
@@ -49,6 +50,7 @@ func Create(userID uint, worker *entities.Worker) (string, error) {
 	worker.Version = utils.GenerateUID()
 
 	code := worker.Code
+
 	if conf.AppConfigInstance.FileStorageUseOSS {
 		err := funcs.UploadFileToSysBucket(fmt.Sprintf("code/%s", worker.GetUID()), bytes.NewReader(code))
 		if err != nil {
@@ -58,7 +60,7 @@ func Create(userID uint, worker *entities.Worker) (string, error) {
 		worker.Code = nil
 	}
 
-	if err := (&models.Worker{Worker: worker}).Create(); err != nil {
+	if err := createWorker(&models.Worker{Worker: worker}, code, "", true); err != nil {
 		logrus.Errorf("failed to create worker, err: %v", err)
 		return "", err
 	}
@@ -71,6 +73,21 @@ func Create(userID uint, worker *entities.Worker) (string, error) {
 	return worker.GetUID(), nil
 }
 
+// createWorker 在同一个事务中保存 worker 与版本快照，任一失败时都不会留下记录
+// newVersion 为 false 时沿用 worker 上已有的版本（回滚）
+func createWorker(worker *models.Worker, code []byte, desc string, newVersion bool) error {
+	return database.GetDB().Transaction(func(tx *gorm.DB) error {
+		if newVersion {
+			version, err := models.CreateWorkerVersion(tx, worker.Worker, code, desc)
+			if err != nil {
+				return err
+			}
+			worker.ActiveVersionID = version.UID
+		}
+		return worker.CreateTx(tx)
+	})
+}
+
 func Recover(userID uint, worker *entities.Worker) error {
 	db := database.GetDB()
 	worker.UserID = uint64(userID)
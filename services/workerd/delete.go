@@ -10,6 +10,7 @@ import (
 	permissions "vvorker/utils/permissions"
 
 	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
 )
 
 func DeleteEndpoint(c *gin.Context) {
@@ -50,6 +51,14 @@ func Delete(userID uint, UID string) error {
 		return err
 	}
 
+	// 灰度副本不随 worker 更新而停止，删除 worker 时需要单独清理
+	if err := models.DeleteWorkerCanary(UID); err != nil {
+		return err
+	}
+	if err := worker.FlushCanary(); err != nil {
+		logrus.WithError(err).Warnf("flush worker %s canary error", UID)
+	}
+
 	if worker.NodeName == conf.AppConfigInstance.NodeName {
 		exec.ExecManager.ExitCmd(worker.GetUID())
 	}
@@ -57,5 +66,5 @@ func Delete(userID uint, UID string) error {
 		return err
 	}
 
-	return nil
+	return models.DeleteWorkerVersions(UID)
 }
@@ -283,6 +283,15 @@ func FillWorkerConfig(c *gin.Context) {
 		common.RespErr(c, defs.CodeInternalError, con.Error.Error(), nil)
 		return
 	}
+	// 灰度副本使用灰度版本的模板
+	if len(req.VersionID) != 0 && req.VersionID != worker.ActiveVersionID {
+		version, err := models.GetWorkerVersion(worker.UID, req.VersionID)
+		if err != nil {
+			common.RespErr(c, defs.CodeInternalError, err.Error(), nil)
+			return
+		}
+		worker.Template = version.Template
+	}
 	newTemplate := FinishWorkerConfig(worker)
 
 	common.RespOK(c, "fill worker config success", &entities.AgentFillWorkerResp{
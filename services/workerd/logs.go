@@ -1,18 +1,31 @@
 package workerd
 
 import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
 	"vvorker/common"
 	"vvorker/exec"
 	"vvorker/utils/database"
 	permissions "vvorker/utils/permissions"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 // GetWorkerLogsReq 定义获取工作者日志请求结构体
 type GetWorkerLogsReq struct {
-	Page     int `json:"page" binding:"gte=1"`      // 页码，从 1 开始
-	PageSize int `json:"page_size" binding:"gte=1"` // 每页记录数
+	Page      int        `json:"page" binding:"gte=1"`      // 页码，从 1 开始
+	PageSize  int        `json:"page_size" binding:"gte=1"` // 每页记录数
+	Keyword   string     `json:"keyword"`                   // 在日志内容中搜索，不区分大小写
+	Types     []string   `json:"types"`                     // 按日志类型过滤，如 stdout stderr
+	StartTime *time.Time `json:"start_time"`                // 时间范围
+	EndTime   *time.Time `json:"end_time"`
 }
 
 // 定义返回结构体
@@ -56,15 +69,16 @@ func GetWorkerLogsEndpoint(c *gin.Context) {
 	}
 
 	db := database.GetDB()
+	query := filterWorkerLogs(db.Model(&exec.WorkerLog{}), UID, req.Keyword, req.Types, req.StartTime, req.EndTime)
 	var logs []*exec.WorkerLog
 	var total int64
 	// 先查询日志总数
-	if err := db.Model(&exec.WorkerLog{}).Where("uid = ?", UID).Limit(10000).Count(&total).Error; err != nil {
+	if err := query.Session(&gorm.Session{}).Limit(10000).Count(&total).Error; err != nil {
 		common.RespErr(c, common.RespCodeInternalError, err.Error(), nil)
 		return
 	}
 	// 使用计算出的 offset 和 page_size 进行查询
-	if err := db.Where("uid = ?", UID).Offset(offset).Limit(req.PageSize).Order("time desc").Find(&logs).Error; err != nil {
+	if err := query.Session(&gorm.Session{}).Offset(offset).Limit(req.PageSize).Order("time desc").Find(&logs).Error; err != nil {
 		common.RespErr(c, common.RespCodeInternalError, err.Error(), nil)
 		return
 	}
@@ -80,3 +94,223 @@ func GetWorkerLogsEndpoint(c *gin.Context) {
 	}
 	common.RespOK(c, "get worker logs success", resp)
 }
+
+// filterWorkerLogs 根据关键字、类型和时间范围构造日志查询条件
+func filterWorkerLogs(tx *gorm.DB, uid string, keyword string, types []string, startTime, endTime *time.Time) *gorm.DB {
+	tx = tx.Where("uid = ?", uid)
+	if keyword != "" {
+		tx = tx.Where("LOWER(output) LIKE ?", "%"+strings.ToLower(keyword)+"%")
+	}
+	if len(types) > 0 {
+		tx = tx.Where("type IN ?", types)
+	}
+	if startTime != nil {
+		tx = tx.Where("time >= ?", *startTime)
+	}
+	if endTime != nil {
+		tx = tx.Where("time <= ?", *endTime)
+	}
+	return tx
+}
+
+// 单次导出的最大条数
+const exportLogsLimit = 1000000
+
+// ExportWorkerLogsEndpoint 导出 worker 在一段时间内的日志
+// query 参数：start_time、end_time（RFC3339，必填），format（ndjson 或 csv，默认 ndjson），type（逗号分隔），keyword
+func ExportWorkerLogsEndpoint(c *gin.Context) {
+	UID := c.Param("uid")
+	if len(UID) == 0 {
+		common.RespErr(c, common.RespCodeInvalidRequest, "uid is empty", nil)
+		return
+	}
+
+	userID, ok := common.RequireUID(c)
+	if !ok {
+		return
+	}
+	// 检查用户是否有权限访问 Worker（拥有者或协作者）
+	if _, err := permissions.CanReadWorker(c, uint64(userID), UID); err != nil {
+		// CanReadWorker 内部已经调用了 RespErr
+		return
+	}
+
+	startTime, err := time.Parse(time.RFC3339, c.Query("start_time"))
+	if err != nil {
+		common.RespErr(c, common.RespCodeInvalidParams, "invalid start_time, should be RFC3339", nil)
+		return
+	}
+	endTime, err := time.Parse(time.RFC3339, c.Query("end_time"))
+	if err != nil {
+		common.RespErr(c, common.RespCodeInvalidParams, "invalid end_time, should be RFC3339", nil)
+		return
+	}
+	format := c.DefaultQuery("format", "ndjson")
+	if format != "ndjson" && format != "csv" {
+		common.RespErr(c, common.RespCodeInvalidParams, "format should be ndjson or csv", nil)
+		return
+	}
+	var types []string
+	for _, t := range strings.Split(c.Query("type"), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			types = append(types, t)
+		}
+	}
+
+	db := database.GetDB()
+	rows, err := filterWorkerLogs(db.Model(&exec.WorkerLog{}), UID, c.Query("keyword"), types, &startTime, &endTime).
+		Order("time asc").Limit(exportLogsLimit).Rows()
+	if err != nil {
+		common.RespErr(c, common.RespCodeInternalError, err.Error(), nil)
+		return
+	}
+	defer rows.Close()
+
+	filename := fmt.Sprintf("%s-logs-%s.%s", UID, startTime.Format("20060102150405"), format)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	var csvWriter *csv.Writer
+	var encoder *json.Encoder
+	if format == "csv" {
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		csvWriter = csv.NewWriter(c.Writer)
+		csvWriter.Write([]string{"time", "local_id", "type", "level", "output", "log_uid"})
+	} else {
+		c.Header("Content-Type", "application/x-ndjson")
+		encoder = json.NewEncoder(c.Writer)
+	}
+	c.Status(http.StatusOK)
+
+	// 逐行读取并写出，避免一次加载全部日志
+	for rows.Next() {
+		var log exec.WorkerLog
+		if err := db.ScanRows(rows, &log); err != nil || log.WorkerLogData == nil {
+			continue
+		}
+		if csvWriter != nil {
+			csvWriter.Write([]string{
+				log.Time.Format(time.RFC3339Nano),
+				strconv.Itoa(int(log.LocalID)),
+				log.Type,
+				log.Level,
+				log.Output,
+				log.LogUID,
+			})
+		} else if err := encoder.Encode(log.WorkerLogData); err != nil {
+			return
+		}
+	}
+	if csvWriter != nil {
+		csvWriter.Flush()
+	}
+}
+
+// 实时日志在 since 之后补发的历史日志上限
+const tailBacklogLimit = 1000
+
+// workerLogFilter 实时日志的过滤条件
+type workerLogFilter struct {
+	types map[string]bool
+	text  string
+	since time.Time
+}
+
+func (f *workerLogFilter) match(log *exec.WorkerLogData) bool {
+	if len(f.types) > 0 && !f.types[log.Type] {
+		return false
+	}
+	if f.text != "" && !strings.Contains(strings.ToLower(log.Output), f.text) {
+		return false
+	}
+	if !f.since.IsZero() && log.Time.Before(f.since) {
+		return false
+	}
+	return true
+}
+
+// TailWorkerLogsEndpoint 以 SSE 推送 worker 的实时日志
+// 支持 query 参数：type（逗号分隔，如 stdout,stderr）、text（包含的文本）、since（RFC3339 时间，会先补发该时间之后的历史日志）
+func TailWorkerLogsEndpoint(c *gin.Context) {
+	UID := c.Param("uid")
+	if len(UID) == 0 {
+		common.RespErr(c, common.RespCodeInvalidRequest, "uid is empty", nil)
+		return
+	}
+
+	userID, ok := common.RequireUID(c)
+	if !ok {
+		return
+	}
+	// 检查用户是否有权限访问 Worker（拥有者或协作者）
+	if _, err := permissions.CanReadWorker(c, uint64(userID), UID); err != nil {
+		// CanReadWorker 内部已经调用了 RespErr
+		return
+	}
+
+	filter := &workerLogFilter{
+		types: map[string]bool{},
+		text:  strings.ToLower(c.Query("text")),
+	}
+	for _, t := range strings.Split(c.Query("type"), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			filter.types[t] = true
+		}
+	}
+	if since := c.Query("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			common.RespErr(c, common.RespCodeInvalidParams, "invalid since, should be RFC3339", nil)
+			return
+		}
+		filter.since = t
+	}
+
+	// 日志在落库之后才会推送，先订阅再查询历史日志：订阅前落库的日志在历史中，订阅后落库的日志会被推送
+	logs, cancel := exec.SubscribeWorkerLogs(UID)
+	defer cancel()
+
+	var backlog []*exec.WorkerLog
+	if !filter.since.IsZero() {
+		db := database.GetDB()
+		if err := db.Where("uid = ? AND time >= ?", UID, filter.since).
+			Order("time asc").Limit(tailBacklogLimit).Find(&backlog).Error; err != nil {
+			common.RespErr(c, common.RespCodeInternalError, err.Error(), nil)
+			return
+		}
+	}
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+
+	// 在订阅与查询之间落库的日志会同时出现在两边，按 log_uid 去重
+	sent := map[string]bool{}
+	for _, log := range backlog {
+		if log.WorkerLogData == nil || !filter.match(log.WorkerLogData) {
+			continue
+		}
+		sent[log.LogUID] = true
+		c.SSEvent("log", log.WorkerLogData)
+	}
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(15 * time.Second)
+	defer heartbeat.Stop()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case <-heartbeat.C:
+			c.SSEvent("ping", time.Now().Unix())
+			return true
+		case log := <-logs:
+			if sent[log.LogUID] {
+				delete(sent, log.LogUID)
+				return true
+			}
+			if filter.match(log) {
+				c.SSEvent("log", log)
+			}
+			return true
+		}
+	})
+}
@@ -0,0 +1,35 @@
+package workerd
+
+import (
+	"errors"
+	"vvorker/common"
+	"vvorker/conf"
+	"vvorker/models"
+	"vvorker/utils/secret"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AgentGetWorkerResourceEndpoint 节点校验资源凭证，没有权限时 data 为空
+func AgentGetWorkerResourceEndpoint(c *gin.Context) {
+	var req models.AgentWorkerResourceReq
+	if err := c.BindJSON(&req); err != nil {
+		common.RespErr(c, common.RespCodeInvalidRequest, err.Error(), nil)
+		return
+	}
+	scope, err := secret.ParseResourceToken(conf.AppConfigInstance.AgentSecret, req.Token)
+	if err != nil {
+		common.RespOK(c, common.RespMsgOK, nil)
+		return
+	}
+	res, err := models.LoadWorkerResource(scope)
+	if errors.Is(err, models.ErrResourceNotAllowed) {
+		common.RespOK(c, common.RespMsgOK, nil)
+		return
+	}
+	if err != nil {
+		common.RespErr(c, common.RespCodeInternalError, err.Error(), nil)
+		return
+	}
+	common.RespOK(c, common.RespMsgOK, res)
+}
@@ -32,7 +32,7 @@ func GetWorkersStatusByUIDEndpoint(c *gin.Context) {
 		}
 	}
 
-	status := make(map[string]int)
+	status := make(map[string]exec.WorkerStatus)
 	for _, uid := range req.UIDS {
 		status[uid] = exec.ExecManager.GetWorkerStatusByUID(uid)
 	}
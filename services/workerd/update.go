@@ -12,11 +12,13 @@ import (
 	"vvorker/funcs"
 	"vvorker/models"
 	"vvorker/utils"
+	"vvorker/utils/database"
 	"vvorker/utils/generate"
 	permissions "vvorker/utils/permissions"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
 )
 
 type UpdateWorkerReq struct {
@@ -24,6 +26,9 @@ type UpdateWorkerReq struct {
 	Description string `json:"Description"`
 }
 
+// flushWorker 部署 worker 并等待新副本就绪，测试中替换
+var flushWorker = (*models.Worker).Flush
+
 func UpdateWorker(userID uint, UID string, worker *entities.Worker, desc string) (string, error) {
 	FillWorkerValue(worker, true, UID, userID)
 
@@ -40,26 +45,15 @@ func UpdateWorker(userID uint, UID string, worker *entities.Worker, desc string)
 
 	curNodeName := conf.AppConfigInstance.NodeName
 
-	if workerRecord.NodeName == curNodeName {
-		exec.ExecManager.ExitCmd(workerRecord.GetUID())
-	}
-
-	// 删除旧的worker
-	err = workerRecord.Delete()
-	if err != nil {
-		if traceID != "" {
-			models.CompleteTask(traceID, "failed")
-		}
-		return traceID, err
-	}
-
-	// 创建新的worker
 	newWorker := &models.Worker{Worker: worker,
 		EnableAccessControl: workerRecord.EnableAccessControl,
 		Description:         desc,
 	}
 	newWorker.Version = utils.GenerateUID()
 	code := newWorker.Code
+
+	// 回滚时调用方会指定已有的版本，否则为本次更新创建新的版本快照，版本与 worker 一起保存
+	newVersion := len(worker.ActiveVersionID) == 0 || worker.ActiveVersionID == workerRecord.ActiveVersionID
 	if conf.AppConfigInstance.FileStorageUseOSS {
 		newWorker.Code = nil
 	}
@@ -74,7 +68,49 @@ func UpdateWorker(userID uint, UID string, worker *entities.Worker, desc string)
 		}
 	}
 
-	err = newWorker.Create()
+	// 节点不变时原地更新，由 Flush 以蓝绿方式替换副本，更新过程中不中断服务
+	if workerRecord.NodeName == worker.NodeName {
+		newWorker.Model = workerRecord.Model
+		err := database.GetDB().Transaction(func(tx *gorm.DB) error {
+			if newVersion {
+				version, err := models.CreateWorkerVersion(tx, worker, code, desc)
+				if err != nil {
+					return err
+				}
+				newWorker.ActiveVersionID = version.UID
+			}
+			return tx.Save(newWorker).Error
+		})
+		if err != nil {
+			if traceID != "" {
+				models.CompleteTask(traceID, "failed")
+			}
+			return traceID, err
+		}
+		if err := flushWorker(newWorker); err != nil {
+			// 新副本没有就绪时旧副本仍在运行旧代码，恢复 worker 记录，避免重启或同步时部署失败的版本
+			restoreWorker(workerRecord)
+			if traceID != "" {
+				models.CompleteTask(traceID, "failed")
+			}
+			return traceID, err
+		}
+		return traceID, nil
+	}
+
+	// 迁移到其他节点时删除旧的worker，在新节点上重新创建
+	if workerRecord.NodeName == curNodeName {
+		exec.ExecManager.ExitCmd(workerRecord.GetUID())
+	}
+	err = workerRecord.Delete()
+	if err != nil {
+		if traceID != "" {
+			models.CompleteTask(traceID, "failed")
+		}
+		return traceID, err
+	}
+
+	err = createWorker(newWorker, code, desc, newVersion)
 	if err != nil {
 		if traceID != "" {
 			models.CompleteTask(traceID, "failed")
@@ -95,6 +131,28 @@ func UpdateWorker(userID uint, UID string, worker *entities.Worker, desc string)
 	return traceID, nil
 }
 
+// restoreWorker 部署失败后恢复更新前的 worker 记录，代码保存在 OSS 时同时恢复当前版本的代码
+func restoreWorker(workerRecord *models.Worker) {
+	if err := database.GetDB().Save(workerRecord).Error; err != nil {
+		logrus.WithError(err).Errorf("restore worker %s error", workerRecord.UID)
+	}
+	if !conf.AppConfigInstance.FileStorageUseOSS || len(workerRecord.ActiveVersionID) == 0 {
+		return
+	}
+	version, err := models.GetWorkerVersion(workerRecord.UID, workerRecord.ActiveVersionID)
+	if err != nil {
+		logrus.WithError(err).Errorf("restore worker %s code error", workerRecord.UID)
+		return
+	}
+	code, err := version.GetCode()
+	if err == nil {
+		err = funcs.UploadFileToSysBucket(fmt.Sprintf("code/%s", workerRecord.GetUID()), bytes.NewReader(code))
+	}
+	if err != nil {
+		logrus.WithError(err).Errorf("restore worker %s code error", workerRecord.UID)
+	}
+}
+
 // 更新worker
 func UpdateEndpointJSON(c *gin.Context) {
 
@@ -113,6 +171,8 @@ func UpdateEndpointJSON(c *gin.Context) {
 		return
 	}
 
+	// 版本只能通过回滚接口切换
+	worker.Worker.ActiveVersionID = oldworker.ActiveVersionID
 	if worker.Worker.Code == nil {
 		worker.Worker.Code = oldworker.Code
 	}
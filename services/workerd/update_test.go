@@ -0,0 +1,261 @@
+package workerd
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"vvorker/conf"
+	"vvorker/defs"
+	"vvorker/entities"
+	"vvorker/ext/kv/src/sys_cache"
+	"vvorker/models"
+	"vvorker/utils/database"
+
+	"github.com/nutsdb/nutsdb"
+	"google.golang.org/protobuf/proto"
+)
+
+// TestMain 整个包共用一个临时的 sqlite，models 的 init 协程会在同一个库上创建默认节点
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "workerd-test")
+	if err != nil {
+		panic(err)
+	}
+	conf.AppConfigInstance.DBType = defs.DBTypeSqlite
+	conf.AppConfigInstance.DBPath = filepath.Join(dir, "db.sqlite")
+	conf.AppConfigInstance.FileStorageUseOSS = false
+	database.InitDB()
+	// 等待默认节点创建完成，避免与测试同时写库
+	for len(conf.AppConfigInstance.NodeID) == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := database.GetDB().AutoMigrate(&models.Worker{}, &models.WorkerVersion{},
+		&models.Task{}, &models.WorkerCanary{}); err != nil {
+		panic(err)
+	}
+	kv, err := nutsdb.Open(nutsdb.DefaultOptions, nutsdb.WithDir(filepath.Join(dir, "kv")))
+	if err != nil {
+		panic(err)
+	}
+	sys_cache.InitCache(kv)
+
+	code := m.Run()
+	kv.Close()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// setupWorkerdTest 部署由测试替换，测试结束后恢复
+func setupWorkerdTest(t *testing.T) {
+	t.Helper()
+	origin, originCanary := flushWorker, flushCanary
+	t.Cleanup(func() { flushWorker, flushCanary = origin, originCanary })
+}
+
+func createTestWorker(t *testing.T, uid string) *models.Worker {
+	t.Helper()
+	worker := &models.Worker{Worker: &entities.Worker{
+		UID:      uid,
+		UserID:   1,
+		Name:     uid,
+		NodeName: conf.AppConfigInstance.NodeName,
+		Entry:    defs.DefaultEntry,
+		Code:     []byte("old code"),
+		Template: `{"version":"1.0.0"}`,
+		MaxCount: 1,
+	}}
+	db := database.GetDB()
+	version, err := models.CreateWorkerVersion(db, worker.Worker, worker.Code, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	worker.ActiveVersionID = version.UID
+	if err := db.Create(worker).Error; err != nil {
+		t.Fatal(err)
+	}
+	return worker
+}
+
+func TestUpdateWorkerFlush(t *testing.T) {
+	setupWorkerdTest(t)
+
+	tests := []struct {
+		name     string
+		flushErr error
+		wantCode string
+	}{
+		{name: "unhealthy copies keep old version", flushErr: errors.New("worker copy is unhealthy"), wantCode: "old code"},
+		{name: "healthy copies switch version", wantCode: "new code"},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old := createTestWorker(t, "update-test-"+string(rune('a'+i)))
+			flushWorker = func(w *models.Worker) error { return tt.flushErr }
+
+			update := proto.Clone(old.Worker).(*entities.Worker)
+			update.Code = []byte("new code")
+			update.Template = `{"version":"2.0.0"}`
+			_, err := UpdateWorker(1, old.UID, update, "")
+			if !errors.Is(err, tt.flushErr) {
+				t.Fatalf("UpdateWorker error = %v, want %v", err, tt.flushErr)
+			}
+
+			stored, err := models.GetWorkerByUID(1, old.UID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(stored.Code) != tt.wantCode {
+				t.Errorf("stored code = %q, want %q", stored.Code, tt.wantCode)
+			}
+			if tt.flushErr != nil {
+				if stored.ActiveVersionID != old.ActiveVersionID || stored.Template != old.Template || stored.Version != old.Version {
+					t.Errorf("stored worker changed after failed deploy: version %s, template %s", stored.ActiveVersionID, stored.Template)
+				}
+				return
+			}
+			if stored.ActiveVersionID == old.ActiveVersionID {
+				t.Error("active version not switched after successful deploy")
+			}
+			version, err := models.GetWorkerVersion(old.UID, stored.ActiveVersionID)
+			if err != nil || string(version.Code) != "new code" {
+				t.Errorf("active version code = %v, err = %v", version, err)
+			}
+		})
+	}
+}
+
+func TestCreateWorkerTransaction(t *testing.T) {
+	setupWorkerdTest(t)
+	db := database.GetDB()
+	if err := db.Create(&models.Node{Node: &entities.Node{Name: "remote-node", UID: "remote-node"}}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		nodeName string
+		maxCount int32
+		wantErr  bool
+	}{
+		{name: "created with version", nodeName: "remote-node", maxCount: 1},
+		{name: "invalid max count", nodeName: "remote-node", maxCount: defs.WorkerSlotSize + 1, wantErr: true},
+		{name: "unknown node", nodeName: "missing-node", maxCount: 1, wantErr: true},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uid := "create-test-" + string(rune('a'+i))
+			worker := &models.Worker{Worker: &entities.Worker{
+				UID:      uid,
+				UserID:   1,
+				Name:     uid,
+				NodeName: tt.nodeName,
+				Entry:    defs.DefaultEntry,
+				Code:     []byte("code"),
+				MaxCount: tt.maxCount,
+			}}
+			err := createWorker(worker, worker.Code, "", true)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("createWorker error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			var workers, versions int64
+			db.Model(&models.Worker{}).Where("uid = ?", uid).Count(&workers)
+			db.Model(&models.WorkerVersion{}).Where("worker_id = ?", uid).Count(&versions)
+			if tt.wantErr {
+				if workers != 0 || versions != 0 {
+					t.Errorf("failed create left %d workers and %d versions", workers, versions)
+				}
+				return
+			}
+			stored, err := models.GetWorkerByUID(1, uid)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if versions != 1 || len(stored.ActiveVersionID) == 0 {
+				t.Errorf("versions = %d, active version = %q", versions, stored.ActiveVersionID)
+			}
+		})
+	}
+}
+
+func TestPromoteWorkerCanary(t *testing.T) {
+	setupWorkerdTest(t)
+
+	tests := []struct {
+		name     string
+		flushErr error
+	}{
+		{name: "failed deploy restores canary", flushErr: errors.New("worker copy is unhealthy")},
+		{name: "successful deploy removes canary"},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old := createTestWorker(t, "promote-test-"+string(rune('a'+i)))
+			next := proto.Clone(old.Worker).(*entities.Worker)
+			next.Code = []byte("canary code")
+			version, err := models.CreateWorkerVersion(database.GetDB(), next, next.Code, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			canary := &models.WorkerCanary{
+				WorkerUID: old.UID,
+				VersionID: version.UID,
+				Percent:   20,
+				Header:    "X-Canary",
+				MaxCount:  1,
+			}
+			if err := models.SaveWorkerCanary(canary); err != nil {
+				t.Fatal(err)
+			}
+
+			flushWorker = func(w *models.Worker) error {
+				// 部署期间全部流量由灰度副本承接
+				stored, err := models.GetWorkerCanary(w.UID)
+				if err != nil || stored.Percent != 100 || stored.Header != "" {
+					t.Errorf("canary during deploy = %+v, err = %v", stored, err)
+				}
+				return tt.flushErr
+			}
+			canaryFlushed := 0
+			flushCanary = func(w *models.Worker) error {
+				canaryFlushed++
+				return nil
+			}
+
+			oldVersionID := old.ActiveVersionID
+			_, _, err = promoteWorkerCanary(old, canary, version)
+			if !errors.Is(err, tt.flushErr) {
+				t.Fatalf("promoteWorkerCanary error = %v, want %v", err, tt.flushErr)
+			}
+
+			stored, err := models.GetWorkerByUID(1, old.UID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			restored, canaryErr := models.GetWorkerCanary(old.UID)
+			if tt.flushErr != nil {
+				if stored.ActiveVersionID != oldVersionID {
+					t.Errorf("active version switched after failed deploy")
+				}
+				if canaryErr != nil || restored.Percent != 20 || restored.Header != "X-Canary" {
+					t.Errorf("canary after failed deploy = %+v, err = %v", restored, canaryErr)
+				}
+				if canaryFlushed != 0 {
+					t.Errorf("canary copies flushed %d times after failed deploy", canaryFlushed)
+				}
+				return
+			}
+			if stored.ActiveVersionID != version.UID {
+				t.Errorf("active version = %s, want %s", stored.ActiveVersionID, version.UID)
+			}
+			if canaryErr == nil {
+				t.Errorf("canary not removed after successful deploy: %+v", restored)
+			}
+			if canaryFlushed != 1 {
+				t.Errorf("canary copies flushed %d times, want 1", canaryFlushed)
+			}
+		})
+	}
+}
@@ -0,0 +1,223 @@
+package workerd
+
+import (
+	"time"
+	"vvorker/common"
+	"vvorker/entities"
+	"vvorker/models"
+	"vvorker/utils/database"
+	permissions "vvorker/utils/permissions"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pmezard/go-difflib/difflib"
+	"gorm.io/gorm"
+)
+
+type ListWorkerVersionsReq struct {
+	WorkerUID string `json:"worker_uid" binding:"required"`
+	Page      int    `json:"page"`
+	PageSize  int    `json:"page_size"`
+}
+
+// WorkerVersionItem 版本列表项，不包含代码
+type WorkerVersionItem struct {
+	UID         string    `json:"uid"`
+	Name        string    `json:"name"`
+	Entry       string    `json:"entry"`
+	SemVersion  string    `json:"sem_version"`
+	Description string    `json:"description"`
+	UserID      uint64    `json:"user_id"`
+	CreatedAt   time.Time `json:"created_at"`
+	Active      bool      `json:"active"`
+}
+
+type ListWorkerVersionsResp struct {
+	Total    int64               `json:"total"`
+	Versions []WorkerVersionItem `json:"versions"`
+}
+
+func ListWorkerVersionsEndpoint(c *gin.Context) {
+	var req ListWorkerVersionsReq
+	if err := c.BindJSON(&req); err != nil {
+		return
+	}
+	if req.Page <= 0 {
+		req.Page = 1
+	}
+	if req.PageSize <= 0 {
+		req.PageSize = 20
+	}
+
+	userID, ok := common.RequireUID(c)
+	if !ok {
+		return
+	}
+	worker, err := permissions.CanReadWorker(c, userID, req.WorkerUID)
+	if err != nil {
+		// CanReadWorker 内部已经调用了 RespErr
+		return
+	}
+
+	db := database.GetDB()
+	query := db.Model(&models.WorkerVersion{}).Where(&models.WorkerVersion{WorkerID: req.WorkerUID})
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		common.RespErr(c, common.RespCodeInternalError, err.Error(), nil)
+		return
+	}
+	var versions []models.WorkerVersion
+	if err := query.Session(&gorm.Session{}).
+		Select("uid", "name", "entry", "sem_version", "description", "user_id", "created_at").
+		Order("id desc").Offset((req.Page - 1) * req.PageSize).Limit(req.PageSize).
+		Find(&versions).Error; err != nil {
+		common.RespErr(c, common.RespCodeInternalError, err.Error(), nil)
+		return
+	}
+
+	items := make([]WorkerVersionItem, 0, len(versions))
+	for _, v := range versions {
+		items = append(items, WorkerVersionItem{
+			UID:         v.UID,
+			Name:        v.Name,
+			Entry:       v.Entry,
+			SemVersion:  v.SemVersion,
+			Description: v.Description,
+			UserID:      v.UserID,
+			CreatedAt:   v.CreatedAt,
+			Active:      v.UID == worker.ActiveVersionID,
+		})
+	}
+	common.RespOK(c, "list worker versions success", ListWorkerVersionsResp{
+		Total:    total,
+		Versions: items,
+	})
+}
+
+type DiffWorkerVersionsReq struct {
+	WorkerUID string `json:"worker_uid" binding:"required"`
+	From      string `json:"from" binding:"required"` // 旧版本 UID
+	To        string `json:"to" binding:"required"`   // 新版本 UID
+}
+
+type DiffWorkerVersionsResp struct {
+	Entry    string `json:"entry"`    // 入口文件的 unified diff
+	Template string `json:"template"` // 模板的 unified diff
+	Code     string `json:"code"`     // 代码的 unified diff
+}
+
+func DiffWorkerVersionsEndpoint(c *gin.Context) {
+	var req DiffWorkerVersionsReq
+	if err := c.BindJSON(&req); err != nil {
+		return
+	}
+
+	userID, ok := common.RequireUID(c)
+	if !ok {
+		return
+	}
+	if _, err := permissions.CanReadWorker(c, userID, req.WorkerUID); err != nil {
+		// CanReadWorker 内部已经调用了 RespErr
+		return
+	}
+
+	from, err := models.GetWorkerVersion(req.WorkerUID, req.From)
+	if err != nil {
+		common.RespErr(c, common.RespCodeNotFound, "version not found", nil)
+		return
+	}
+	to, err := models.GetWorkerVersion(req.WorkerUID, req.To)
+	if err != nil {
+		common.RespErr(c, common.RespCodeNotFound, "version not found", nil)
+		return
+	}
+	fromCode, err := from.GetCode()
+	if err != nil {
+		common.RespErr(c, common.RespCodeInternalError, err.Error(), nil)
+		return
+	}
+	toCode, err := to.GetCode()
+	if err != nil {
+		common.RespErr(c, common.RespCodeInternalError, err.Error(), nil)
+		return
+	}
+
+	diff := func(name string, a, b string) string {
+		text, _ := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+			A:        difflib.SplitLines(a),
+			B:        difflib.SplitLines(b),
+			FromFile: from.Name + "/" + name,
+			ToFile:   to.Name + "/" + name,
+			Context:  3,
+		})
+		return text
+	}
+	common.RespOK(c, "diff worker versions success", DiffWorkerVersionsResp{
+		Entry:    diff("entry", from.Entry, to.Entry),
+		Template: diff("template", from.Template, to.Template),
+		Code:     diff(to.Entry, string(fromCode), string(toCode)),
+	})
+}
+
+type RollbackWorkerVersionReq struct {
+	WorkerUID  string `json:"worker_uid" binding:"required"`
+	VersionUID string `json:"version_uid" binding:"required"`
+}
+
+// RollbackWorkerVersionEndpoint 将 worker 切换到指定版本，复用更新流程，由 flush 通知到其他节点
+func RollbackWorkerVersionEndpoint(c *gin.Context) {
+	var req RollbackWorkerVersionReq
+	if err := c.BindJSON(&req); err != nil {
+		return
+	}
+
+	userID, ok := common.RequireUID(c)
+	if !ok {
+		return
+	}
+	oldworker, err := permissions.CanWriteWorker(c, userID, req.WorkerUID)
+	if err != nil {
+		return
+	}
+	if oldworker.ActiveVersionID == req.VersionUID {
+		common.RespErr(c, common.RespCodeInvalidRequest, "version is already active", nil)
+		return
+	}
+
+	version, err := models.GetWorkerVersion(req.WorkerUID, req.VersionUID)
+	if err != nil {
+		common.RespErr(c, common.RespCodeNotFound, "version not found", nil)
+		return
+	}
+
+	worker, traceID, err := switchWorkerVersion(oldworker, version)
+	if err != nil {
+		common.RespErr(c, common.RespCodeInternalError, err.Error(), nil)
+		return
+	}
+	common.RespOK(c, "rollback worker success", gin.H{
+		"version":    worker.Version,
+		"version_id": version.UID,
+		"task_id":    traceID,
+	})
+}
+
+// switchWorkerVersion 以指定版本的代码、入口和模板更新 worker
+func switchWorkerVersion(oldworker *models.Worker, version *models.WorkerVersion) (*entities.Worker, string, error) {
+	code, err := version.GetCode()
+	if err != nil {
+		return nil, "", err
+	}
+
+	worker := oldworker.ToEntity()
+	worker.Code = code
+	worker.Entry = version.Entry
+	worker.Template = version.Template
+	worker.SemVersion = version.SemVersion
+	worker.ActiveVersionID = version.UID
+
+	traceID, err := UpdateWorker(uint(oldworker.UserID), oldworker.UID, worker, oldworker.Description)
+	if err != nil {
+		return nil, traceID, err
+	}
+	return worker, traceID, nil
+}
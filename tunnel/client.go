@@ -17,6 +17,7 @@ type ClientHandler interface {
 	Run(ctx context.Context)
 	Add(clientID, routeHostname string, forwardPort int) error
 	AddWorker(workerID, routeHostname string, forwardPort int) error
+	AddCanaryWorker(workerID, routeHostname, hostRewrite string, forwardPort int) error
 	AddService(serviceName string, servicePort int) error
 	AddVisitor(servicename string, lcoalPort int) error
 	Delete(clientID string) error
@@ -134,6 +135,43 @@ func (c *Client) AddWorker(clientID, routeHostname string, forwardPort int) erro
 	return nil
 }
 
+// AddCanaryWorker implements ClientHandler.
+// 灰度副本注册在单独的子域名下，转发时将 Host 改回 worker 原本的域名
+func (c *Client) AddCanaryWorker(clientID, routeHostname, hostRewrite string, forwardPort int) error {
+	var newCfg v1.ProxyConfigurer = &v1.HTTPProxyConfig{
+		ProxyBaseConfig: v1.ProxyBaseConfig{
+			Name: clientID,
+			Type: "http",
+			ProxyBackend: v1.ProxyBackend{
+				LocalIP:   "127.0.0.1",
+				LocalPort: forwardPort,
+			},
+			LoadBalancer: v1.LoadBalancerConfig{
+				Group:    routeHostname,
+				GroupKey: routeHostname,
+			},
+		},
+		DomainConfig: v1.DomainConfig{
+			SubDomain: routeHostname,
+		},
+		HostHeaderRewrite: hostRewrite,
+	}
+	newCfg.Complete("")
+	if _, ok := c.proxyConf.LoadOrStore(clientID, newCfg); ok {
+		logger(context.Background(), "Client.AddCanaryWorker").Errorf("client %s already exists", clientID)
+		return nil
+	}
+
+	err := c.cli.UpdateAllConfigurer(lo.Values(c.proxyConf.ToMap()), lo.Values(c.visitorConf.ToMap()))
+	if err != nil {
+		logger(context.Background(), "Client.AddCanaryWorker").WithError(err).
+			Errorf("reload conf failed, config is: %+v", c.proxyConf.ToMap())
+		return err
+	}
+	logger(context.Background(), "Client.AddCanaryWorker").Infof("client %s added successfully, router: %s, port: %d", clientID, routeHostname, forwardPort)
+	return nil
+}
+
 // AddService implements ClientHandler.
 func (c *Client) AddService(serviceName string, servicePort int) error {
 	var newCfg v1.ProxyConfigurer = &v1.STCPProxyConfig{
@@ -23,3 +23,13 @@ func WorkerHost(workerName string) string {
 	suffix := strings.Trim(conf.AppConfigInstance.WorkerURLSuffix, ".")
 	return fmt.Sprintf("%s.%s", WorkerHostPrefix(workerName), suffix)
 }
+
+// WorkerCanaryHostPrefix 灰度副本在 frp 中注册的子域名
+func WorkerCanaryHostPrefix(workerName string) string {
+	return fmt.Sprintf("%s--canary", workerName)
+}
+
+func WorkerCanaryHost(workerName string) string {
+	suffix := strings.Trim(conf.AppConfigInstance.WorkerURLSuffix, ".")
+	return fmt.Sprintf("%s.%s", WorkerCanaryHostPrefix(workerName), suffix)
+}
@@ -0,0 +1,125 @@
+package blobstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"vvorker/conf"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	TypeDB    = "db"
+	TypeLocal = "local"
+	TypeOSS   = "oss"
+)
+
+var ErrInvalidHash = errors.New("invalid blob hash")
+
+// Store 按内容的 sha256 保存文件，相同的内容只保存一份
+type Store interface {
+	Put(ctx context.Context, hash string, r io.Reader, size int64) error
+	Open(ctx context.Context, hash string) (io.ReadCloser, error)
+	Exists(ctx context.Context, hash string) (bool, error)
+	Delete(ctx context.Context, hash string) error
+}
+
+var (
+	store     Store
+	storeOnce sync.Once
+)
+
+// Default 按配置创建的 blob 存储
+func Default() Store {
+	storeOnce.Do(func() {
+		switch StorageType() {
+		case TypeLocal:
+			store = &localStore{dir: localDir()}
+		case TypeOSS:
+			store = &ossStore{}
+		default:
+			store = &dbStore{}
+		}
+		logrus.Infof("blob storage: %s", StorageType())
+	})
+	return store
+}
+
+func StorageType() string {
+	switch conf.AppConfigInstance.BlobStorageType {
+	case TypeDB, TypeLocal, TypeOSS:
+		return conf.AppConfigInstance.BlobStorageType
+	}
+	if conf.AppConfigInstance.FileStorageUseOSS {
+		return TypeOSS
+	}
+	return TypeDB
+}
+
+// Put 边写入临时文件边计算哈希，再按哈希保存，内容已存在时不重复保存
+func Put(ctx context.Context, r io.Reader) (string, int64, error) {
+	tmp, err := os.CreateTemp("", "vvorker-blob-*")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, h), r)
+	if err != nil {
+		return "", 0, err
+	}
+	hash := hex.EncodeToString(h.Sum(nil))
+
+	if exists, err := Default().Exists(ctx, hash); err == nil && exists {
+		return hash, size, nil
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return "", 0, err
+	}
+	return hash, size, Default().Put(ctx, hash, tmp, size)
+}
+
+// PutBytes 保存已经在内存中的内容
+func PutBytes(ctx context.Context, data []byte) (string, error) {
+	hashBytes := sha256.Sum256(data)
+	hash := hex.EncodeToString(hashBytes[:])
+
+	if exists, err := Default().Exists(ctx, hash); err == nil && exists {
+		return hash, nil
+	}
+	return hash, Default().Put(ctx, hash, bytes.NewReader(data), int64(len(data)))
+}
+
+func Open(ctx context.Context, hash string) (io.ReadCloser, error) {
+	if !validHash(hash) {
+		return nil, ErrInvalidHash
+	}
+	return Default().Open(ctx, hash)
+}
+
+func Delete(ctx context.Context, hash string) error {
+	if !validHash(hash) {
+		return ErrInvalidHash
+	}
+	if err := Default().Delete(ctx, hash); err != nil {
+		return err
+	}
+	return deleteCache(hash)
+}
+
+// validHash 哈希会作为本地路径与对象名使用，只接受 sha256 的十六进制形式
+func validHash(hash string) bool {
+	if len(hash) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(hash)
+	return err == nil
+}
@@ -0,0 +1,131 @@
+package blobstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+	"vvorker/conf"
+	"vvorker/ext/kv/src/sys_cache"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 小文件在 sys_cache 中的缓存时间（秒）
+const memoryCacheTTL = 3600
+
+// File 可以随机读取的内容，用于支持 Range 请求
+type File interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+type bytesFile struct {
+	*bytes.Reader
+}
+
+func (f *bytesFile) Close() error {
+	return nil
+}
+
+// NewBytesFile 将内存中的内容包装为 File
+func NewBytesFile(data []byte) File {
+	return &bytesFile{bytes.NewReader(data)}
+}
+
+func memoryCacheKey(hash string) string {
+	return "blob:" + hash
+}
+
+func diskCacheDir() string {
+	return filepath.Join(conf.AppConfigInstance.WorkerdDir, "blob-cache")
+}
+
+func diskCachePath(hash string) string {
+	return filepath.Join(diskCacheDir(), hash)
+}
+
+// OpenCached 读取可以随机访问的内容
+// 不超过 BLOB_CACHE_MAX_SIZE 的内容缓存在 sys_cache 中，更大的内容缓存在本地磁盘，避免大文件进入 nutsdb
+func OpenCached(ctx context.Context, hash string) (File, error) {
+	if !validHash(hash) {
+		return nil, ErrInvalidHash
+	}
+	if s, ok := Default().(*localStore); ok {
+		return os.Open(s.path(hash))
+	}
+
+	if data, err := sys_cache.Get(memoryCacheKey(hash)); err == nil && len(data) != 0 {
+		return NewBytesFile(data), nil
+	}
+	p := diskCachePath(hash)
+	if f, err := os.Open(p); err == nil {
+		now := time.Now()
+		os.Chtimes(p, now, now)
+		return f, nil
+	}
+
+	rc, err := Default().Open(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	maxSize := int64(conf.AppConfigInstance.BlobCacheMaxSize)
+	head, err := io.ReadAll(io.LimitReader(rc, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(head)) <= maxSize {
+		if len(head) != 0 {
+			sys_cache.Put(memoryCacheKey(hash), head, memoryCacheTTL)
+		}
+		return NewBytesFile(head), nil
+	}
+
+	if err := writeFileAtomic(p, io.MultiReader(bytes.NewReader(head), rc)); err != nil {
+		return nil, err
+	}
+	return os.Open(p)
+}
+
+func deleteCache(hash string) error {
+	sys_cache.Del(memoryCacheKey(hash))
+	err := os.Remove(diskCachePath(hash))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// RunDiskCachePrune 定期清理长时间未访问的磁盘缓存
+func RunDiskCachePrune() {
+	ttl := time.Duration(conf.AppConfigInstance.BlobDiskCacheTTL) * time.Hour
+	if ttl <= 0 {
+		return
+	}
+	for {
+		time.Sleep(time.Hour)
+		pruneDiskCache(ttl)
+	}
+}
+
+func pruneDiskCache(ttl time.Duration) {
+	entries, err := os.ReadDir(diskCacheDir())
+	if err != nil {
+		return
+	}
+	before := time.Now().Add(-ttl)
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || info.IsDir() || info.ModTime().After(before) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(diskCacheDir(), e.Name())); err != nil {
+			logrus.WithError(err).Warnf("remove blob cache %s error", e.Name())
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package blobstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+	"vvorker/conf"
+	"vvorker/funcs"
+	"vvorker/utils/database"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Blob db 存储的内容，只适合较小的文件
+type Blob struct {
+	Hash      string `gorm:"primaryKey;size:64"`
+	Size      int64
+	Data      []byte
+	CreatedAt time.Time
+}
+
+func (b *Blob) TableName() string {
+	return "blobs"
+}
+
+type dbStore struct{}
+
+func (s *dbStore) Put(ctx context.Context, hash string, r io.Reader, size int64) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return database.GetDB().Clauses(clause.OnConflict{DoNothing: true}).Create(&Blob{
+		Hash: hash,
+		Size: int64(len(data)),
+		Data: data,
+	}).Error
+}
+
+func (s *dbStore) Open(ctx context.Context, hash string) (io.ReadCloser, error) {
+	var blob Blob
+	if err := database.GetDB().Where(&Blob{Hash: hash}).First(&blob).Error; err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(blob.Data)), nil
+}
+
+func (s *dbStore) Exists(ctx context.Context, hash string) (bool, error) {
+	count := int64(0)
+	if err := database.GetDB().Model(&Blob{}).Where(&Blob{Hash: hash}).Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (s *dbStore) Delete(ctx context.Context, hash string) error {
+	err := database.GetDB().Where(&Blob{Hash: hash}).Delete(&Blob{}).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	return err
+}
+
+// localStore 按哈希前两位分目录保存在本地磁盘
+type localStore struct {
+	dir string
+}
+
+func localDir() string {
+	if len(conf.AppConfigInstance.BlobStorageDir) != 0 {
+		return conf.AppConfigInstance.BlobStorageDir
+	}
+	return filepath.Join(conf.AppConfigInstance.WorkerdDir, "blobs")
+}
+
+func (s *localStore) path(hash string) string {
+	return filepath.Join(s.dir, hash[:2], hash)
+}
+
+func (s *localStore) Put(ctx context.Context, hash string, r io.Reader, size int64) error {
+	return writeFileAtomic(s.path(hash), r)
+}
+
+func (s *localStore) Open(ctx context.Context, hash string) (io.ReadCloser, error) {
+	return os.Open(s.path(hash))
+}
+
+func (s *localStore) Exists(ctx context.Context, hash string) (bool, error) {
+	_, err := os.Stat(s.path(hash))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *localStore) Delete(ctx context.Context, hash string) error {
+	err := os.Remove(s.path(hash))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// ossStore 保存在系统 bucket 的 blobs/ 目录下
+type ossStore struct{}
+
+func ossKey(hash string) string {
+	return "blobs/" + hash
+}
+
+func (s *ossStore) Put(ctx context.Context, hash string, r io.Reader, size int64) error {
+	return funcs.UploadFileToSysBucket(ossKey(hash), r)
+}
+
+func (s *ossStore) Open(ctx context.Context, hash string) (io.ReadCloser, error) {
+	return funcs.DownloadFileFromSysBucket(ossKey(hash))
+}
+
+// Exists 部分 OSS 客户端在读取时才返回对象不存在的错误，因此读取一个字节判断
+func (s *ossStore) Exists(ctx context.Context, hash string) (bool, error) {
+	rc, err := funcs.DownloadFileFromSysBucket(ossKey(hash))
+	if err != nil {
+		return false, nil
+	}
+	defer rc.Close()
+	if _, err := rc.Read(make([]byte, 1)); err != nil && !errors.Is(err, io.EOF) {
+		return false, nil
+	}
+	return true, nil
+}
+
+func (s *ossStore) Delete(ctx context.Context, hash string) error {
+	return funcs.DeleteFileFromSysBucket(ossKey(hash))
+}
+
+// writeFileAtomic 先写入同目录的临时文件再重命名，读取方不会看到写了一半的文件
+func writeFileAtomic(p string, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
@@ -35,14 +35,14 @@ func WriteFile(path string, content string) error {
 
 func CreateTarFromZip(zipReader *zip.Reader) ([]byte, error) {
 	var tarBuffer bytes.Buffer
-	err := writeTarArchive(&tarBuffer, zipReader)
+	err := WriteTarArchive(&tarBuffer, zipReader)
 	if err != nil {
 		return nil, err
 	}
 	return tarBuffer.Bytes(), nil
 }
 
-func writeTarArchive(w io.Writer, zipReader *zip.Reader) error {
+func WriteTarArchive(w io.Writer, zipReader *zip.Reader) error {
 	tarWriter := tar.NewWriter(w)
 	defer tarWriter.Close()
 
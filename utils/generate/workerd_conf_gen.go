@@ -18,6 +18,7 @@ import (
 	workercopy "vvorker/models/worker_copy"
 	"vvorker/utils"
 	"vvorker/utils/database"
+	"vvorker/utils/secret"
 
 	"github.com/imroc/req/v3"
 	"github.com/sirupsen/logrus"
@@ -55,6 +56,16 @@ func writeFileIfNotExists(filePath string, content string) error {
 	return nil
 }
 
+// resourceToken 绑定中的资源凭证，只能访问当前 worker 版本配置中的这个绑定
+func resourceToken(worker *entities.Worker, resourceType string, binding string) string {
+	return secret.NewResourceToken(conf.AppConfigInstance.AgentSecret, secret.ResourceScope{
+		WorkerUID: worker.GetUID(),
+		VersionID: worker.GetActiveVersionID(),
+		Type:      resourceType,
+		Binding:   binding,
+	})
+}
+
 func RPCWrapper() *req.Request {
 	return req.C().R().
 		SetHeaders(map[string]string{
@@ -63,7 +74,8 @@ func RPCWrapper() *req.Request {
 		})
 }
 
-func FillWorkerConfig(endpoint string, UID string) (string, error) {
+// FillWorkerConfig 由 master 补全 worker 的配置，versionID 不为空时使用该版本的模板
+func FillWorkerConfig(endpoint string, UID string, versionID string) (string, error) {
 	url := endpoint + "/api/agent/fill-worker-config"
 
 	rtype := struct {
@@ -74,7 +86,8 @@ func FillWorkerConfig(endpoint string, UID string) (string, error) {
 
 	reqResp, err := RPCWrapper().
 		SetBody(&entities.AgentFillWorkerReq{
-			UID: UID,
+			UID:       UID,
+			VersionID: versionID,
 		}).
 		SetSuccessResult(&rtype).
 		Post(url)
@@ -95,7 +108,7 @@ func BuildCapfile(workers []*entities.Worker, workerQuery funcs.WorkerQuery) map
 		writer := new(bytes.Buffer)
 		capTemplate := template.New("capfile")
 		workerTemplate := defs.DefaultTemplate
-		newTemplate, ferr := FillWorkerConfig(conf.AppConfigInstance.MasterEndpoint, worker.GetUID())
+		newTemplate, ferr := FillWorkerConfig(conf.AppConfigInstance.MasterEndpoint, worker.GetUID(), worker.GetActiveVersionID())
 		if ferr != nil {
 			logrus.Warnf("new workerconfig error: %v", ferr)
 		}
@@ -184,7 +197,7 @@ func BuildCapfile(workers []*entities.Worker, workerQuery funcs.WorkerQuery) map
 	( name = "USER", text = "`+ext.User+`" ),
 	( name = "PASSWORD", text = "`+ext.Password+`" ),
 	( name = "DATABASE", text = "`+ext.Database+`" ),
-	( name = "X_SECRET" , text = "`+conf.RPCToken+`" ),
+	( name = "RESOURCE_TOKEN", text = "`+resourceToken(worker, extName, ext.Binding)+`" ),
 	( name = "X_NODENAME", text = "`+conf.AppConfigInstance.NodeName+`" ),
 	( name = "MASTER_ENDPOINT", text = "http://127.0.0.1:`+strconv.Itoa(conf.AppConfigInstance.APIPort)+`" ),`))
 					workerTemplate = workerTemplate + allowExtension.ExtensionTemplate
@@ -223,7 +236,7 @@ func BuildCapfile(workers []*entities.Worker, workerQuery funcs.WorkerQuery) map
 	( name = "USER", text = "`+ext.User+`" ),
 	( name = "PASSWORD", text = "`+ext.Password+`" ),
 	( name = "DATABASE", text = "`+ext.Database+`" ),
-	( name = "X_SECRET" , text = "`+conf.RPCToken+`" ),
+	( name = "RESOURCE_TOKEN", text = "`+resourceToken(worker, extName, ext.Binding)+`" ),
 	( name = "X_NODENAME", text = "`+conf.AppConfigInstance.NodeName+`" ),
 	( name = "MASTER_ENDPOINT", text = "http://127.0.0.1:`+strconv.Itoa(conf.AppConfigInstance.APIPort)+`" ),`))
 					workerTemplate = workerTemplate + allowExtension.ExtensionTemplate
@@ -262,8 +275,8 @@ func BuildCapfile(workers []*entities.Worker, workerQuery funcs.WorkerQuery) map
 	( name = "PORT", text = "`+strconv.Itoa(ext.Port)+`" ),	
 	( name = "RESOURCE_ID", text = "`+ext.ResourceID+`" ),
 	( name = "KVPROVIDER", text = "`+ext.Provider+`" ),
-	( name = "MASTER_ENDPOINT", text = "`+conf.AppConfigInstance.MasterEndpoint+`" ),
-	( name = "X_SECRET", text = "`+conf.RPCToken+`" ),
+	( name = "MASTER_ENDPOINT", text = "http://127.0.0.1:`+strconv.Itoa(conf.AppConfigInstance.APIPort)+`" ),
+	( name = "RESOURCE_TOKEN", text = "`+resourceToken(worker, extName, ext.Binding)+`" ),
 	( name = "X_NODENAME", text = "`+conf.AppConfigInstance.NodeName+`" ),
 `))
 					workerTemplate = workerTemplate + allowExtension.ExtensionTemplate
@@ -305,7 +318,7 @@ func BuildCapfile(workers []*entities.Worker, workerQuery funcs.WorkerQuery) map
 	( name = "REGION", text = "`+ext.Region+`" ),
 	( name = "OSS_AGENT_URL", text = "`+ossAgentUrl+`" ),
 	( name = "RESOURCE_ID", text = "`+ext.ResourceID+`" ),
-	( name = "X_SECRET" , text = "`+conf.RPCToken+`" ),
+	( name = "RESOURCE_TOKEN", text = "`+resourceToken(worker, extName, ext.Binding)+`" ),
 	( name = "X_NODENAME", text = "`+conf.AppConfigInstance.NodeName+`" ),
 `))
 					workerTemplate = workerTemplate + allowExtension.ExtensionTemplate
@@ -336,8 +349,9 @@ func BuildCapfile(workers []*entities.Worker, workerQuery funcs.WorkerQuery) map
 					allowExtension := allowExtensionFn(ext.Binding, template.HTML(`
 	( name = "WORKER_UID", text = "`+worker.UID+`" ),
 	( name = "MASTER_ENDPOINT", text = "`+conf.AppConfigInstance.MasterEndpoint+`" ),
-	( name = "X_SECRET" , text = "`+conf.RPCToken+`" ),
+	( name = "RESOURCE_TOKEN", text = "`+resourceToken(worker, extName, ext.Binding)+`" ),
 	( name = "X_NODENAME", text = "`+conf.AppConfigInstance.NodeName+`" ),
+	( name = "ASSETS_BINDING", text = "`+ext.Binding+`" ),
 `))
 					workerTemplate = workerTemplate + allowExtension.ExtensionTemplate
 					bindingsText = bindingsText + allowExtension.BindingTemplate
@@ -367,7 +381,7 @@ func BuildCapfile(workers []*entities.Worker, workerQuery funcs.WorkerQuery) map
 					allowExtension := allowExtensionFn(ext.Binding, template.HTML(`
 	( name = "WORKER_UID", text = "`+worker.UID+`" ),
 	( name = "MASTER_ENDPOINT", text = "`+conf.AppConfigInstance.MasterEndpoint+`" ),
-	( name = "X_SECRET" , text = "`+conf.RPCToken+`" ),
+	( name = "RESOURCE_TOKEN", text = "`+resourceToken(worker, extName, ext.Binding)+`" ),
 	( name = "X_NODENAME", text = "`+conf.AppConfigInstance.NodeName+`" ),
 `))
 					workerTemplate = workerTemplate + allowExtension.ExtensionTemplate
@@ -468,45 +482,48 @@ func GenWorkerConfig(worker *entities.Worker, workerQuery funcs.WorkerQuery) err
 	}
 	db := database.GetDB()
 	copies := []workercopy.WorkerCopy{}
-	db.Where(&workercopy.WorkerCopy{WorkerUID: worker.GetUID()}).Find(&copies)
+	db.Scopes(workercopy.Primary).Where(&workercopy.WorkerCopy{WorkerUID: worker.GetUID()}).Find(&copies)
 
 	for _, copy := range copies {
-		newworker := &entities.Worker{
-			UID:             worker.GetUID(),
-			LocalID:         int32(copy.LocalID),
-			Name:            worker.Name,
-			Template:        worker.Template,
-			ControlPort:     int32(copy.ControlPort),
-			Version:         worker.Version,
-			ActiveVersionID: worker.ActiveVersionID,
-			MaxCount:        worker.MaxCount,
-			UserID:          worker.UserID,
-			ExternalPath:    worker.ExternalPath,
-			HostName:        worker.HostName,
-			NodeName:        worker.NodeName,
-			Port:            int32(copy.Port),
-			Entry:           worker.Entry,
-			Code:            worker.Code,
-			TunnelID:        worker.TunnelID,
-		}
-		fileMap := BuildCapfile([]*entities.Worker{
-			newworker,
-		}, workerQuery)
-
-		fileContent, ok := fileMap[newworker.GetUID()]
-		if !ok {
-			return errors.New("BuildCapfile error")
-		}
-
-		if err := utils.WriteFile(
-			filepath.Join(
-				conf.AppConfigInstance.WorkerdDir,
-				defs.WorkerInfoPath,
-				worker.GetUID(),
-				defs.CapFileName+"-"+strconv.Itoa(int(newworker.GetLocalID())),
-			), fileContent); err != nil {
+		if err := GenWorkerCopyConfig(worker, &copy, workerQuery); err != nil {
 			return err
 		}
 	}
 	return nil
 }
+
+// GenWorkerCopyConfig 为单个副本生成 capfile，灰度副本的 worker 需要带上灰度版本的入口和模板
+func GenWorkerCopyConfig(worker *entities.Worker, copy *workercopy.WorkerCopy, workerQuery funcs.WorkerQuery) error {
+	newworker := &entities.Worker{
+		UID:             worker.GetUID(),
+		LocalID:         int32(copy.LocalID),
+		Name:            worker.Name,
+		Template:        worker.Template,
+		ControlPort:     int32(copy.ControlPort),
+		Version:         worker.Version,
+		ActiveVersionID: worker.ActiveVersionID,
+		MaxCount:        worker.MaxCount,
+		UserID:          worker.UserID,
+		ExternalPath:    worker.ExternalPath,
+		HostName:        worker.HostName,
+		NodeName:        worker.NodeName,
+		Port:            int32(copy.Port),
+		Entry:           worker.Entry,
+		Code:            worker.Code,
+		TunnelID:        worker.TunnelID,
+	}
+	fileMap := BuildCapfile([]*entities.Worker{
+		newworker,
+	}, workerQuery)
+
+	fileContent, ok := fileMap[newworker.GetUID()]
+	if !ok {
+		return errors.New("BuildCapfile error")
+	}
+
+	return utils.WriteFile(
+		filepath.Join(
+			copy.Dir(),
+			defs.CapFileName+"-"+strconv.Itoa(int(newworker.GetLocalID())),
+		), fileContent)
+}
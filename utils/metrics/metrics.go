@@ -0,0 +1,90 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "vvorker"
+
+var (
+	// worker 代理请求数，按状态码分类
+	ProxyRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "proxy",
+		Name:      "requests_total",
+		Help:      "Total number of requests proxied to workers.",
+	}, []string{"worker_uid", "status_class"})
+
+	// worker 代理请求耗时
+	ProxyRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "proxy",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of requests proxied to workers.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"worker_uid"})
+
+	// workerd 副本重启次数，copy 为 WorkerUID-LocalID
+	WorkerCopyRestarts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "worker",
+		Name:      "copy_restarts_total",
+		Help:      "Total number of workerd copy restarts.",
+	}, []string{"copy"})
+
+	// 定时任务触发次数
+	SchedulerJobFires = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "scheduler",
+		Name:      "job_fires_total",
+		Help:      "Total number of scheduler job fires.",
+	}, []string{"worker_uid", "name"})
+
+	// 日志队列满时丢弃的条数
+	LogQueueDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "log_queue",
+		Name:      "dropped_total",
+		Help:      "Total number of log entries dropped because the queue was full.",
+	}, []string{"queue"})
+
+	// /api/ext/* 接口调用次数
+	ExtCalls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "ext",
+		Name:      "calls_total",
+		Help:      "Total number of calls to ext handlers.",
+	}, []string{"handler"})
+
+	// /api/ext/* 接口调用失败次数
+	ExtCallErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "ext",
+		Name:      "call_errors_total",
+		Help:      "Total number of failed calls to ext handlers.",
+	}, []string{"handler"})
+)
+
+// RegisterLogQueueLength 注册日志队列长度，采集时回调 fn 读取当前长度
+func RegisterLogQueueLength(queue string, fn func() float64) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Subsystem:   "log_queue",
+		Name:        "length",
+		Help:        "Current number of log entries waiting in the queue.",
+		ConstLabels: prometheus.Labels{"queue": queue},
+	}, fn)
+}
+
+// MustRegister 注册自定义的 collector，如 worker 副本运行状态
+func MustRegister(cs ...prometheus.Collector) {
+	prometheus.MustRegister(cs...)
+}
+
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
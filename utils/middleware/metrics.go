@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"vvorker/common"
+	"vvorker/conf"
+	"vvorker/utils/metrics"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ExtMetricsMiddleware 统计 /api/ext/* 各接口的调用次数和失败次数
+// handler 标签取路由中 /ext/ 之后的部分，如 kv/invoke、pgsql/query
+func ExtMetricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		fullPath := c.FullPath()
+		if fullPath == "" {
+			return
+		}
+		handler := fullPath
+		if idx := strings.Index(fullPath, "/ext/"); idx >= 0 {
+			handler = fullPath[idx+len("/ext/"):]
+		}
+
+		metrics.ExtCalls.WithLabelValues(handler).Inc()
+		if _, failed := c.Get(common.RespErrCodeKey); failed || c.Writer.Status() >= http.StatusBadRequest {
+			metrics.ExtCallErrors.WithLabelValues(handler).Inc()
+		}
+	}
+}
+
+// MetricsEndpoint 暴露 prometheus 指标，需要携带 METRICS_TOKEN 作为 Bearer Token
+func MetricsEndpoint() gin.HandlerFunc {
+	h := metrics.Handler()
+	return func(c *gin.Context) {
+		token := conf.AppConfigInstance.MetricsToken
+		auth := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if token == "" || subtle.ConstantTimeCompare([]byte(auth), []byte(token)) != 1 {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(c.Writer, c.Request)
+	}
+}
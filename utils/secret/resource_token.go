@@ -0,0 +1,55 @@
+package secret
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+var ErrInvalidResourceToken = errors.New("invalid resource token")
+
+// ResourceScope 资源凭证的授权范围，一个凭证只能访问一个 worker 版本中的一个绑定
+type ResourceScope struct {
+	WorkerUID string `json:"w"`
+	VersionID string `json:"v"`
+	Type      string `json:"t"` // kv、pgsql、mysql、oss
+	Binding   string `json:"b"`
+}
+
+func resourceTokenKey(agentSecret string) []byte {
+	sum := sha256.Sum256([]byte("resource-token:" + agentSecret))
+	return sum[:]
+}
+
+func signResourceToken(agentSecret string, payload string) string {
+	mac := hmac.New(sha256.New, resourceTokenKey(agentSecret))
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// NewResourceToken 生成写入绑定的资源凭证，各节点使用相同的 AgentSecret 签名，任意节点都可以校验
+func NewResourceToken(agentSecret string, scope ResourceScope) string {
+	data, _ := json.Marshal(scope)
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + signResourceToken(agentSecret, payload)
+}
+
+// ParseResourceToken 校验签名并返回授权范围
+func ParseResourceToken(agentSecret string, token string) (*ResourceScope, error) {
+	payload, sig, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(signResourceToken(agentSecret, payload))) {
+		return nil, ErrInvalidResourceToken
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, ErrInvalidResourceToken
+	}
+	scope := &ResourceScope{}
+	if err := json.Unmarshal(data, scope); err != nil || scope.WorkerUID == "" || scope.Type == "" {
+		return nil, ErrInvalidResourceToken
+	}
+	return scope, nil
+}
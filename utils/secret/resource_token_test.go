@@ -0,0 +1,31 @@
+package secret
+
+import "testing"
+
+func TestResourceToken(t *testing.T) {
+	scope := ResourceScope{WorkerUID: "w1", VersionID: "v1", Type: "kv", Binding: "kv"}
+	token := NewResourceToken("secret", scope)
+
+	tests := []struct {
+		name    string
+		secret  string
+		token   string
+		wantErr bool
+	}{
+		{name: "valid", secret: "secret", token: token},
+		{name: "wrong secret", secret: "other", token: token, wantErr: true},
+		{name: "tampered", secret: "secret", token: "x" + token, wantErr: true},
+		{name: "no signature", secret: "secret", token: "abc", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseResourceToken(tt.secret, tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseResourceToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && *got != scope {
+				t.Errorf("ParseResourceToken() = %v, want %v", *got, scope)
+			}
+		})
+	}
+}